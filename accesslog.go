@@ -0,0 +1,207 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// AccessRecord is the access log record of a request.
+type AccessRecord struct {
+	// Time is when the request was received.
+	Time time.Time
+
+	// Method is the method name of the request.
+	Method string
+
+	// ID is the id of a call.
+	ID ID
+
+	// IsCall reports whether the request is a call.
+	IsCall bool
+
+	// Duration is the time elapsed until the call was replied to, or until the
+	// handler of a notification returned.
+	Duration time.Duration
+
+	// Bytes is the size of the request message, as reported by ReceivedBytes.
+	Bytes int64
+
+	// Code is the error code of a failed request.
+	Code Code
+
+	// Err is the error message of a failed request.
+	Err string
+}
+
+// AccessLogFormat writes r to w as a single line.
+type AccessLogFormat func(w io.Writer, r AccessRecord) error
+
+// JSONAccessLog is an AccessLogFormat writing records as JSON objects.
+func JSONAccessLog(w io.Writer, r AccessRecord) error {
+	record := struct {
+		Time     time.Time `json:"time"`
+		Method   string    `json:"method"`
+		ID       *ID       `json:"id,omitempty"`
+		Duration float64   `json:"duration_ms"`
+		Bytes    int64     `json:"bytes"`
+		Code     Code      `json:"code,omitempty"`
+		Err      string    `json:"error,omitempty"`
+	}{
+		Time:     r.Time,
+		Method:   r.Method,
+		Duration: float64(r.Duration) / float64(time.Millisecond),
+		Bytes:    r.Bytes,
+		Code:     r.Code,
+		Err:      r.Err,
+	}
+	if r.IsCall {
+		record.ID = &r.ID
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("marshaling access record: %w", err)
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// CommonAccessLog is an AccessLogFormat writing records in a format close to
+// the common log format of HTTP servers:
+//
+//	2021-06-01T12:00:00Z textDocument/hover #3 0 1.2ms 245
+func CommonAccessLog(w io.Writer, r AccessRecord) error {
+	id := "-"
+	if r.IsCall {
+		id = fmt.Sprintf("%q", r.ID)
+	}
+	_, err := fmt.Fprintf(w, "%s %s %s %d %s %d\n", r.Time.Format(time.RFC3339), r.Method, id, r.Code, r.Duration, r.Bytes)
+	return err
+}
+
+// AccessLog writes a record of every request passed to its Handler.
+type AccessLog struct {
+	mu       sync.Mutex // serializes writes and protects sampling
+	w        io.Writer
+	format   AccessLogFormat
+	sampling map[string]*sampler
+	disabled int32 // access atomically
+}
+
+// sampler keeps a fraction of the records of a method.
+type sampler struct {
+	rate float64
+	n    uint64
+}
+
+// keep reports whether the next record must be kept.
+func (s *sampler) keep() bool {
+	s.n++
+	return uint64(float64(s.n)*s.rate) != uint64(float64(s.n-1)*s.rate)
+}
+
+// NewAccessLog returns an enabled AccessLog writing records to w in format.
+func NewAccessLog(w io.Writer, format AccessLogFormat) *AccessLog {
+	return &AccessLog{
+		w:        w,
+		format:   format,
+		sampling: make(map[string]*sampler),
+	}
+}
+
+// SetSampling makes the access log keep only a fraction rate of the records
+// of successful requests for method, such as 0.01 for one in a hundred.
+//
+// Failed requests are always recorded. A rate of 1 or more disables sampling
+// for method.
+func (l *AccessLog) SetSampling(method string, rate float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if rate >= 1 {
+		delete(l.sampling, method)
+		return
+	}
+	l.sampling[method] = &sampler{rate: rate}
+}
+
+// SetEnabled turns the access log on or off at runtime.
+func (l *AccessLog) SetEnabled(enabled bool) {
+	var disabled int32
+	if !enabled {
+		disabled = 1
+	}
+	atomic.StoreInt32(&l.disabled, disabled)
+}
+
+// Handler returns a handler that records the requests passed to handler.
+//
+// Calls are recorded when they are replied to, or when handler returns an
+// error without replying. Notifications are recorded when handler returns.
+// Errors writing the log are ignored.
+func (l *AccessLog) Handler(handler Handler) (h Handler) {
+	h = Handler(func(ctx context.Context, reply Replier, req Request) error {
+		if atomic.LoadInt32(&l.disabled) == 1 {
+			return handler(ctx, reply, req)
+		}
+
+		r := AccessRecord{
+			Time:   time.Now(),
+			Method: req.Method(),
+			Bytes:  ReceivedBytes(ctx),
+		}
+		call, isCall := req.(*Call)
+		if isCall {
+			r.ID, r.IsCall = call.ID(), true
+		}
+
+		var once sync.Once
+		record := func(err error) {
+			once.Do(func() { l.write(r, err) })
+		}
+
+		innerReply := reply
+		reply = func(ctx context.Context, result interface{}, err error) error {
+			if isCall {
+				record(err)
+			}
+			return innerReply(ctx, result, err)
+		}
+		err := handler(ctx, reply, req)
+		if !isCall || err != nil {
+			record(err)
+		}
+		return err
+	})
+
+	return h
+}
+
+// write writes the record of a request that ended with err.
+func (l *AccessLog) write(r AccessRecord, err error) {
+	r.Duration = time.Since(r.Time)
+	if err != nil {
+		r.Err = err.Error()
+		var werr *Error
+		if errors.As(err, &werr) {
+			r.Code = werr.Code
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if s, ok := l.sampling[r.Method]; ok && err == nil && !s.keep() {
+		return
+	}
+	l.format(l.w, r)
+}
@@ -0,0 +1,117 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+func TestAccessLog(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	call, err := jsonrpc2.NewCall(jsonrpc2.NewNumberID(3), "hover", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notify, err := jsonrpc2.NewNotification("didChange", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		format jsonrpc2.AccessLogFormat
+		want   []string
+	}{
+		"json": {
+			format: jsonrpc2.JSONAccessLog,
+			want: []string{
+				`"method":"hover","id":3,`,
+				`"code":-32601,"error":`,
+				`"method":"didChange","duration_ms":`,
+			},
+		},
+		"common": {
+			format: jsonrpc2.CommonAccessLog,
+			want: []string{
+				` hover #3 -32601 `,
+				` didChange - 0 `,
+			},
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+			log := jsonrpc2.NewAccessLog(&buf, tt.format)
+			h := log.Handler(jsonrpc2.MethodNotFoundHandler)
+
+			if err := h(ctx, noopReplier, call); err != nil {
+				t.Fatal(err)
+			}
+			if err := h(ctx, noopReplier, notify); err != nil {
+				t.Fatal(err)
+			}
+			log.SetEnabled(false)
+			if err := h(ctx, noopReplier, call); err != nil {
+				t.Fatal(err)
+			}
+
+			got := buf.String()
+			if n := strings.Count(got, "\n"); n != 2 {
+				t.Errorf("got %d records, want 2:\n%s", n, got)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("got:\n%s\nwant it to contain %s", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	notify, err := jsonrpc2.NewNotification("didChange", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	call, err := jsonrpc2.NewCall(jsonrpc2.NewNumberID(1), "didChange", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	log := jsonrpc2.NewAccessLog(&buf, jsonrpc2.CommonAccessLog)
+	log.SetSampling("didChange", 0.1)
+	h := log.Handler(jsonrpc2.MethodNotFoundHandler)
+
+	for i := 0; i < 100; i++ {
+		if err := h(ctx, noopReplier, notify); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := strings.Count(buf.String(), "\n"); got != 10 {
+		t.Errorf("got %d sampled records, want 10", got)
+	}
+
+	buf.Reset()
+	for i := 0; i < 5; i++ {
+		if err := h(ctx, noopReplier, call); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := strings.Count(buf.String(), "\n"); got != 5 {
+		t.Errorf("got %d failed records, want all 5", got)
+	}
+}
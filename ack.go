@@ -0,0 +1,75 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// AckParams is the params of the MethodAckNotify call, carrying the
+// notification to acknowledge.
+type AckParams struct {
+	// Method is the method of the notification.
+	Method string `json:"method"`
+
+	// Params is the params of the notification.
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// NotifyAcked sends a notification the peer acknowledges once its handler
+// processed it, for critical notifications such as state synchronization.
+//
+// The notification is sent as a MethodAckNotify call, answered by an
+// AckHandler. A peer without an AckHandler replies to the reserved method with
+// the standard method not found response, in which case the notification is
+// sent again as a plain one, and acked is false.
+func NotifyAcked(ctx context.Context, c Conn, method string, params interface{}) (acked bool, err error) {
+	raw, err := marshalInterface(params)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = c.Call(ctx, MethodAckNotify, AckParams{Method: method, Params: raw}, nil)
+	var werr *Error
+	if errors.As(err, &werr) && werr.Code == MethodNotFound {
+		return false, c.Notify(ctx, method, raw)
+	}
+	if err != nil {
+		return false, fmt.Errorf("%q: %w", method, err)
+	}
+	return true, nil
+}
+
+// AckHandler returns a handler that passes the notifications sent by
+// NotifyAcked to handler, and acknowledges them once handler returns, or
+// replies with its error. Other requests are passed to handler unchanged.
+//
+// The acknowledgment reports that handler returned, so a handler processing
+// notifications asynchronously, such as an AsyncHandler, acknowledges them
+// once they are started.
+//
+// MethodAckNotify is a reserved method, so the handler must wrap a
+// ReservedHandler rather than be wrapped by one.
+func AckHandler(handler Handler) (h Handler) {
+	h = Handler(func(ctx context.Context, reply Replier, req Request) error {
+		if req.Method() != MethodAckNotify {
+			return handler(ctx, reply, req)
+		}
+
+		var params AckParams
+		if err := json.Unmarshal(req.Params(), &params); err != nil {
+			return reply(ctx, nil, fmt.Errorf("%s params: %v: %w", req.Method(), err, ErrInvalidParams))
+		}
+		notify := &Notification{method: params.Method, params: params.Params}
+		noReply := func(ctx context.Context, result interface{}, err error) error { return nil }
+
+		return reply(ctx, nil, handler(ctx, noReply, notify))
+	})
+
+	return h
+}
@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"context"
+	"testing"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+func TestNotifyAcked(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		wrap      func(jsonrpc2.Handler) jsonrpc2.Handler
+		wantAcked bool
+	}{
+		"ack handler": {
+			wrap: func(h jsonrpc2.Handler) jsonrpc2.Handler {
+				return jsonrpc2.AckHandler(jsonrpc2.ReservedHandler(h, nil))
+			},
+			wantAcked: true,
+		},
+		"plain peer": {
+			wrap: func(h jsonrpc2.Handler) jsonrpc2.Handler {
+				return jsonrpc2.ReservedHandler(h, nil)
+			},
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			got := make(chan string, 1)
+			handler := func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+				if _, ok := req.(*jsonrpc2.Notification); ok {
+					got <- req.Method() + " " + string(req.Params())
+				}
+				return reply(ctx, nil, nil)
+			}
+
+			a, b := pipeConns(t)
+			a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+			b.Go(ctx, tt.wrap(handler))
+
+			acked, err := jsonrpc2.NotifyAcked(ctx, a, "sync", []int{1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if acked != tt.wantAcked {
+				t.Errorf("got acked %t, want %t", acked, tt.wantAcked)
+			}
+			if msg := <-got; msg != "sync [1]" {
+				t.Errorf("got notification %s, want sync [1]", msg)
+			}
+		})
+	}
+}
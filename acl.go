@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import (
+	"context"
+	"fmt"
+	"path"
+)
+
+// MethodPolicy is a declarative access control list of methods.
+//
+// Patterns use the syntax of path.Match, so that "textDocument/*" matches
+// every method of the textDocument namespace.
+type MethodPolicy struct {
+	// Allow is the list of method patterns that are allowed.
+	Allow []string
+
+	// Deny is the list of method patterns that are denied, it takes precedence
+	// over Allow.
+	Deny []string
+
+	// DefaultAllow is the action for methods that match no pattern.
+	DefaultAllow bool
+
+	// Err is the error denied calls are replied with. If nil, they are replied
+	// with ErrMethodNotFound, so that denied methods look like missing ones.
+	Err error
+}
+
+// Allowed reports whether method is allowed by p.
+func (p *MethodPolicy) Allowed(method string) bool {
+	if matchAny(p.Deny, method) {
+		return false
+	}
+	if matchAny(p.Allow, method) {
+		return true
+	}
+	return p.DefaultAllow
+}
+
+// matchAny reports whether name matches any of patterns.
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// PolicyHandler returns a handler that passes the requests for methods
+// allowed by policy to handler, and rejects the others.
+//
+// Denied calls are replied to with the policy error, denied notifications are
+// dropped. PolicyHandler panics if policy contains a malformed pattern.
+func PolicyHandler(handler Handler, policy MethodPolicy) (h Handler) {
+	for _, pattern := range append(append([]string(nil), policy.Allow...), policy.Deny...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			panic(fmt.Errorf("method pattern %q: %w", pattern, err))
+		}
+	}
+
+	h = Handler(func(ctx context.Context, reply Replier, req Request) error {
+		if policy.Allowed(req.Method()) {
+			return handler(ctx, reply, req)
+		}
+
+		err := policy.Err
+		if err == nil {
+			err = fmt.Errorf("%q: %w", req.Method(), ErrMethodNotFound)
+		}
+		return reply(ctx, nil, err)
+	})
+
+	return h
+}
@@ -0,0 +1,74 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+func TestPolicyHandler(t *testing.T) {
+	t.Parallel()
+
+	errDenied := errors.New("denied")
+	tests := map[string]struct {
+		policy  jsonrpc2.MethodPolicy
+		method  string
+		wantErr error
+	}{
+		"allowed": {
+			policy: jsonrpc2.MethodPolicy{Allow: []string{"textDocument/*"}},
+			method: "textDocument/hover",
+		},
+		"deniedByDefault": {
+			policy:  jsonrpc2.MethodPolicy{Allow: []string{"textDocument/*"}},
+			method:  "workspace/executeCommand",
+			wantErr: jsonrpc2.ErrMethodNotFound,
+		},
+		"denyWins": {
+			policy:  jsonrpc2.MethodPolicy{Allow: []string{"textDocument/*"}, Deny: []string{"textDocument/rename"}},
+			method:  "textDocument/rename",
+			wantErr: jsonrpc2.ErrMethodNotFound,
+		},
+		"defaultAllow": {
+			policy: jsonrpc2.MethodPolicy{Deny: []string{"debug/*"}, DefaultAllow: true},
+			method: "initialize",
+		},
+		"customError": {
+			policy:  jsonrpc2.MethodPolicy{Err: errDenied},
+			method:  "initialize",
+			wantErr: errDenied,
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			h := jsonrpc2.PolicyHandler(func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+				return reply(ctx, true, nil)
+			}, tt.policy)
+
+			call, err := jsonrpc2.NewCall(jsonrpc2.NewNumberID(1), tt.method, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var gotErr error
+			reply := func(ctx context.Context, result interface{}, err error) error {
+				gotErr = err
+				return nil
+			}
+			if err := h(ctx, reply, call); err != nil {
+				t.Fatal(err)
+			}
+			if !errors.Is(gotErr, tt.wantErr) || (tt.wantErr == nil && gotErr != nil) {
+				t.Errorf("got %v, want %v", gotErr, tt.wantErr)
+			}
+		})
+	}
+}
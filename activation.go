@@ -0,0 +1,72 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd.
+const listenFDsStart = 3
+
+// ActivationListeners returns the listeners passed to the process by systemd
+// socket activation, in the order of the socket unit.
+//
+// It returns no listeners if the process was not socket activated. The
+// activation environment variables are unset, so that child processes do not
+// adopt the listeners too.
+//
+// The listeners can be served with Serve, for the daemon to be started on
+// demand without managing its own sockets.
+//
+// See https://www.freedesktop.org/software/systemd/man/sd_listen_fds.html.
+func ActivationListeners() ([]net.Listener, error) {
+	pid, fds, names := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	if pid == "" || fds == "" {
+		return nil, nil
+	}
+	if pid != strconv.Itoa(os.Getpid()) {
+		// the variables were meant for another process
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+
+	var fdNames []string
+	if names != "" {
+		fdNames = strings.Split(names, ":")
+	}
+
+	listeners := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		name := "LISTEN_FD_" + strconv.Itoa(listenFDsStart+i)
+		if i < len(fdNames) {
+			name = fdNames[i]
+		}
+
+		f := os.NewFile(uintptr(listenFDsStart+i), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return nil, fmt.Errorf("adopt listener %s: %w", name, err)
+		}
+		listeners = append(listeners, ln)
+	}
+
+	return listeners, nil
+}
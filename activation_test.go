@@ -0,0 +1,53 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+func TestActivationListeners(t *testing.T) {
+	tests := map[string]struct {
+		pid     string
+		fds     string
+		wantErr bool
+	}{
+		"notActivated": {},
+		"otherProcess": {
+			pid: strconv.Itoa(os.Getpid() + 1),
+			fds: "1",
+		},
+		"invalidCount": {
+			pid:     strconv.Itoa(os.Getpid()),
+			fds:     "many",
+			wantErr: true,
+		},
+		"noSockets": {
+			pid: strconv.Itoa(os.Getpid()),
+			fds: "0",
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("LISTEN_PID", tt.pid)
+			t.Setenv("LISTEN_FDS", tt.fds)
+
+			listeners, err := jsonrpc2.ActivationListeners()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if len(listeners) != 0 {
+				t.Errorf("got %d listeners, want none", len(listeners))
+			}
+			if os.Getenv("LISTEN_FDS") != "" {
+				t.Error("LISTEN_FDS was not unset")
+			}
+		})
+	}
+}
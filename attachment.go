@@ -0,0 +1,216 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// HdrAttachmentID is the header name of the id of an attachment frame on the
+// companion channel of an Attachments.
+const HdrAttachmentID = "Attachment-ID"
+
+// Attachment describes binary data sent on the companion channel of an
+// Attachments, to be referenced from the params of a message.
+type Attachment struct {
+	// ID identifies the attachment on the channel.
+	ID string `json:"id"`
+
+	// Size is the size of the data in bytes.
+	Size int64 `json:"size"`
+}
+
+// Attachments moves binary data alongside a Conn over a companion channel,
+// such as a second connection to the same peer, without encoding it in JSON.
+//
+// The data of each attachment is written as a single frame with Attachment-ID
+// and Content-Length headers, and the messages of the Conn reference it by its
+// Attachment descriptor.
+type Attachments struct {
+	channel io.ReadWriteCloser
+	maxSize int64 // maximum size of an attachment received
+
+	writeMu sync.Mutex
+	next    uint64
+
+	mu       sync.Mutex
+	received map[string][]byte
+	waiting  map[string]chan struct{}
+	err      error // error that ended the reading of the channel
+	done     chan struct{}
+}
+
+// DefaultMaxAttachmentSize is the maximum size of the attachments received,
+// unless configured with WithMaxAttachmentSize.
+const DefaultMaxAttachmentSize = 256 << 20
+
+// AttachmentsOption configures an Attachments created by NewAttachments.
+type AttachmentsOption func(*Attachments)
+
+// WithMaxAttachmentSize returns an AttachmentsOption that sets the maximum
+// size of the attachments received, DefaultMaxAttachmentSize by default.
+//
+// The size is checked from the frame header, before the data is read. A larger
+// attachment ends the reading of the channel and closes it, and the attachments
+// not yet received then fail to open.
+func WithMaxAttachmentSize(size int64) AttachmentsOption {
+	return func(a *Attachments) {
+		if size > 0 {
+			a.maxSize = size
+		}
+	}
+}
+
+// NewAttachments returns an Attachments over channel, configured by opts, and
+// starts reading the attachments sent by the peer on it.
+//
+// Received attachments are held in memory until they are opened.
+func NewAttachments(channel io.ReadWriteCloser, opts ...AttachmentsOption) *Attachments {
+	a := &Attachments{
+		channel:  channel,
+		maxSize:  DefaultMaxAttachmentSize,
+		received: make(map[string][]byte),
+		waiting:  make(map[string]chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	go a.run()
+	return a
+}
+
+// Send writes size bytes read from r to the channel, and returns the
+// descriptor of the attachment to send to the peer.
+//
+// The channel is closed if the data cannot be written in full once its header
+// was, as the peer would read the next frame as part of the data.
+func (a *Attachments) Send(r io.Reader, size int64) (Attachment, error) {
+	a.writeMu.Lock()
+	defer a.writeMu.Unlock()
+
+	a.next++
+	att := Attachment{ID: strconv.FormatUint(a.next, 10), Size: size}
+	header := fmt.Sprintf("%s: %s\r\n%s: %d\r\n\r\n", HdrAttachmentID, att.ID, HdrContentLength, size)
+	if _, err := io.WriteString(a.channel, header); err != nil {
+		return Attachment{}, fmt.Errorf("writing attachment header: %w", err)
+	}
+	if _, err := io.CopyN(a.channel, r, size); err != nil {
+		a.channel.Close()
+		return Attachment{}, fmt.Errorf("writing attachment data: %w", err)
+	}
+	return att, nil
+}
+
+// Open returns a reader of the data of att, waiting for it to be received.
+//
+// An attachment can only be opened once.
+func (a *Attachments) Open(ctx context.Context, att Attachment) (io.Reader, error) {
+	for {
+		a.mu.Lock()
+		data, ok := a.received[att.ID]
+		if ok {
+			delete(a.received, att.ID)
+			a.mu.Unlock()
+			if int64(len(data)) != att.Size {
+				return nil, fmt.Errorf("attachment %s: got %d bytes, want %d", att.ID, len(data), att.Size)
+			}
+			return bytes.NewReader(data), nil
+		}
+		if a.err != nil {
+			err := a.err
+			a.mu.Unlock()
+			return nil, fmt.Errorf("attachment %s: %w", att.ID, err)
+		}
+		wait, ok := a.waiting[att.ID]
+		if !ok {
+			wait = make(chan struct{})
+			a.waiting[att.ID] = wait
+		}
+		a.mu.Unlock()
+
+		select {
+		case <-wait:
+		case <-a.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Close closes the channel.
+func (a *Attachments) Close() error {
+	return a.channel.Close()
+}
+
+// run reads the attachments from the channel until it fails, then closes the
+// channel so that the writes of the peer fail rather than block.
+func (a *Attachments) run() {
+	err := a.read(bufio.NewReader(a.channel))
+	a.channel.Close()
+
+	a.mu.Lock()
+	a.err = err
+	a.mu.Unlock()
+	close(a.done)
+}
+
+// read reads attachment frames from r.
+func (a *Attachments) read(r *bufio.Reader) error {
+	for {
+		var (
+			id     string
+			length int64 = -1
+		)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return fmt.Errorf("reading attachment header: %w", err)
+			}
+			line = strings.TrimSpace(line)
+			if line == "" {
+				break
+			}
+			colon := strings.IndexByte(line, ':')
+			if colon < 0 {
+				return fmt.Errorf("invalid attachment header line %q", line)
+			}
+			name, value := line[:colon], strings.TrimSpace(line[colon+1:])
+			switch name {
+			case HdrAttachmentID:
+				id = value
+			case HdrContentLength:
+				if length, err = strconv.ParseInt(value, 10, 64); err != nil || length < 0 {
+					return fmt.Errorf("invalid attachment %s %q", HdrContentLength, value)
+				}
+			}
+		}
+		if id == "" || length < 0 {
+			return fmt.Errorf("missing attachment %s or %s header", HdrAttachmentID, HdrContentLength)
+		}
+		if length > a.maxSize {
+			return fmt.Errorf("attachment %s of %d bytes larger than %d bytes", id, length, a.maxSize)
+		}
+
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return fmt.Errorf("reading attachment data: %w", err)
+		}
+
+		a.mu.Lock()
+		a.received[id] = data
+		if wait, ok := a.waiting[id]; ok {
+			delete(a.waiting, id)
+			close(wait)
+		}
+		a.mu.Unlock()
+	}
+}
@@ -0,0 +1,102 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+func TestAttachments(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, server := pipeConns(t)
+	aChannel, bChannel := net.Pipe()
+	clientAttachments := jsonrpc2.NewAttachments(aChannel)
+	serverAttachments := jsonrpc2.NewAttachments(bChannel)
+	defer clientAttachments.Close()
+	defer serverAttachments.Close()
+
+	server.Go(ctx, func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		var att jsonrpc2.Attachment
+		if err := json.Unmarshal(req.Params(), &att); err != nil {
+			return reply(ctx, nil, err)
+		}
+		r, err := serverAttachments.Open(ctx, att)
+		if err != nil {
+			return reply(ctx, nil, err)
+		}
+		data, err := io.ReadAll(r)
+		return reply(ctx, len(data), err)
+	})
+	client.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+
+	blob := bytes.Repeat([]byte{0, 1, 2, 0xff}, 1024)
+	att, err := clientAttachments.Send(bytes.NewReader(blob), int64(len(blob)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var size int
+	if _, err := client.Call(ctx, "upload", att, &size); err != nil {
+		t.Fatal(err)
+	}
+	if size != len(blob) {
+		t.Errorf("got %d bytes uploaded, want %d", size, len(blob))
+	}
+}
+
+func TestAttachmentsLimits(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	t.Run("too large", func(t *testing.T) {
+		t.Parallel()
+
+		aChannel, bChannel := net.Pipe()
+		sender := jsonrpc2.NewAttachments(aChannel)
+		receiver := jsonrpc2.NewAttachments(bChannel, jsonrpc2.WithMaxAttachmentSize(8))
+		defer sender.Close()
+
+		blob := make([]byte, 16)
+		if _, err := sender.Send(bytes.NewReader(blob), int64(len(blob))); err == nil {
+			t.Error("got nil error sending an attachment too large for the peer")
+		}
+		if _, err := receiver.Open(ctx, jsonrpc2.Attachment{ID: "1", Size: 16}); err == nil {
+			t.Error("got nil error opening an attachment too large")
+		}
+	})
+
+	t.Run("short data", func(t *testing.T) {
+		t.Parallel()
+
+		aChannel, bChannel := net.Pipe()
+		sender := jsonrpc2.NewAttachments(aChannel)
+		receiver := jsonrpc2.NewAttachments(bChannel)
+		defer receiver.Close()
+
+		if _, err := sender.Send(bytes.NewReader(make([]byte, 4)), 16); err == nil {
+			t.Fatal("got nil error sending less data than announced")
+		}
+		if _, err := sender.Send(bytes.NewReader(make([]byte, 4)), 4); err == nil {
+			t.Error("got nil error sending on the channel closed by a partial write")
+		}
+		if _, err := receiver.Open(ctx, jsonrpc2.Attachment{ID: "1", Size: 16}); err == nil {
+			t.Error("got nil error opening a partially written attachment")
+		}
+	})
+}
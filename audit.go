@@ -0,0 +1,184 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AuditRecord is the tamper-evident record of a request in an AuditLog.
+type AuditRecord struct {
+	// Time is when the request was received.
+	Time time.Time `json:"time"`
+
+	// Method is the method name of the request.
+	Method string `json:"method"`
+
+	// ParamsDigest is the hex encoded SHA-256 digest of the params.
+	ParamsDigest string `json:"paramsDigest"`
+
+	// Identity identifies the peer that sent the request.
+	Identity string `json:"identity,omitempty"`
+
+	// Code is the error code of a failed request, 0 if it succeeded.
+	Code Code `json:"code"`
+
+	// Prev is the hash of the previous record, empty for the first one.
+	Prev string `json:"prev"`
+
+	// Hash is the hex encoded SHA-256 hash of the record, which covers Prev.
+	Hash string `json:"hash"`
+}
+
+// hash returns the hash of r, ignoring the Hash field.
+func (r *AuditRecord) hash() string {
+	h := sha256.New()
+	for _, field := range []string{
+		r.Prev,
+		r.Time.UTC().Format(time.RFC3339Nano),
+		r.Method,
+		r.ParamsDigest,
+		r.Identity,
+		strconv.FormatInt(int64(r.Code), 10),
+	} {
+		// prefix each field with its length, so that fields cannot be shifted
+		h.Write([]byte(strconv.Itoa(len(field)) + ":" + field))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// AuditSink stores the records of an AuditLog.
+type AuditSink interface {
+	// Append stores r after the previous records.
+	Append(r AuditRecord) error
+}
+
+// AuditLog appends a hash-chained record of the requests passed to its
+// Handler to a sink, so that removing, reordering or altering records breaks
+// the chain.
+type AuditLog struct {
+	sink     AuditSink
+	identity func(ctx context.Context) string
+	match    func(method string) bool
+
+	mu   sync.Mutex
+	last string // hash of the last record
+}
+
+// NewAuditLog returns an AuditLog appending records to sink.
+//
+// identity returns the identity of the peer of a request from its context,
+// it may be nil. match reports whether the requests for a method must be
+// audited, such as the methods that mutate state, a nil match audits all the
+// requests. prev is the hash of the last record of sink, to continue its
+// chain, or empty for a new chain.
+func NewAuditLog(
+	sink AuditSink, identity func(ctx context.Context) string, match func(method string) bool, prev string,
+) *AuditLog {
+	return &AuditLog{
+		sink:     sink,
+		identity: identity,
+		match:    match,
+		last:     prev,
+	}
+}
+
+// Handler returns a handler that audits the requests passed to handler.
+//
+// Calls are recorded when they are replied to, or when handler returns an
+// error without replying. Notifications are recorded when handler returns.
+// The audit fails closed: if the record of a call cannot be appended, the call
+// is not replied to and the reply returns the error of the sink.
+func (a *AuditLog) Handler(handler Handler) (h Handler) {
+	h = Handler(func(ctx context.Context, reply Replier, req Request) error {
+		if a.match != nil && !a.match(req.Method()) {
+			return handler(ctx, reply, req)
+		}
+
+		digest := sha256.Sum256(req.Params())
+		r := AuditRecord{
+			Time:         time.Now(),
+			Method:       req.Method(),
+			ParamsDigest: hex.EncodeToString(digest[:]),
+		}
+		if a.identity != nil {
+			r.Identity = a.identity(ctx)
+		}
+
+		var once sync.Once
+		record := func(err error) (aerr error) {
+			once.Do(func() { aerr = a.append(r, err) })
+			return aerr
+		}
+
+		_, isCall := req.(*Call)
+		innerReply := reply
+		reply = func(ctx context.Context, result interface{}, err error) error {
+			if isCall {
+				if aerr := record(err); aerr != nil {
+					return aerr
+				}
+			}
+			return innerReply(ctx, result, err)
+		}
+		err := handler(ctx, reply, req)
+		if !isCall || err != nil {
+			if aerr := record(err); aerr != nil && err == nil {
+				err = aerr
+			}
+		}
+		return err
+	})
+
+	return h
+}
+
+// append chains r, for a request that ended with err, and appends it to the
+// sink.
+func (a *AuditLog) append(r AuditRecord, err error) error {
+	if err != nil {
+		r.Code = UnknownError
+		var werr *Error
+		if errors.As(err, &werr) {
+			r.Code = werr.Code
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	r.Prev = a.last
+	r.Hash = r.hash()
+	if err := a.sink.Append(r); err != nil {
+		return fmt.Errorf("appending audit record: %w", err)
+	}
+	a.last = r.Hash
+	return nil
+}
+
+// VerifyAuditChain checks that records form an unbroken chain starting after
+// the record of hash prev, or at the start of a chain if prev is empty.
+//
+// It returns an error identifying the first record that was altered, or
+// that does not follow the previous one.
+func VerifyAuditChain(prev string, records []AuditRecord) error {
+	for i := range records {
+		r := &records[i]
+		if r.Prev != prev {
+			return fmt.Errorf("audit record %d: does not follow the previous record", i)
+		}
+		if r.hash() != r.Hash {
+			return fmt.Errorf("audit record %d: hash mismatch", i)
+		}
+		prev = r.Hash
+	}
+	return nil
+}
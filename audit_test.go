@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+// auditRecords is an AuditSink keeping the records in memory.
+type auditRecords []jsonrpc2.AuditRecord
+
+func (s *auditRecords) Append(r jsonrpc2.AuditRecord) error {
+	*s = append(*s, r)
+	return nil
+}
+
+func TestAuditLog(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var sink auditRecords
+	audit := jsonrpc2.NewAuditLog(&sink, func(context.Context) string { return "alice" }, func(method string) bool {
+		return method != "hover"
+	}, "")
+	h := audit.Handler(func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		if req.Method() == "delete" {
+			return reply(ctx, nil, fmt.Errorf("read-only: %w", jsonrpc2.ErrInvalidRequest))
+		}
+		return reply(ctx, true, nil)
+	})
+
+	for i, method := range []string{"rename", "hover", "delete"} {
+		call, err := jsonrpc2.NewCall(jsonrpc2.NewNumberID(int32(i)), method, []string{"file:///a.go"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := h(ctx, noopReplier, call); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(sink) != 2 {
+		t.Fatalf("got %d records, want 2", len(sink))
+	}
+	if sink[0].Method != "rename" || sink[0].Identity != "alice" || sink[0].Code != 0 {
+		t.Errorf("got record %+v, want a successful rename by alice", sink[0])
+	}
+	if sink[1].Method != "delete" || sink[1].Code != jsonrpc2.InvalidRequest {
+		t.Errorf("got record %+v, want a failed delete", sink[1])
+	}
+	if err := jsonrpc2.VerifyAuditChain("", sink); err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := append(auditRecords(nil), sink...)
+	tampered[0].Identity = "mallory"
+	if err := jsonrpc2.VerifyAuditChain("", tampered); err == nil {
+		t.Error("altered record not detected")
+	}
+	if err := jsonrpc2.VerifyAuditChain("", sink[1:]); err == nil {
+		t.Error("removed record not detected")
+	}
+}
@@ -0,0 +1,129 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package benchmarks provides reproducible workloads simulating the traffic of
+// language servers, to evaluate performance changes of the jsonrpc2 package
+// consistently.
+//
+// Results of two revisions can be compared with benchstat:
+//
+//	go test -run=- -bench=. -count=10 ./benchmarks > old.txt
+//	go test -run=- -bench=. -count=10 ./benchmarks > new.txt
+//	benchstat old.txt new.txt
+package benchmarks
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+// Workload describes the traffic generated by Run.
+type Workload struct {
+	// Seed makes the sequence of messages reproducible.
+	Seed int64
+
+	// Clients is the number of connections sending messages concurrently.
+	Clients int
+
+	// CallRatio is the fraction of messages that are calls, the others are
+	// notifications.
+	CallRatio float64
+
+	// PayloadSize is the size in bytes of the params of a message.
+	PayloadSize int
+
+	// LargeRatio is the fraction of messages sent with LargeSize params
+	// instead.
+	LargeRatio float64
+
+	// LargeSize is the size in bytes of large params.
+	LargeSize int
+}
+
+// Workloads are typical language server traffic patterns.
+var Workloads = map[string]Workload{
+	// Typing is a single editor sending change notifications with a few
+	// completion calls.
+	"Typing": {Seed: 1, Clients: 1, CallRatio: 0.2, PayloadSize: 200},
+
+	// Navigation is a single editor sending hover and definition calls.
+	"Navigation": {Seed: 2, Clients: 1, CallRatio: 1, PayloadSize: 150},
+
+	// Indexing is several clients mixing calls with large document syncs.
+	"Indexing": {Seed: 3, Clients: 8, CallRatio: 0.5, PayloadSize: 500, LargeRatio: 0.05, LargeSize: 256 << 10},
+}
+
+// echo replies to calls with their params.
+func echo(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+	return reply(ctx, req.Params(), nil)
+}
+
+// Run runs b.N messages of w over connections framed by framer, between
+// clients and servers replying to calls with their params.
+func Run(b *testing.B, w Workload, framer jsonrpc2.Framer) {
+	b.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clients := make([]jsonrpc2.Conn, w.Clients)
+	for i := range clients {
+		cPipe, sPipe := net.Pipe()
+		client := jsonrpc2.NewConn(framer(cPipe))
+		server := jsonrpc2.NewConn(framer(sPipe))
+		client.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+		server.Go(ctx, jsonrpc2.AsyncHandler(echo))
+		defer func() {
+			client.Close()
+			<-client.Done()
+			<-server.Done()
+		}()
+		clients[i] = client
+	}
+
+	small := strings.Repeat("x", w.PayloadSize)
+	large := strings.Repeat("x", w.LargeSize)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	var wg sync.WaitGroup
+	for i, client := range clients {
+		n := b.N / len(clients)
+		if i < b.N%len(clients) {
+			n++
+		}
+
+		wg.Add(1)
+		go func(client jsonrpc2.Conn, rnd *rand.Rand, n int) {
+			defer wg.Done()
+			for j := 0; j < n; j++ {
+				params := small
+				if rnd.Float64() < w.LargeRatio {
+					params = large
+				}
+
+				var err error
+				if rnd.Float64() < w.CallRatio {
+					var result string
+					_, err = client.Call(ctx, "call", params, &result)
+				} else {
+					err = client.Notify(ctx, "notify", params)
+				}
+				if err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		}(client, rand.New(rand.NewSource(w.Seed+int64(i))), n)
+	}
+	wg.Wait()
+
+	b.StopTimer()
+}
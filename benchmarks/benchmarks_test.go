@@ -0,0 +1,27 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package benchmarks_test
+
+import (
+	"testing"
+
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/jsonrpc2/benchmarks"
+)
+
+func BenchmarkWorkloads(b *testing.B) {
+	framers := map[string]jsonrpc2.Framer{
+		"stream":    jsonrpc2.NewStream,
+		"rawStream": jsonrpc2.NewRawStream,
+	}
+	for name, w := range benchmarks.Workloads {
+		w := w
+		for framerName, framer := range framers {
+			framer := framer
+			b.Run(name+"/"+framerName, func(b *testing.B) {
+				benchmarks.Run(b, w, framer)
+			})
+		}
+	}
+}
@@ -0,0 +1,204 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// CapabilitiesParams is the params of the MethodCapabilities,
+// MethodRegister and MethodUnregister messages, and the result of
+// MethodCapabilities.
+type CapabilitiesParams struct {
+	// Methods is the list of method names.
+	Methods []string `json:"methods"`
+}
+
+// Capabilities keeps track of the methods served by both peers of a Conn, and
+// lets them register and unregister methods at runtime, as LSP does with
+// client/registerCapability for its own methods.
+type Capabilities struct {
+	conn     Conn
+	onChange func(method string, registered bool) // may be nil
+
+	mu     sync.Mutex
+	local  map[string]bool
+	remote map[string]bool
+}
+
+// NewCapabilities returns Capabilities serving methods on conn.
+//
+// onChange is called when the peer registers or unregisters a method, and may
+// be nil.
+func NewCapabilities(conn Conn, methods []string, onChange func(method string, registered bool)) *Capabilities {
+	c := &Capabilities{
+		conn:     conn,
+		onChange: onChange,
+		local:    make(map[string]bool, len(methods)),
+		remote:   make(map[string]bool),
+	}
+	for _, method := range methods {
+		c.local[method] = true
+	}
+	return c
+}
+
+// Exchange sends the methods served locally to the peer, and records the
+// methods it serves from its reply.
+func (c *Capabilities) Exchange(ctx context.Context) error {
+	var result CapabilitiesParams
+	if _, err := c.conn.Call(ctx, MethodCapabilities, CapabilitiesParams{Methods: c.Local()}, &result); err != nil {
+		return fmt.Errorf("exchanging capabilities: %w", err)
+	}
+	c.setRemote(result.Methods)
+	return nil
+}
+
+// Register starts serving methods, and notifies the peer.
+func (c *Capabilities) Register(ctx context.Context, methods ...string) error {
+	c.mu.Lock()
+	for _, method := range methods {
+		c.local[method] = true
+	}
+	c.mu.Unlock()
+
+	return c.conn.Notify(ctx, MethodRegister, CapabilitiesParams{Methods: methods})
+}
+
+// Unregister stops serving methods, and notifies the peer.
+func (c *Capabilities) Unregister(ctx context.Context, methods ...string) error {
+	c.mu.Lock()
+	for _, method := range methods {
+		delete(c.local, method)
+	}
+	c.mu.Unlock()
+
+	return c.conn.Notify(ctx, MethodUnregister, CapabilitiesParams{Methods: methods})
+}
+
+// Local returns the sorted list of the methods served locally.
+func (c *Capabilities) Local() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return sortedMethods(c.local)
+}
+
+// Remote returns the sorted list of the methods served by the peer.
+func (c *Capabilities) Remote() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return sortedMethods(c.remote)
+}
+
+// Supports reports whether the peer serves method.
+func (c *Capabilities) Supports(method string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.remote[method]
+}
+
+// Handler returns a handler that answers the capabilities messages of the
+// peer, and passes the requests for the methods served locally to handler.
+// The requests for other methods are replied to with the standard method not
+// found response.
+//
+// The capabilities messages are reserved methods, so the handler must wrap a
+// ReservedHandler rather than be wrapped by one.
+func (c *Capabilities) Handler(handler Handler) (h Handler) {
+	h = Handler(func(ctx context.Context, reply Replier, req Request) error {
+		switch req.Method() {
+		case MethodCapabilities, MethodRegister, MethodUnregister:
+			var params CapabilitiesParams
+			if err := json.Unmarshal(req.Params(), &params); err != nil {
+				return reply(ctx, nil, fmt.Errorf("%s params: %v: %w", req.Method(), err, ErrInvalidParams))
+			}
+			switch req.Method() {
+			case MethodCapabilities:
+				c.setRemote(params.Methods)
+				return reply(ctx, CapabilitiesParams{Methods: c.Local()}, nil)
+			case MethodRegister:
+				c.update(params.Methods, true)
+			default:
+				c.update(params.Methods, false)
+			}
+			return reply(ctx, nil, nil)
+		}
+
+		c.mu.Lock()
+		served := c.local[req.Method()]
+		c.mu.Unlock()
+		if !served {
+			return reply(ctx, nil, fmt.Errorf("%q: %w", req.Method(), ErrMethodNotFound))
+		}
+		return handler(ctx, reply, req)
+	})
+
+	return h
+}
+
+// setRemote replaces the methods served by the peer.
+func (c *Capabilities) setRemote(methods []string) {
+	set := make(map[string]bool, len(methods))
+	for _, method := range methods {
+		set[method] = true
+	}
+
+	c.mu.Lock()
+	old := c.remote
+	c.remote = set
+	c.mu.Unlock()
+
+	if c.onChange == nil {
+		return
+	}
+	for _, method := range sortedMethods(old) {
+		if !set[method] {
+			c.onChange(method, false)
+		}
+	}
+	for _, method := range sortedMethods(set) {
+		if !old[method] {
+			c.onChange(method, true)
+		}
+	}
+}
+
+// update registers or unregisters methods served by the peer.
+func (c *Capabilities) update(methods []string, registered bool) {
+	var changed []string
+	c.mu.Lock()
+	for _, method := range methods {
+		if c.remote[method] == registered {
+			continue
+		}
+		if registered {
+			c.remote[method] = true
+		} else {
+			delete(c.remote, method)
+		}
+		changed = append(changed, method)
+	}
+	c.mu.Unlock()
+
+	if c.onChange != nil {
+		for _, method := range changed {
+			c.onChange(method, registered)
+		}
+	}
+}
+
+// sortedMethods returns the sorted keys of set.
+func sortedMethods(set map[string]bool) []string {
+	methods := make([]string, 0, len(set))
+	for method := range set {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+func TestCapabilities(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	changes := make(chan string, 4)
+	onChange := func(method string, registered bool) {
+		if registered {
+			changes <- "+" + method
+		} else {
+			changes <- "-" + method
+		}
+	}
+	echo := func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		return reply(ctx, req.Method(), nil)
+	}
+
+	a, b := pipeConns(t)
+	aCaps := jsonrpc2.NewCapabilities(a, nil, onChange)
+	bCaps := jsonrpc2.NewCapabilities(b, []string{"hover"}, nil)
+	a.Go(ctx, aCaps.Handler(echo))
+	b.Go(ctx, bCaps.Handler(echo))
+
+	if err := aCaps.Exchange(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-changes; got != "+hover" {
+		t.Errorf("got change %s, want +hover", got)
+	}
+	if !aCaps.Supports("hover") || aCaps.Supports("completion") {
+		t.Errorf("got remote methods %v, want [hover]", aCaps.Remote())
+	}
+
+	var werr *jsonrpc2.Error
+	if _, err := a.Call(ctx, "completion", nil, nil); !errors.As(err, &werr) || werr.Code != jsonrpc2.MethodNotFound {
+		t.Errorf("got %v for an unregistered method, want %v", err, jsonrpc2.ErrMethodNotFound)
+	}
+
+	if err := bCaps.Register(ctx, "completion"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bCaps.Unregister(ctx, "hover"); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-changes + " " + <-changes; got != "+completion -hover" {
+		t.Errorf("got changes %s, want +completion -hover", got)
+	}
+	var got string
+	if _, err := a.Call(ctx, "completion", nil, &got); err != nil || got != "completion" {
+		t.Errorf("got %q, %v for a registered method, want completion", got, err)
+	}
+	if remote := strings.Join(aCaps.Remote(), " "); remote != "completion" {
+		t.Errorf("got remote methods %s, want completion", remote)
+	}
+}
@@ -0,0 +1,299 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// ChunkParams is the params of the MethodChunk notifications carrying the
+// parts of a large result.
+type ChunkParams struct {
+	// Token identifies the result the chunk is part of.
+	Token string `json:"token"`
+
+	// Index is the position of the chunk in the result, starting at 0.
+	Index int `json:"index"`
+
+	// Data is the chunk of the JSON encoding of the result.
+	Data []byte `json:"data"`
+}
+
+// ChunkManifest describes a result sent in chunks.
+type ChunkManifest struct {
+	// Token identifies the result in the chunks.
+	Token string `json:"token"`
+
+	// Chunks is the number of chunks.
+	Chunks int `json:"chunks"`
+
+	// Size is the size of the JSON encoding of the result.
+	Size int `json:"size"`
+}
+
+// chunkedResult is the result of a call replied to in chunks.
+type chunkedResult struct {
+	Manifest *ChunkManifest `json:"rpc.chunked"`
+}
+
+// ChunkingHandler returns a handler that sends the results of handler larger
+// than threshold bytes to the peer of conn in chunks.
+//
+// Each chunk of at most threshold bytes is sent as a MethodChunk notification,
+// so that writes of other messages are interleaved with them, and the call is
+// then replied to with a ChunkManifest. The peer must use a ChunkReceiver to
+// reassemble the result.
+//
+// ChunkingHandler panics if threshold is not positive.
+func ChunkingHandler(conn Conn, handler Handler, threshold int) (h Handler) {
+	if threshold <= 0 {
+		panic("jsonrpc2: chunk threshold must be positive")
+	}
+	var tokens uint64
+
+	h = Handler(func(ctx context.Context, reply Replier, req Request) error {
+		if _, ok := req.(*Call); !ok {
+			return handler(ctx, reply, req)
+		}
+
+		innerReply := reply
+		reply = func(ctx context.Context, result interface{}, err error) error {
+			if err != nil {
+				return innerReply(ctx, nil, err)
+			}
+
+			data, err := json.Marshal(result)
+			if err != nil {
+				return innerReply(ctx, nil, fmt.Errorf("marshaling result: %w", err))
+			}
+			if len(data) <= threshold {
+				return innerReply(ctx, json.RawMessage(data), nil)
+			}
+
+			manifest := &ChunkManifest{
+				Token: strconv.FormatUint(atomic.AddUint64(&tokens, 1), 10),
+				Size:  len(data),
+			}
+			for ; len(data) > 0; manifest.Chunks++ {
+				n := threshold
+				if n > len(data) {
+					n = len(data)
+				}
+				params := ChunkParams{Token: manifest.Token, Index: manifest.Chunks, Data: data[:n]}
+				if err := conn.Notify(ctx, MethodChunk, params); err != nil {
+					return fmt.Errorf("sending chunk: %w", err)
+				}
+				data = data[n:]
+			}
+			return innerReply(ctx, chunkedResult{Manifest: manifest}, nil)
+		}
+
+		return handler(ctx, reply, req)
+	})
+
+	return h
+}
+
+// maxUnclaimedChunks is the maximum size of the chunks a ChunkReceiver keeps
+// for the results no call waits for yet.
+const maxUnclaimedChunks = 64 << 20
+
+// ChunkReceiver reassembles the results sent in chunks by a ChunkingHandler.
+//
+// Its Handler must wrap the handler of the Conn the calls are made on. The
+// chunks of a result are kept until its call returns, so calls must be made
+// with Call rather than on the Conn directly.
+//
+// The chunks arrive before the reply that tells which call they belong to, so
+// they are kept unclaimed until then. Once the unclaimed chunks exceed 64 MiB
+// in total, the results they belong to are dropped, oldest first, and their
+// calls then wait for them until their context is done.
+type ChunkReceiver struct {
+	mu        sync.Mutex
+	results   map[string]*chunkedData
+	unclaimed []string // tokens of the results no call waits for, oldest first
+	size      int      // size of the chunks of the unclaimed results
+}
+
+// chunkedData holds the chunks of a result received so far.
+type chunkedData struct {
+	chunks   map[int][]byte
+	size     int           // size of the chunks
+	claimed  bool          // reports whether a call waits for the result
+	want     int           // number of chunks of a claimed result
+	received chan struct{} // signaled when a chunk is received
+}
+
+// complete reports whether the chunks 0 to n-1 were all received.
+func (d *chunkedData) complete(n int) bool {
+	have := 0
+	for i := range d.chunks {
+		if i >= 0 && i < n {
+			have++
+		}
+	}
+	return have == n
+}
+
+// NewChunkReceiver returns a ChunkReceiver.
+func NewChunkReceiver() *ChunkReceiver {
+	return &ChunkReceiver{
+		results: make(map[string]*chunkedData),
+	}
+}
+
+// data returns the chunks of token, creating them if needed. r.mu must be
+// held.
+func (r *ChunkReceiver) data(token string) *chunkedData {
+	d, ok := r.results[token]
+	if !ok {
+		d = &chunkedData{
+			chunks:   make(map[int][]byte),
+			received: make(chan struct{}, 1),
+		}
+		r.results[token] = d
+		r.unclaimed = append(r.unclaimed, token)
+	}
+	return d
+}
+
+// add adds a chunk to d, the result of token, and drops the oldest unclaimed
+// results while they are too large. r.mu must be held.
+func (r *ChunkReceiver) add(d *chunkedData, index int, data []byte) {
+	if d.claimed && (index < 0 || index >= d.want) {
+		// not part of the result
+		return
+	}
+	delta := len(data) - len(d.chunks[index])
+	d.chunks[index] = data
+	d.size += delta
+	if d.claimed {
+		return
+	}
+
+	r.size += delta
+	for r.size > maxUnclaimedChunks && len(r.unclaimed) > 0 {
+		token := r.unclaimed[0]
+		r.unclaimed = r.unclaimed[1:]
+		r.size -= r.results[token].size
+		delete(r.results, token)
+	}
+}
+
+// claim marks d, the result of token, as waited for by a call of a result of
+// n chunks. r.mu must be held.
+func (r *ChunkReceiver) claim(token string, d *chunkedData, n int) {
+	if d.claimed {
+		return
+	}
+	d.claimed, d.want = true, n
+	r.size -= d.size
+	for i, t := range r.unclaimed {
+		if t == token {
+			r.unclaimed = append(r.unclaimed[:i], r.unclaimed[i+1:]...)
+			break
+		}
+	}
+	for i := range d.chunks {
+		if i < 0 || i >= n {
+			d.size -= len(d.chunks[i])
+			delete(d.chunks, i)
+		}
+	}
+}
+
+// Handler returns a handler that collects the MethodChunk notifications, and
+// passes all other requests to handler.
+func (r *ChunkReceiver) Handler(handler Handler) (h Handler) {
+	h = Handler(func(ctx context.Context, reply Replier, req Request) error {
+		if req.Method() != MethodChunk {
+			return handler(ctx, reply, req)
+		}
+
+		var params ChunkParams
+		if err := json.Unmarshal(req.Params(), &params); err != nil {
+			return reply(ctx, nil, fmt.Errorf("%s params: %v: %w", MethodChunk, err, ErrInvalidParams))
+		}
+
+		r.mu.Lock()
+		d := r.data(params.Token)
+		r.add(d, params.Index, params.Data)
+		r.mu.Unlock()
+
+		select {
+		case d.received <- struct{}{}:
+		default:
+		}
+		return reply(ctx, nil, nil)
+	})
+
+	return h
+}
+
+// Call calls method with params on conn, and returns a reader of the JSON
+// encoding of the result, reassembled from its chunks if it was sent in
+// chunks.
+func (r *ChunkReceiver) Call(ctx context.Context, conn Conn, method string, params interface{}) (io.Reader, error) {
+	var raw json.RawMessage
+	if _, err := conn.Call(ctx, method, params, &raw); err != nil {
+		return nil, err
+	}
+
+	var chunked chunkedResult
+	if err := json.Unmarshal(raw, &chunked); err != nil || chunked.Manifest == nil {
+		return bytes.NewReader(raw), nil
+	}
+	manifest := chunked.Manifest
+	if manifest.Chunks < 0 || manifest.Size < 0 {
+		return nil, fmt.Errorf("invalid manifest of %d chunks of %d bytes: %w", manifest.Chunks, manifest.Size, ErrParse)
+	}
+
+	r.mu.Lock()
+	d := r.data(manifest.Token)
+	r.claim(manifest.Token, d, manifest.Chunks)
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		if r.results[manifest.Token] == d {
+			delete(r.results, manifest.Token)
+		}
+		r.mu.Unlock()
+	}()
+
+	// the chunks are sent before the reply, but may be handled after it
+	for {
+		r.mu.Lock()
+		complete := d.complete(manifest.Chunks)
+		r.mu.Unlock()
+		if complete {
+			break
+		}
+		select {
+		case <-d.received:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for chunks: %w", ctx.Err())
+		}
+	}
+
+	// the chunks are complete, so d.size is the size of the chunks received,
+	// which bounds the allocation whatever the manifest claims
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if d.size != manifest.Size {
+		return nil, fmt.Errorf("got %d bytes of chunks, want %d: %w", d.size, manifest.Size, ErrParse)
+	}
+	result := make([]byte, 0, d.size)
+	for i := 0; i < manifest.Chunks; i++ {
+		result = append(result, d.chunks[i]...)
+	}
+	return bytes.NewReader(result), nil
+}
@@ -0,0 +1,99 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+func TestChunking(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, server := pipeConns(t)
+
+	large := strings.Repeat("x", 1000)
+	server.Go(ctx, jsonrpc2.ChunkingHandler(server, func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		if req.Method() == "small" {
+			return reply(ctx, "small", nil)
+		}
+		return reply(ctx, large, nil)
+	}, 64))
+
+	receiver := jsonrpc2.NewChunkReceiver()
+	client.Go(ctx, receiver.Handler(jsonrpc2.MethodNotFoundHandler))
+
+	for method, want := range map[string]string{"small": "small", "large": large} {
+		r, err := receiver.Call(ctx, client, method, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got string
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%s: got a result of %d bytes, want %d", method, len(got), len(want))
+		}
+	}
+}
+
+func TestChunkReceiverInvalidManifest(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, server := pipeConns(t)
+	server.Go(ctx, func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		var manifest jsonrpc2.ChunkManifest
+		if err := json.Unmarshal(req.Params(), &manifest); err != nil {
+			return reply(ctx, nil, err)
+		}
+		chunk := jsonrpc2.ChunkParams{Token: manifest.Token, Index: 0, Data: []byte(`""`)}
+		if err := server.Notify(ctx, jsonrpc2.MethodChunk, chunk); err != nil {
+			return reply(ctx, nil, err)
+		}
+		return reply(ctx, map[string]interface{}{"rpc.chunked": manifest}, nil)
+	})
+
+	receiver := jsonrpc2.NewChunkReceiver()
+	client.Go(ctx, receiver.Handler(jsonrpc2.MethodNotFoundHandler))
+
+	for _, manifest := range []jsonrpc2.ChunkManifest{
+		{Token: "negative", Chunks: 1, Size: -1},
+		{Token: "huge", Chunks: 1, Size: 1 << 62},
+		{Token: "no chunks", Chunks: -1, Size: 2},
+	} {
+		if _, err := receiver.Call(ctx, client, "large", manifest); !errors.Is(err, jsonrpc2.ErrParse) {
+			t.Errorf("%s: got %v, want %v", manifest.Token, err, jsonrpc2.ErrParse)
+		}
+	}
+}
+
+func TestChunkingHandlerThreshold(t *testing.T) {
+	t.Parallel()
+
+	defer func() {
+		if recover() == nil {
+			t.Error("got no panic for a threshold of 0")
+		}
+	}()
+	jsonrpc2.ChunkingHandler(nil, jsonrpc2.MethodNotFoundHandler, 0)
+}
@@ -0,0 +1,21 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import (
+	"context"
+	"io"
+)
+
+// NewClient returns a running Conn over rwc for a program that only calls a
+// server, such as a script talking to a language server over stdio.
+//
+// The messages are framed as with NewStream, and the requests of the server
+// are replied to with the standard method not found response. The Conn stops
+// when ctx is done, and rwc is closed when the Conn is.
+func NewClient(ctx context.Context, rwc io.ReadWriteCloser, opts ...ConnOption) Conn {
+	conn := NewConn(NewStream(rwc), opts...)
+	conn.Go(ctx, MethodNotFoundHandler)
+	return conn
+}
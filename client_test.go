@@ -0,0 +1,38 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+func TestNewClient(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	aPipe, bPipe := net.Pipe()
+	server := jsonrpc2.NewConn(jsonrpc2.NewStream(bPipe))
+	server.Go(ctx, func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		return reply(ctx, req.Method(), nil)
+	})
+
+	client := jsonrpc2.NewClient(ctx, aPipe)
+	defer func() {
+		client.Close()
+		<-client.Done()
+		<-server.Done()
+	}()
+
+	var got string
+	if _, err := client.Call(ctx, "initialize", nil, &got); err != nil || got != "initialize" {
+		t.Errorf("client.Call(...): got %q, %v, want initialize", got, err)
+	}
+	if _, err := server.Call(ctx, "window/showMessage", nil, nil); err == nil {
+		t.Error("server.Call(...): got no error from a client without a handler")
+	}
+}
@@ -0,0 +1,136 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time of the timeouts of this package, so that tests
+// can control time instead of waiting for it to pass.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// NewTimer returns a Timer that fires after d.
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a timer created by a Clock, with the semantics of a time.Timer.
+type Timer interface {
+	// C returns the channel the time is sent on when the timer fires.
+	C() <-chan time.Time
+
+	// Stop prevents the timer from firing, and reports whether it was armed.
+	Stop() bool
+
+	// Reset makes the timer fire after d, and reports whether it was armed.
+	Reset(d time.Duration) bool
+}
+
+// SystemClock is the Clock of the time package.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+// Now implements Clock.Now.
+func (systemClock) Now() time.Time { return time.Now() }
+
+// NewTimer implements Clock.NewTimer.
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+type systemTimer struct {
+	*time.Timer
+}
+
+// C implements Timer.C.
+func (t systemTimer) C() <-chan time.Time { return t.Timer.C }
+
+// afterFunc calls f in its own goroutine once d has elapsed on clock, unless
+// the returned function is called first.
+func afterFunc(clock Clock, d time.Duration, f func()) (stop func()) {
+	if _, ok := clock.(systemClock); ok {
+		timer := time.AfterFunc(d, f)
+		return func() { timer.Stop() }
+	}
+
+	timer := clock.NewTimer(d)
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-timer.C():
+			f()
+		case <-stopped:
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			timer.Stop()
+			close(stopped)
+		})
+	}
+}
+
+// withDeadline is context.WithDeadline with the deadline measured by clock.
+func withDeadline(parent context.Context, clock Clock, d time.Time) (context.Context, context.CancelFunc) {
+	if _, ok := clock.(systemClock); ok {
+		return context.WithDeadline(parent, d)
+	}
+
+	inner, cancel := context.WithCancel(parent)
+	ctx := &deadlineCtx{Context: inner, deadline: d}
+	expire := func() {
+		ctx.mu.Lock()
+		defer ctx.mu.Unlock()
+		if inner.Err() == nil {
+			ctx.err = context.DeadlineExceeded
+		}
+		cancel()
+	}
+	wait := d.Sub(clock.Now())
+	if wait <= 0 {
+		expire()
+		return ctx, cancel
+	}
+	timer := clock.NewTimer(wait)
+	go func() {
+		select {
+		case <-timer.C():
+			expire()
+		case <-inner.Done():
+			timer.Stop()
+		}
+	}()
+	return ctx, cancel
+}
+
+// deadlineCtx is a context.Context expiring at a deadline measured by a Clock
+// other than SystemClock.
+type deadlineCtx struct {
+	context.Context
+	deadline time.Time
+
+	mu  sync.Mutex
+	err error // context.DeadlineExceeded once expired
+}
+
+// Deadline implements context.Context.Deadline.
+func (c *deadlineCtx) Deadline() (time.Time, bool) {
+	return c.deadline, true
+}
+
+// Err implements context.Context.Err.
+func (c *deadlineCtx) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	return c.Context.Err()
+}
@@ -39,6 +39,10 @@ const (
 	// Deprecated: Use JSONRPCReservedErrorRangeStart instead.
 	CodeServerErrorStart = JSONRPCReservedErrorRangeStart
 
+	// ServerOverloaded is the error of a request rejected because the server is
+	// too busy to process it, such as by a Limiter under backpressure.
+	ServerOverloaded Code = -32003
+
 	// ServerNotInitialized is the error of server not initialized.
 	ServerNotInitialized Code = -32002
 
@@ -56,6 +60,12 @@ const (
 	//
 	// Deprecated: Use JSONRPCReservedErrorRangeEnd instead.
 	CodeServerErrorEnd = JSONRPCReservedErrorRangeEnd
+
+	// ContentModified is the error of a request whose result is no longer valid
+	// because the content it was computed on was modified.
+	//
+	// It is defined by the LSP specification.
+	ContentModified Code = -32801
 )
 
 // This file contains the Go forms of the wire specification.
@@ -83,4 +93,12 @@ var (
 
 	// ErrInternal is not currently returned but defined for completeness.
 	ErrInternal = NewError(InternalError, "JSON-RPC internal error")
+
+	// ErrContentModified is used when a queued request became stale before it
+	// was processed.
+	ErrContentModified = NewError(ContentModified, "JSON-RPC content modified")
+
+	// ErrServerOverloaded is used when a request is rejected because the server
+	// is too busy.
+	ErrServerOverloaded = NewError(ServerOverloaded, "JSON-RPC server overloaded")
 )
@@ -0,0 +1,126 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"sync"
+)
+
+// MethodLogMessage is the default method of the notifications sent by
+// ForwardLog, as defined by the LSP specification.
+const MethodLogMessage = "window/logMessage"
+
+// LogMessageParams is the params of the notifications sent by ForwardLog.
+type LogMessageParams struct {
+	// Type is the LSP message type, 4 for a log message.
+	Type int `json:"type"`
+
+	// Message is the line logged.
+	Message string `json:"message"`
+}
+
+// StartCommand starts cmd and returns a connection to its standard input and
+// output, to be framed for a server run as a subprocess.
+//
+// If stderr is not nil, it is called with each line the process writes to its
+// standard error, without the line terminator, in the order they are written,
+// so that the diagnostics of the server can be followed along with the RPC
+// traffic. Otherwise cmd.Stderr is left as is.
+//
+// Closing the connection kills the process and waits for it to exit.
+func StartCommand(cmd *exec.Cmd, stderr func(line string)) (io.ReadWriteCloser, error) {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	var lines *lineWriter
+	if stderr != nil {
+		lines = &lineWriter{fn: stderr}
+		cmd.Stderr = lines
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return &commandConn{
+		ReadCloser:  stdout,
+		WriteCloser: stdin,
+		cmd:         cmd,
+		stderr:      lines,
+	}, nil
+}
+
+// ForwardLog returns a function for StartCommand forwarding each line of the
+// standard error of a subprocess server to the peer of conn, as notifications
+// of method with LogMessageParams, MethodLogMessage if empty.
+func ForwardLog(conn Conn, method string) func(line string) {
+	if method == "" {
+		method = MethodLogMessage
+	}
+	return func(line string) {
+		conn.Notify(context.Background(), method, LogMessageParams{Type: 4, Message: line})
+	}
+}
+
+// commandConn is a connection to the standard input and output of a process.
+type commandConn struct {
+	io.ReadCloser
+	io.WriteCloser
+	cmd    *exec.Cmd
+	stderr *lineWriter // may be nil
+}
+
+// Close implements io.Closer.
+func (c *commandConn) Close() error {
+	err := c.WriteCloser.Close()
+	c.cmd.Process.Kill()
+	c.cmd.Wait()
+	if c.stderr != nil {
+		c.stderr.flush()
+	}
+	return err
+}
+
+// lineWriter is an io.Writer calling fn with each line written.
+type lineWriter struct {
+	mu  sync.Mutex
+	fn  func(line string)
+	buf []byte // the start of the last line, not terminated yet
+}
+
+// Write implements io.Writer.
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.fn(string(bytes.TrimSuffix(w.buf[:i], []byte{'\r'})))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush calls fn with the last line if it was not terminated.
+func (w *lineWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) > 0 {
+		w.fn(string(w.buf))
+		w.buf = nil
+	}
+}
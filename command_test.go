@@ -0,0 +1,55 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"context"
+	"io"
+	"os/exec"
+	"testing"
+
+	"github.com/segmentio/encoding/json"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+func TestStartCommand(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	ctx := context.Background()
+	logged := make(chan jsonrpc2.LogMessageParams, 2)
+	a, b := pipeConns(t)
+	a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+	b.Go(ctx, func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		var params jsonrpc2.LogMessageParams
+		if req.Method() == jsonrpc2.MethodLogMessage && json.Unmarshal(req.Params(), &params) == nil {
+			logged <- params
+		}
+		return reply(ctx, nil, nil)
+	})
+
+	cmd := exec.Command("sh", "-c", "echo one >&2; printf two >&2; cat")
+	rwc, err := jsonrpc2.StartCommand(cmd, jsonrpc2.ForwardLog(a, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(rwc, "ping"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(rwc, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("got %q, %v from the standard output, want ping", buf, err)
+	}
+	rwc.Close()
+
+	for _, want := range []string{"one", "two"} {
+		if got := <-logged; got.Message != want || got.Type != 4 {
+			t.Errorf("got logged %+v, want %s", got, want)
+		}
+	}
+}
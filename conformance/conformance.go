@@ -0,0 +1,131 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package conformance provides a corpus of JSON-RPC wire messages and helpers
+// to check that codecs, framers and other implementations are compatible with
+// the jsonrpc2 package.
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+// Case is a wire message of the corpus.
+type Case struct {
+	// Name describes the message.
+	Name string
+
+	// Body is the JSON encoding of the message, without framing.
+	Body string
+}
+
+// Valid is the corpus of messages that must be accepted.
+var Valid = []Case{
+	{Name: "callNumberID", Body: `{"jsonrpc":"2.0","id":1,"method":"call","params":[1,2]}`},
+	{Name: "callStringID", Body: `{"jsonrpc":"2.0","id":"a1","method":"call","params":{"a":1}}`},
+	{Name: "callNoParams", Body: `{"jsonrpc":"2.0","id":2,"method":"call"}`},
+	{Name: "notification", Body: `{"jsonrpc":"2.0","method":"notify","params":["x"]}`},
+	{Name: "notificationNoParams", Body: `{"jsonrpc":"2.0","method":"notify"}`},
+	{Name: "result", Body: `{"jsonrpc":"2.0","id":3,"result":{"ok":true}}`},
+	{Name: "nullResult", Body: `{"jsonrpc":"2.0","id":4,"result":null}`},
+	{Name: "error", Body: `{"jsonrpc":"2.0","id":5,"error":{"code":-32601,"message":"method not found"}}`},
+	{Name: "errorData", Body: `{"jsonrpc":"2.0","id":"b","error":{"code":-32000,"message":"failed","data":[1]}}`},
+	{Name: "nullIDError", Body: `{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"parse error"}}`},
+	{Name: "unicode", Body: `{"jsonrpc":"2.0","method":"notify","params":["été 😀"]}`},
+}
+
+// Invalid is the corpus of messages that must be rejected.
+var Invalid = []Case{
+	{Name: "empty", Body: ``},
+	{Name: "truncated", Body: `{"jsonrpc":"2.0","id":1,"method":"call"`},
+	{Name: "notObject", Body: `[1,2]`},
+	{Name: "noMethodNoID", Body: `{"jsonrpc":"2.0","result":1}`},
+	{Name: "boolID", Body: `{"jsonrpc":"2.0","id":true,"method":"call"}`},
+}
+
+// RunDecoder checks that decode accepts every Valid message and rejects every
+// Invalid one.
+func RunDecoder(t *testing.T, decode func(data []byte) (jsonrpc2.Message, error)) {
+	t.Helper()
+
+	for _, tt := range Valid {
+		tt := tt
+		t.Run("valid/"+tt.Name, func(t *testing.T) {
+			if _, err := decode([]byte(tt.Body)); err != nil {
+				t.Errorf("got error %v decoding %s", err, tt.Body)
+			}
+		})
+	}
+	for _, tt := range Invalid {
+		tt := tt
+		t.Run("invalid/"+tt.Name, func(t *testing.T) {
+			if msg, err := decode([]byte(tt.Body)); err == nil {
+				t.Errorf("got %#v decoding %s, want an error", msg, tt.Body)
+			}
+		})
+	}
+}
+
+// Run checks that every Valid message written to a stream framed by framer is
+// read back unchanged by a peer using the same framer.
+func Run(t *testing.T, framer jsonrpc2.Framer) {
+	t.Helper()
+
+	for _, tt := range Valid {
+		tt := tt
+		t.Run(tt.Name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			msg, err := jsonrpc2.DecodeMessage([]byte(tt.Body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			aPipe, bPipe := net.Pipe()
+			a, b := framer(aPipe), framer(bPipe)
+			defer a.Close()
+			defer b.Close()
+
+			errc := make(chan error, 1)
+			go func() {
+				_, err := a.Write(ctx, msg)
+				errc <- err
+			}()
+
+			got, _, err := b.Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := <-errc; err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := normalize(t, msg), normalize(t, got); !bytes.Equal(got, want) {
+				t.Errorf("got %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+// normalize returns the canonical JSON encoding of msg.
+func normalize(t *testing.T, msg jsonrpc2.Message) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err = jsonrpc2.Canonicalize(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,52 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package conformance_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/jsonrpc2/conformance"
+)
+
+func TestDecodeMessage(t *testing.T) {
+	conformance.RunDecoder(t, jsonrpc2.DecodeMessage)
+}
+
+func TestFramers(t *testing.T) {
+	tests := map[string]jsonrpc2.Framer{
+		"stream":    jsonrpc2.NewStream,
+		"rawStream": jsonrpc2.NewRawStream,
+		"streaming": jsonrpc2.NewStreamFramer(jsonrpc2.WithStreamingDecode()),
+	}
+	for name, framer := range tests {
+		framer := framer
+		t.Run(name, func(t *testing.T) {
+			conformance.Run(t, framer)
+		})
+	}
+}
+
+func TestRunEcho(t *testing.T) {
+	ctx := context.Background()
+
+	echo := func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		return reply(ctx, req.Params(), nil)
+	}
+
+	aPipe, bPipe := net.Pipe()
+	a := jsonrpc2.NewConn(jsonrpc2.NewStream(aPipe))
+	b := jsonrpc2.NewConn(jsonrpc2.NewStream(bPipe))
+	a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+	b.Go(ctx, echo)
+	defer func() {
+		a.Close()
+		<-a.Done()
+		<-b.Done()
+	}()
+
+	conformance.RunEcho(t, a, "echo")
+}
@@ -0,0 +1,74 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+// echoParams are the params sent to echo peers by RunEcho.
+var echoParams = map[string]interface{}{
+	"array":   []interface{}{1, "two", 3.5, true, nil},
+	"object":  map[string]interface{}{"a": map[string]interface{}{"b": []int{1}}},
+	"unicode": []string{"été 😀", " \t\""},
+	"large":   []string{strings.Repeat("x", 1<<20)},
+}
+
+// RunEcho checks that the peer of conn replies to calls of method with their
+// params, as the echo servers of reference implementations do.
+//
+// It exercises encoding and framing edge cases across implementations.
+func RunEcho(t *testing.T, conn jsonrpc2.Conn, method string) {
+	t.Helper()
+
+	for name, params := range echoParams {
+		params := params
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			want, err := json.Marshal(params)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got json.RawMessage
+			if _, err := conn.Call(ctx, method, params, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(compact(t, got), compact(t, want)) {
+				t.Errorf("got %.100s, want %.100s", got, want)
+			}
+		})
+	}
+}
+
+// compact returns the canonical form of data.
+func compact(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	data, err := jsonrpc2.Canonicalize(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// CommandConn starts cmd and returns a connection to its standard input and
+// output, to be framed for a reference peer speaking over stdio.
+//
+// Closing the connection kills the process and waits for it to exit.
+func CommandConn(cmd *exec.Cmd) (io.ReadWriteCloser, error) {
+	return jsonrpc2.StartCommand(cmd, nil)
+}
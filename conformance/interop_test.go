@@ -0,0 +1,54 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build interop
+// +build interop
+
+package conformance_test
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/jsonrpc2/conformance"
+)
+
+// TestInterop runs the echo checks against the reference peers listed in the
+// JSONRPC2_INTEROP_PEERS environment variable, one command line per line.
+//
+// Each peer must serve an "echo" method over stdio with Content-Length framing,
+// for example a vscode-jsonrpc echo server:
+//
+//	JSONRPC2_INTEROP_PEERS='node echo.js' go test -tags=interop ./conformance
+func TestInterop(t *testing.T) {
+	peers := strings.TrimSpace(os.Getenv("JSONRPC2_INTEROP_PEERS"))
+	if peers == "" {
+		t.Skip("JSONRPC2_INTEROP_PEERS is not set")
+	}
+
+	for _, peer := range strings.Split(peers, "\n") {
+		args := strings.Fields(peer)
+		if len(args) == 0 {
+			continue
+		}
+		t.Run(args[0], func(t *testing.T) {
+			rwc, err := conformance.CommandConn(exec.Command(args[0], args[1:]...))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			conn := jsonrpc2.NewConn(jsonrpc2.NewStream(rwc))
+			conn.Go(context.Background(), jsonrpc2.MethodNotFoundHandler)
+			defer func() {
+				conn.Close()
+				<-conn.Done()
+			}()
+
+			conformance.RunEcho(t, conn, "echo")
+		})
+	}
+}
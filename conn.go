@@ -6,9 +6,12 @@ package jsonrpc2
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"strconv"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/segmentio/encoding/json"
 )
@@ -21,9 +24,10 @@ type Conn interface {
 	// Call invokes the target method and waits for a response.
 	//
 	// The params will be marshaled to JSON before sending over the wire, and will
-	// be handed to the method invoked.
+	// be handed to the method invoked. A json.RawMessage is sent as it is.
 	//
-	// The response will be unmarshaled from JSON into the result.
+	// The response will be unmarshaled from JSON into the result. If result is a
+	// *json.RawMessage, it is set to the bytes of the result as they were read.
 	//
 	// The id returned will be unique from this connection, and can be used for
 	// logging or tracking.
@@ -32,7 +36,7 @@ type Conn interface {
 	// Notify invokes the target method but does not wait for a response.
 	//
 	// The params will be marshaled to JSON before sending over the wire, and will
-	// be handed to the method invoked.
+	// be handed to the method invoked. A json.RawMessage is sent as it is.
 	Notify(ctx context.Context, method string, params interface{}) error
 
 	// Go starts a goroutine to handle the connection.
@@ -40,6 +44,10 @@ type Conn interface {
 	// It must be called exactly once for each Conn. It returns immediately.
 	// Must block on Done() to wait for the connection to shut down.
 	//
+	// The connection is closed when ctx is done, interrupting any pending read.
+	// The contexts passed to handler are done once the connection is closed,
+	// use CloseWhenIdle to let the requests being handled finish first.
+	//
 	// This is a temporary measure, this should be started automatically in the
 	// future.
 	Go(ctx context.Context, handler Handler)
@@ -57,35 +65,320 @@ type Conn interface {
 
 	// Err returns an error if there was one from within the processing goroutine.
 	//
+	// The errors are reported as *OpError. If several occurred, for example a
+	// read error after the Handler failed, they are all joined in order.
+	//
 	// If err returns non nil, the connection will be already closed or closing.
 	Err() error
 }
 
 type conn struct {
-	seq       int32                 // access atomically
-	writeMu   sync.Mutex            // protects writes to the stream
-	stream    Stream                // supplied stream
-	pendingMu sync.Mutex            // protects the pending map
-	pending   map[ID]chan *Response // holds the pending response channel with the ID as the key.
+	seq       int32               // access atomically
+	idStart   int32               // first number of the call IDs
+	idStep    int32               // increment between call IDs
+	idPrefix  string              // prefix of string call IDs, number IDs are used if empty
+	writeMu   sync.Mutex          // protects writes to the stream
+	stream    Stream              // supplied stream
+	pendingMu sync.Mutex          // protects the pending map and closed
+	pending   map[ID]*pendingCall // holds the pending call with the ID as the key.
+	closed    error               // fails new calls once the read loop terminated
+	counter   ByteCounter         // reports the transferred bytes, may be nil
+
+	goroutines    int32         // number of running internal goroutines, access atomically
+	goroutineHook GoroutineHook // reports internal goroutines, may be nil
+
+	recoverDecode bool                     // reply to undecodable messages instead of failing
+	peerClose     bool                     // fail on MethodClose notifications instead of handling them
+	unmatched     UnmatchedResponseHandler // reports responses to unknown calls, may be nil
+
+	undelivered      DeliveryErrorHandler // reports responses that could not be written, may be nil
+	deliveryFailures int64                // number of responses that could not be written, access atomically
+
+	callInterceptors   []CallInterceptor   // applied to outgoing calls
+	notifyInterceptors []NotifyInterceptor // applied to outgoing notifications
+	invokeCall         CallInvoker         // sends a call through the interceptors
+	invokeNotify       NotifyInvoker       // sends a notification through the interceptors
+
+	writeWait      WriteWaitObserver // reports the time messages wait to be written, may be nil
+	doubleBuffered bool              // write the messages through the queue instead of writeMu
+	queueMu        sync.Mutex        // protects queue, spare and flushing
+	queue          []*queuedWrite    // messages waiting to be written
+	spare          []*queuedWrite    // buffer swapped with queue once written
+	flushing       bool              // reports whether a writer is flushing the queue
+
+	closeMu  sync.Mutex // protects onClose, finished and draining
+	onClose  []func()   // run in reverse order once the read loop terminates
+	finished bool       // reports whether the onClose functions were run
+	draining bool       // reports whether the background work is being drained
+
+	idleMu   sync.Mutex      // protects inflight and idle
+	inflight int             // number of requests being handled
+	idle     []chan struct{} // closed once no request is being handled
+
+	background       sync.WaitGroup     // tracks the work started with Background
+	backgroundCtx    context.Context    // passed to the background work
+	backgroundCancel context.CancelFunc // cancels backgroundCtx
+
+	done  chan struct{} // closed when done
+	errMu sync.Mutex    // protects errs
+	errs  []error       // holds the run errors in order
+}
 
-	done chan struct{} // closed when done
-	err  atomic.Value  // holds run error
+// pendingCall is an outgoing call waiting for its response.
+type pendingCall struct {
+	method string
+	rchan  chan *Response
+}
+
+// ConnOption configures a Conn created by NewConn.
+type ConnOption func(*conn)
+
+// ByteCounter is called with the method name and the number of bytes
+// transferred each time a message is sent or received.
+//
+// Responses are accounted to the method of the call they reply to.
+//
+// It is called concurrently from the read loop and from every goroutine
+// writing to the Conn, so it must be safe for concurrent use.
+type ByteCounter func(method string, sent, received int64)
+
+// WithByteCounter returns a ConnOption that reports the size of every message
+// sent or received by the Conn to counter.
+func WithByteCounter(counter ByteCounter) ConnOption {
+	return func(c *conn) {
+		c.counter = counter
+	}
+}
+
+// WithDecodeErrorRecovery returns a ConnOption that keeps the Conn running
+// when a message read cannot be decoded but the stream is still in sync, as
+// reported by Stream.Read.
+//
+// The peer is sent a ParseError or InvalidRequest error response with the
+// NullID, as required by the JSON-RPC spec, accounted to the empty method by
+// the ByteCounter. Other read errors, and a failure to write the response,
+// still make the Conn fail.
+func WithDecodeErrorRecovery() ConnOption {
+	return func(c *conn) {
+		c.recoverDecode = true
+	}
+}
+
+// WithPeerClose returns a ConnOption that makes the Conn stop on the
+// MethodClose notifications sent by CloseWithError, and report the reason
+// they carry in its Err, instead of passing them to the Handler.
+func WithPeerClose() ConnOption {
+	return func(c *conn) {
+		c.peerClose = true
+	}
+}
+
+// UnmatchedResponseHandler is called with the responses read that do not
+// match a pending call, such as duplicate replies or replies arriving after
+// the call was cancelled.
+//
+// It is called from the read loop, so it must not block.
+type UnmatchedResponseHandler func(ctx context.Context, resp *Response)
+
+// WithUnmatchedResponseHandler returns a ConnOption that reports the responses
+// that do not match a pending call to handler, instead of silently dropping
+// them.
+func WithUnmatchedResponseHandler(handler UnmatchedResponseHandler) ConnOption {
+	return func(c *conn) {
+		c.unmatched = handler
+	}
+}
+
+// DeliveryErrorHandler is called with the call and the response to it when
+// writing the response to the stream failed, so the peer never got it.
+//
+// It is called from the goroutine that replied, so it must be safe for
+// concurrent use.
+type DeliveryErrorHandler func(ctx context.Context, call *Call, resp *Response, err error)
+
+// WithDeliveryErrorHandler returns a ConnOption that reports the responses
+// that could not be written to handler. The error is still returned by the
+// Replier.
+func WithDeliveryErrorHandler(handler DeliveryErrorHandler) ConnOption {
+	return func(c *conn) {
+		c.undelivered = handler
+	}
+}
+
+// DeliveryFailures returns the number of responses c failed to write to its
+// stream. It returns 0 for Conns that were not created by NewConn.
+func DeliveryFailures(c Conn) int64 {
+	if c, ok := c.(*conn); ok {
+		return atomic.LoadInt64(&c.deliveryFailures)
+	}
+	return 0
+}
+
+// WithIDSequence returns a ConnOption that numbers the outgoing calls start,
+// start+step, start+2*step and so on, instead of from 1 by 1.
+//
+// Giving each side of a connection a distinct sequence, such as odd and even
+// numbers, keeps the IDs of both directions apart in traces and in proxies
+// merging streams. WithIDSequence panics if step is not positive.
+func WithIDSequence(start, step int32) ConnOption {
+	if step <= 0 {
+		panic(fmt.Sprintf("jsonrpc2: invalid ID step %d", step))
+	}
+	return func(c *conn) {
+		c.idStart, c.idStep = start, step
+	}
+}
+
+// WithIDPrefix returns a ConnOption that makes the outgoing calls use string
+// IDs made of prefix and the call number, such as "c-1".
+func WithIDPrefix(prefix string) ConnOption {
+	return func(c *conn) {
+		c.idPrefix = prefix
+	}
+}
+
+// GoroutineHook is called with running set to true when a goroutine internal
+// to a Conn starts, and with running set to false when it exits.
+//
+// name identifies the role of the goroutine. The hook is called from the
+// goroutines themselves, so it must be safe for concurrent use.
+type GoroutineHook func(name string, running bool)
+
+// WithGoroutineHook returns a ConnOption that reports the internal goroutines
+// of the Conn to hook, so that they can be registered with a leak detector.
+func WithGoroutineHook(hook GoroutineHook) ConnOption {
+	return func(c *conn) {
+		c.goroutineHook = hook
+	}
+}
+
+// Goroutines returns the number of internal goroutines of c that are running.
+//
+// A Conn runs two goroutines once Go is called: the read loop and a watcher of
+// the context given to Go. Both exit shortly after Done is closed. The work
+// started with Background is counted as well. Goroutines returns 0 for Conns
+// that were not created by NewConn.
+func Goroutines(c Conn) int {
+	if c, ok := c.(*conn); ok {
+		return int(atomic.LoadInt32(&c.goroutines))
+	}
+	return 0
+}
+
+// OnClose registers fn to be called once the read loop of c has terminated,
+// so that resources tied to the connection can be released.
+//
+// The functions registered are called in the reverse order of their
+// registration, before Done is closed. fn is called at once if the read loop
+// has already terminated.
+//
+// For Conns that were not created by NewConn, fn is called from a new
+// goroutine once Done is closed.
+func OnClose(c Conn, fn func()) {
+	cc, ok := c.(*conn)
+	if !ok {
+		go func() {
+			<-c.Done()
+			fn()
+		}()
+		return
+	}
+
+	cc.closeMu.Lock()
+	if !cc.finished {
+		cc.onClose = append(cc.onClose, fn)
+		cc.closeMu.Unlock()
+		return
+	}
+	cc.closeMu.Unlock()
+	fn()
+}
+
+// Background runs fn in a goroutine tied to the lifetime of c, for work a
+// handler starts that must not outlive the connection.
+//
+// The context passed to fn is done once the read loop of c has terminated, and
+// Done is not closed before fn returns, so fn must return promptly then. If fn
+// returns an error while c is still open, c fails with an *OpError with Op set
+// to OpBackground. If c is already closed, fn is run with a context that is
+// already done.
+//
+// For Conns that were not created by NewConn, the context passed to fn is done
+// once Done is closed, and the error returned by fn is ignored.
+func Background(c Conn, fn func(ctx context.Context) error) {
+	cc, ok := c.(*conn)
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			select {
+			case <-c.Done():
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		go func() {
+			defer cancel()
+			fn(ctx)
+		}()
+		return
+	}
+
+	cc.closeMu.Lock()
+	if cc.draining {
+		cc.closeMu.Unlock()
+		go fn(cc.backgroundCtx)
+		return
+	}
+	cc.background.Add(1)
+	cc.closeMu.Unlock()
+
+	cc.goroutine("background", func() {
+		defer cc.background.Done()
+		if err := fn(cc.backgroundCtx); err != nil && cc.backgroundCtx.Err() == nil {
+			cc.fail(&OpError{Op: OpBackground, Err: err})
+		}
+	})
 }
 
 // NewConn creates a new connection object around the supplied stream.
-func NewConn(s Stream) Conn {
+func NewConn(s Stream, opts ...ConnOption) Conn {
 	conn := &conn{
 		stream:  s,
-		pending: make(map[ID]chan *Response),
+		idStart: 1,
+		idStep:  1,
+		pending: make(map[ID]*pendingCall),
 		done:    make(chan struct{}),
 	}
+	conn.backgroundCtx, conn.backgroundCancel = context.WithCancel(context.Background())
+	for _, opt := range opts {
+		opt(conn)
+	}
+	conn.invokeCall = chainCallInterceptors(conn.callInterceptors, conn.call)
+	conn.invokeNotify = chainNotifyInterceptors(conn.notifyInterceptors, conn.notify)
 	return conn
 }
 
+// receivedBytesKey is the context key of the request message size.
+type receivedBytesKey struct{}
+
+// ReceivedBytes returns the size in bytes of the request message being handled
+// with ctx, as read from the stream.
+//
+// It returns 0 if ctx was not passed to a Handler by a Conn.
+func ReceivedBytes(ctx context.Context) int64 {
+	n, _ := ctx.Value(receivedBytesKey{}).(int64)
+	return n
+}
+
 // Call implements Conn.
-func (c *conn) Call(ctx context.Context, method string, params, result interface{}) (id ID, err error) {
+func (c *conn) Call(ctx context.Context, method string, params, result interface{}) (ID, error) {
+	return c.invokeCall(ctx, method, params, result)
+}
+
+// call sends the call to the stream and waits for its response.
+func (c *conn) call(ctx context.Context, method string, params, result interface{}) (id ID, err error) {
 	// generate a new request identifier
-	id = NewNumberID(atomic.AddInt32(&c.seq, 1))
+	id = c.nextID()
 	call, err := NewCall(id, method, params)
 	if err != nil {
 		return id, fmt.Errorf("marshaling call parameters: %w", err)
@@ -98,7 +391,11 @@ func (c *conn) Call(ctx context.Context, method string, params, result interface
 	rchan := make(chan *Response, 1)
 
 	c.pendingMu.Lock()
-	c.pending[id] = rchan
+	if err := c.closed; err != nil {
+		c.pendingMu.Unlock()
+		return id, err
+	}
+	c.pending[id] = &pendingCall{method: method, rchan: rchan}
 	c.pendingMu.Unlock()
 
 	defer func() {
@@ -108,11 +405,12 @@ func (c *conn) Call(ctx context.Context, method string, params, result interface
 	}()
 
 	// now we are ready to send
-	_, err = c.write(ctx, call)
+	n, err := c.write(ctx, call)
 	if err != nil {
 		// sending failed, we will never get a response, so don't leave it pending
 		return id, err
 	}
+	c.count(method, n, 0)
 
 	// now wait for the response
 	select {
@@ -126,6 +424,13 @@ func (c *conn) Call(ctx context.Context, method string, params, result interface
 			return id, nil
 		}
 
+		if raw, ok := result.(*json.RawMessage); ok {
+			// hand the result over without a decoding round trip, so that
+			// proxies can forward it as it is
+			*raw = resp.result
+			return id, nil
+		}
+
 		dec := json.NewDecoder(bytes.NewReader(resp.result))
 		dec.ZeroCopy()
 		if err := dec.Decode(result); err != nil {
@@ -139,16 +444,34 @@ func (c *conn) Call(ctx context.Context, method string, params, result interface
 	}
 }
 
+// nextID returns the ID of a new outgoing call.
+func (c *conn) nextID() ID {
+	number := c.idStart + (atomic.AddInt32(&c.seq, 1)-1)*c.idStep
+	if c.idPrefix != "" {
+		return NewStringID(c.idPrefix + strconv.FormatInt(int64(number), 10))
+	}
+	return NewNumberID(number)
+}
+
 // Notify implements Conn.
-func (c *conn) Notify(ctx context.Context, method string, params interface{}) (err error) {
+func (c *conn) Notify(ctx context.Context, method string, params interface{}) error {
+	return c.invokeNotify(ctx, method, params)
+}
+
+// notify sends the notification to the stream.
+func (c *conn) notify(ctx context.Context, method string, params interface{}) (err error) {
 	notify, err := NewNotification(method, params)
 	if err != nil {
 		return fmt.Errorf("marshaling notify parameters: %w", err)
 	}
 
-	_, err = c.write(ctx, notify)
+	n, err := c.write(ctx, notify)
+	if err != nil {
+		return err
+	}
+	c.count(method, n, 0)
 
-	return err
+	return nil
 }
 
 func (c *conn) replier(req Message) Replier {
@@ -161,22 +484,48 @@ func (c *conn) replier(req Message) Replier {
 
 		response, err := NewResponse(call.id, result, err)
 		if err != nil {
+			// the result could not be marshaled, still reply so that the peer
+			// is not left waiting
+			failure := &Error{Code: InternalError, Message: err.Error()}
+			var werr *Error
+			if errors.As(err, &werr) {
+				failure.Data = werr.Data
+			}
+			if n, err := c.write(ctx, &Response{id: call.id, err: failure}); err == nil {
+				c.count(call.method, n, 0)
+			}
 			return err
 		}
 
-		_, err = c.write(ctx, response)
+		n, err := c.write(ctx, response)
 		if err != nil {
 			// TODO(iancottrell): if a stream write fails, we really need to shut down the whole stream
+			atomic.AddInt64(&c.deliveryFailures, 1)
+			if c.undelivered != nil {
+				c.undelivered(ctx, call, response, err)
+			}
 			return err
 		}
+		c.count(call.method, n, 0)
 		return nil
 	}
 }
 
-func (c *conn) write(ctx context.Context, msg Message) (int64, error) {
-	c.writeMu.Lock()
-	n, err := c.stream.Write(ctx, msg)
-	c.writeMu.Unlock()
+func (c *conn) write(ctx context.Context, msg Message) (n int64, err error) {
+	if c.doubleBuffered {
+		n, err = c.writeQueued(ctx, msg)
+	} else {
+		var start time.Time
+		if c.writeWait != nil {
+			start = time.Now()
+		}
+		c.writeMu.Lock()
+		if c.writeWait != nil {
+			c.writeWait(msg, time.Since(start))
+		}
+		n, err = c.stream.Write(ctx, msg)
+		c.writeMu.Unlock()
+	}
 	if err != nil {
 		return 0, fmt.Errorf("write to stream: %w", err)
 	}
@@ -184,42 +533,283 @@ func (c *conn) write(ctx context.Context, msg Message) (int64, error) {
 	return n, nil
 }
 
+// count reports the transferred bytes for method to the ByteCounter, if any.
+func (c *conn) count(method string, sent, received int64) {
+	if c.counter != nil {
+		c.counter(method, sent, received)
+	}
+}
+
 // Go implements Conn.
 func (c *conn) Go(ctx context.Context, handler Handler) {
-	go c.run(ctx, handler)
+	// Stream.Read only checks ctx before blocking on I/O, so close the stream
+	// when ctx is done to interrupt a pending read.
+	var interrupted int32 // access atomically
+	stop := make(chan struct{})
+	c.goroutine("interrupt", func() {
+		select {
+		case <-ctx.Done():
+			atomic.StoreInt32(&interrupted, 1)
+			c.stream.Close()
+		case <-stop:
+		}
+	})
+
+	c.goroutine("run", func() {
+		defer close(stop)
+		c.run(ctx, handler, &interrupted)
+	})
+}
+
+// goroutine runs fn in an internal goroutine identified by name.
+func (c *conn) goroutine(name string, fn func()) {
+	atomic.AddInt32(&c.goroutines, 1)
+	if c.goroutineHook != nil {
+		c.goroutineHook(name, true)
+	}
+
+	go func() {
+		defer func() {
+			atomic.AddInt32(&c.goroutines, -1)
+			if c.goroutineHook != nil {
+				c.goroutineHook(name, false)
+			}
+		}()
+		fn()
+	}()
 }
 
-func (c *conn) run(ctx context.Context, handler Handler) {
+// run reads and dispatches messages until the stream fails.
+//
+// interrupted is set when the stream was closed because ctx is done.
+func (c *conn) run(ctx context.Context, handler Handler, interrupted *int32) {
 	defer close(c.done)
+	defer c.finish()
+	defer c.drain()
+	defer c.failPending()
+
+	// the requests being handled are canceled once the stream failed
+	handlerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	for {
 		// get the next message
-		msg, _, err := c.stream.Read(ctx)
+		msg, n, err := c.stream.Read(ctx)
+		if err != nil && c.recoverDecode && atomic.LoadInt32(interrupted) == 0 {
+			if rerr := decodeError(err); rerr != nil {
+				// a broken stream makes the next read fail
+				written, werr := c.write(ctx, &Response{id: NullID, err: rerr})
+				if werr != nil {
+					c.fail(&OpError{Op: OpWrite, Err: werr})
+					return
+				}
+				c.count("", written, n)
+				continue
+			}
+		}
 		if err != nil {
+			if atomic.LoadInt32(interrupted) == 1 {
+				// the read was interrupted by closing the stream on cancellation
+				err = ctx.Err()
+			}
 			// The stream failed, we cannot continue.
-			c.fail(err)
+			c.fail(&OpError{Op: OpRead, Err: err})
 			return
 		}
 
 		switch msg := msg.(type) {
-		case Request:
-			if err := handler(ctx, c.replier(msg), msg); err != nil {
-				c.fail(err)
+		case *Notification:
+			if !c.peerClose || msg.method != MethodClose {
+				c.handle(handlerCtx, handler, msg, n)
+				break
+			}
+			c.count(msg.method, 0, n)
+			reason := &Error{}
+			if err := json.Unmarshal(msg.params, reason); err != nil {
+				reason = Errorf(InternalError, "invalid close reason: %v", err)
 			}
+			c.fail(&OpError{Op: OpPeerClose, Err: reason})
+
+		case Request:
+			c.handle(handlerCtx, handler, msg, n)
 
 		case *Response:
 			// If method is not set, this should be a response, in which case we must
 			// have an id to send the response back to the caller.
 			c.pendingMu.Lock()
-			rchan, ok := c.pending[msg.id]
+			pc, ok := c.pending[msg.id]
 			c.pendingMu.Unlock()
-			if ok {
-				rchan <- msg
+			switch {
+			case ok:
+				c.count(pc.method, 0, n)
+				pc.rchan <- msg
+			case c.unmatched != nil:
+				c.unmatched(ctx, msg)
 			}
 		}
 	}
 }
 
+// decodeError returns the error to reply with to a message that Stream.Read
+// could not decode, or nil if the stream is out of sync.
+func decodeError(err error) *Error {
+	switch {
+	case errors.Is(err, ErrParse):
+		return NewError(ParseError, err.Error())
+	case errors.Is(err, ErrInvalidRequest):
+		return NewError(InvalidRequest, err.Error())
+	default:
+		return nil
+	}
+}
+
+// handle passes the request read from the stream to handler.
+//
+// A call is handled until it is replied to, or until handler returns an error
+// without replying, and a notification until handler returns.
+func (c *conn) handle(ctx context.Context, handler Handler, req Request, n int64) {
+	c.count(req.Method(), 0, n)
+	reqCtx := context.WithValue(ctx, receivedBytesKey{}, n)
+
+	c.begin()
+	reply := c.replier(req)
+	_, isCall := req.(*Call)
+	var handled int32 // access atomically
+	end := func() {
+		if atomic.CompareAndSwapInt32(&handled, 0, 1) {
+			c.end()
+		}
+	}
+	if isCall {
+		innerReply := reply
+		reply = func(ctx context.Context, result interface{}, err error) error {
+			defer end()
+			return innerReply(ctx, result, err)
+		}
+	}
+
+	err := handler(reqCtx, reply, req)
+	if !isCall || err != nil {
+		end()
+	}
+	if err != nil {
+		c.fail(&OpError{Op: OpHandle, Err: err})
+	}
+}
+
+// begin records that a request is being handled.
+func (c *conn) begin() {
+	c.idleMu.Lock()
+	c.inflight++
+	c.idleMu.Unlock()
+}
+
+// end records that a request was handled, and wakes up the waiters for idle
+// once none is left.
+func (c *conn) end() {
+	c.idleMu.Lock()
+	defer c.idleMu.Unlock()
+
+	c.inflight--
+	if c.inflight > 0 {
+		return
+	}
+	for _, ch := range c.idle {
+		close(ch)
+	}
+	c.idle = nil
+}
+
+// idleCh returns a channel closed once no request is being handled.
+func (c *conn) idleCh() <-chan struct{} {
+	c.idleMu.Lock()
+	defer c.idleMu.Unlock()
+
+	ch := make(chan struct{})
+	if c.inflight == 0 {
+		close(ch)
+		return ch
+	}
+	c.idle = append(c.idle, ch)
+	return ch
+}
+
+// failPending fails the pending calls, and the calls made later, with
+// ErrConnectionClosed joined with the errors of c.
+func (c *conn) failPending() {
+	err := joinedError{ErrConnectionClosed}
+	if cerr := c.Err(); cerr != nil {
+		err = append(err, cerr)
+	}
+
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+	c.closed = err
+	for _, pc := range c.pending {
+		// rchan has room for a response, and only one is ever delivered by the
+		// read loop, which has terminated
+		select {
+		case pc.rchan <- &Response{err: err}:
+		default:
+		}
+	}
+}
+
+// drain cancels the work started with Background and waits for it to return.
+func (c *conn) drain() {
+	c.closeMu.Lock()
+	c.draining = true
+	c.closeMu.Unlock()
+
+	c.backgroundCancel()
+	c.background.Wait()
+}
+
+// finish calls the functions registered with OnClose, last registered first.
+func (c *conn) finish() {
+	c.closeMu.Lock()
+	fns := c.onClose
+	c.onClose, c.finished = nil, true
+	c.closeMu.Unlock()
+
+	for i := len(fns) - 1; i >= 0; i-- {
+		fns[i]()
+	}
+}
+
+// CloseWithError informs the peer of c why the connection is going away with
+// a MethodClose notification, then closes c.
+//
+// A Conn created by NewConn with WithPeerClose reports the reason it receives
+// in its Err, as an *OpError with Op set to OpPeerClose wrapping an *Error with
+// code and message. Other Conns pass the notification to their Handler.
+func CloseWithError(ctx context.Context, c Conn, code Code, message string) error {
+	err := c.Notify(ctx, MethodClose, NewError(code, message))
+	if cerr := c.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// CloseWhenIdle waits for the requests c is handling to be processed, then
+// closes c. A call is processed once it is replied to, and a notification once
+// the Handler returns, so notifications queued by an AsyncHandler are not
+// waited for.
+//
+// If ctx is done first, c is closed at once, which cancels the contexts of
+// the requests being handled. For Conns that were not created by NewConn, c is
+// closed at once.
+func CloseWhenIdle(ctx context.Context, c Conn) error {
+	if cc, ok := c.(*conn); ok {
+		select {
+		case <-cc.idleCh():
+		case <-cc.done:
+		case <-ctx.Done():
+		}
+	}
+	return c.Close()
+}
+
 // Close implements Conn.
 func (c *conn) Close() error {
 	return c.stream.Close()
@@ -232,14 +822,23 @@ func (c *conn) Done() <-chan struct{} {
 
 // Err implements Conn.
 func (c *conn) Err() error {
-	if err := c.err.Load(); err != nil {
-		return err.(error)
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+
+	switch len(c.errs) {
+	case 0:
+		return nil
+	case 1:
+		return c.errs[0]
+	default:
+		return joinedError(append([]error(nil), c.errs...))
 	}
-	return nil
 }
 
 // fail sets a failure condition on the stream and closes it.
 func (c *conn) fail(err error) {
-	c.err.Store(err)
+	c.errMu.Lock()
+	c.errs = append(c.errs, err)
+	c.errMu.Unlock()
 	c.stream.Close()
 }
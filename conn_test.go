@@ -0,0 +1,602 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+type byteCount struct {
+	mu       sync.Mutex
+	sent     map[string]int64
+	received map[string]int64
+}
+
+func newByteCount() *byteCount {
+	return &byteCount{
+		sent:     make(map[string]int64),
+		received: make(map[string]int64),
+	}
+}
+
+func (bc *byteCount) count(method string, sent, received int64) {
+	bc.mu.Lock()
+	bc.sent[method] += sent
+	bc.received[method] += received
+	bc.mu.Unlock()
+}
+
+func TestByteCounter(t *testing.T) {
+	ctx := context.Background()
+
+	aPipe, bPipe := net.Pipe()
+	aCount, bCount := newByteCount(), newByteCount()
+	a := jsonrpc2.NewConn(jsonrpc2.NewStream(aPipe), jsonrpc2.WithByteCounter(aCount.count))
+	b := jsonrpc2.NewConn(jsonrpc2.NewStream(bPipe), jsonrpc2.WithByteCounter(bCount.count))
+
+	var handlerBytes int64
+	a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+	b.Go(ctx, func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		handlerBytes = jsonrpc2.ReceivedBytes(ctx)
+		return reply(ctx, "pong", nil)
+	})
+
+	var got string
+	_, err := a.Call(ctx, "ping", "ping", &got)
+	a.Close()
+	b.Close()
+	<-a.Done()
+	<-b.Done()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if aCount.sent["ping"] == 0 || aCount.sent["ping"] != bCount.received["ping"] {
+		t.Errorf("call bytes: sent %d, received %d", aCount.sent["ping"], bCount.received["ping"])
+	}
+	if bCount.sent["ping"] == 0 || bCount.sent["ping"] != aCount.received["ping"] {
+		t.Errorf("response bytes: sent %d, received %d", bCount.sent["ping"], aCount.received["ping"])
+	}
+	if handlerBytes != bCount.received["ping"] {
+		t.Errorf("ReceivedBytes: got %d want %d", handlerBytes, bCount.received["ping"])
+	}
+}
+
+func TestConnContextCancel(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	aPipe, bPipe := net.Pipe()
+	defer bPipe.Close()
+	a := jsonrpc2.NewConn(jsonrpc2.NewStream(aPipe))
+	a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+
+	// nothing is ever written to bPipe, so a is blocked reading
+	cancel()
+
+	select {
+	case <-a.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection did not shut down after cancellation")
+	}
+
+	if err := a.Err(); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestOnClose(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	a, b := pipeConns(t)
+
+	var order []int
+	jsonrpc2.OnClose(a, func() { order = append(order, 1) })
+	jsonrpc2.OnClose(a, func() { order = append(order, 2) })
+
+	a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+	b.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+	a.Close()
+	<-a.Done()
+	<-b.Done()
+
+	if len(order) != 2 || order[0] != 2 || order[1] != 1 {
+		t.Errorf("got cleanup order %v, want [2 1]", order)
+	}
+
+	late := false
+	jsonrpc2.OnClose(a, func() { late = true })
+	if !late {
+		t.Error("cleanup registered after close was not called")
+	}
+}
+
+func TestConnErrors(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	errHandler := errors.New("handler failed")
+	failing := func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		return errHandler
+	}
+
+	a, b := pipeConns(t)
+	a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+	b.Go(ctx, failing)
+
+	if err := a.Notify(ctx, "fail", nil); err != nil {
+		t.Fatal(err)
+	}
+	<-b.Done()
+	a.Close()
+	<-a.Done()
+
+	err := b.Err()
+	if !errors.Is(err, errHandler) {
+		t.Fatalf("got %v, want the handler error", err)
+	}
+	var opErr *jsonrpc2.OpError
+	if !errors.As(err, &opErr) || opErr.Op != jsonrpc2.OpHandle {
+		t.Errorf("got %#v, want the first error to be a %s error", opErr, jsonrpc2.OpHandle)
+	}
+	if !strings.Contains(err.Error(), jsonrpc2.OpRead+": ") {
+		t.Errorf("got %v, want the read error to be kept", err)
+	}
+}
+
+func TestCloseWithError(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	b, a := pipeConns(t, jsonrpc2.WithPeerClose())
+	a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+	b.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+
+	if err := jsonrpc2.CloseWithError(ctx, a, jsonrpc2.UnknownError, "shutting down"); err != nil {
+		t.Fatal(err)
+	}
+	<-a.Done()
+	<-b.Done()
+
+	var opErr *jsonrpc2.OpError
+	if !errors.As(b.Err(), &opErr) || opErr.Op != jsonrpc2.OpPeerClose {
+		t.Fatalf("got %v, want a %s error", b.Err(), jsonrpc2.OpPeerClose)
+	}
+	var reason *jsonrpc2.Error
+	if !errors.As(opErr, &reason) || reason.Code != jsonrpc2.UnknownError || reason.Message != "shutting down" {
+		t.Errorf("got reason %#v, want the reason sent", reason)
+	}
+
+	// without WithPeerClose, the notification is handled as any other
+	c, d := pipeConns(t)
+	handled := make(chan string, 1)
+	c.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+	d.Go(ctx, func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		handled <- req.Method()
+		return reply(ctx, nil, nil)
+	})
+	if err := jsonrpc2.CloseWithError(ctx, c, jsonrpc2.UnknownError, "shutting down"); err != nil {
+		t.Fatal(err)
+	}
+	if method := <-handled; method != jsonrpc2.MethodClose {
+		t.Errorf("got %q handled, want %q", method, jsonrpc2.MethodClose)
+	}
+	<-d.Done()
+	var dErr *jsonrpc2.OpError
+	if errors.As(d.Err(), &dErr) && dErr.Op == jsonrpc2.OpPeerClose {
+		t.Errorf("got %v, want no %s error", d.Err(), jsonrpc2.OpPeerClose)
+	}
+}
+
+func TestDecodeErrorRecovery(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	aPipe, bPipe := net.Pipe()
+	count := newByteCount()
+	b := jsonrpc2.NewConn(jsonrpc2.NewStream(bPipe), jsonrpc2.WithDecodeErrorRecovery(), jsonrpc2.WithByteCounter(count.count))
+	b.Go(ctx, testHandler())
+	defer func() {
+		aPipe.Close()
+		<-b.Done()
+	}()
+
+	go func() {
+		io.WriteString(aPipe, "Content-Length: 5\r\n\r\n{oops")
+		io.WriteString(aPipe, "Content-Length: 17\r\n\r\n{\"jsonrpc\":\"2.0\"}")
+		call, _ := jsonrpc2.NewCall(jsonrpc2.NewNumberID(1), methodOneString, "fish")
+		jsonrpc2.NewStream(aPipe).Write(ctx, call)
+	}()
+
+	a := jsonrpc2.NewStream(aPipe)
+	for _, want := range []string{`"code":-32700`, `"code":-32600`, `"result":"got:fish"`} {
+		msg, _, err := a.Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := json.Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), want) {
+			t.Errorf("got %s, want %s", data, want)
+		}
+		if resp := msg.(*jsonrpc2.Response); resp.Err() != nil && resp.ID() != jsonrpc2.NullID {
+			t.Errorf("got error response with id %v, want null", resp.ID())
+		}
+	}
+
+	count.mu.Lock()
+	defer count.mu.Unlock()
+	if count.sent[""] == 0 || count.received[""] == 0 {
+		t.Errorf("got %d bytes sent and %d received for the error responses, want both counted",
+			count.sent[""], count.received[""])
+	}
+}
+
+func TestUnmatchedResponseHandler(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	unmatched := make(chan jsonrpc2.ID, 1)
+	report := func(ctx context.Context, resp *jsonrpc2.Response) {
+		unmatched <- resp.ID()
+	}
+
+	aPipe, bPipe := net.Pipe()
+	b := jsonrpc2.NewConn(jsonrpc2.NewStream(bPipe), jsonrpc2.WithUnmatchedResponseHandler(report))
+	b.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+	defer func() {
+		aPipe.Close()
+		<-b.Done()
+	}()
+
+	resp, err := jsonrpc2.NewResponse(jsonrpc2.NewNumberID(42), "late", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jsonrpc2.NewStream(aPipe).Write(ctx, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case id := <-unmatched:
+		if id != jsonrpc2.NewNumberID(42) {
+			t.Errorf("got unmatched response %v, want 42", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("unmatched response was not reported")
+	}
+}
+
+// failingWrites is a stream that fails to write responses.
+type failingWrites struct {
+	jsonrpc2.Stream
+}
+
+func (s failingWrites) Write(ctx context.Context, msg jsonrpc2.Message) (int64, error) {
+	if _, ok := msg.(*jsonrpc2.Response); ok {
+		return 0, io.ErrClosedPipe
+	}
+	return s.Stream.Write(ctx, msg)
+}
+
+func TestDecodeErrorRecoveryWriteFailure(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	aPipe, bPipe := net.Pipe()
+	defer aPipe.Close()
+	b := jsonrpc2.NewConn(failingWrites{jsonrpc2.NewStream(bPipe)}, jsonrpc2.WithDecodeErrorRecovery())
+	b.Go(ctx, testHandler())
+
+	go io.WriteString(aPipe, "Content-Length: 5\r\n\r\n{oops")
+	<-b.Done()
+
+	var opErr *jsonrpc2.OpError
+	if err := b.Err(); !errors.As(err, &opErr) || opErr.Op != jsonrpc2.OpWrite || !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("got %v, want a %s error wrapping %v", err, jsonrpc2.OpWrite, io.ErrClosedPipe)
+	}
+}
+
+func TestDeliveryErrorHandler(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	undelivered := make(chan jsonrpc2.ID, 1)
+	report := func(ctx context.Context, call *jsonrpc2.Call, resp *jsonrpc2.Response, err error) {
+		if !errors.Is(err, io.ErrClosedPipe) || resp.ID() != call.ID() {
+			t.Errorf("got %v for response %v to call %v", err, resp.ID(), call.ID())
+		}
+		undelivered <- call.ID()
+	}
+	replyErr := make(chan error, 1)
+	handler := func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		replyErr <- reply(ctx, "lost", nil)
+		return nil
+	}
+
+	aPipe, bPipe := net.Pipe()
+	b := jsonrpc2.NewConn(failingWrites{jsonrpc2.NewStream(bPipe)}, jsonrpc2.WithDeliveryErrorHandler(report))
+	b.Go(ctx, handler)
+	defer func() {
+		aPipe.Close()
+		<-b.Done()
+	}()
+
+	call, _ := jsonrpc2.NewCall(jsonrpc2.NewNumberID(7), "method", nil)
+	if _, err := jsonrpc2.NewStream(aPipe).Write(ctx, call); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-replyErr; !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("got reply error %v, want %v", err, io.ErrClosedPipe)
+	}
+	if id := <-undelivered; id != call.ID() {
+		t.Errorf("got undelivered response to %v, want %v", id, call.ID())
+	}
+	if got := jsonrpc2.DeliveryFailures(b); got != 1 {
+		t.Errorf("got %d delivery failures, want 1", got)
+	}
+}
+
+func TestCloseWhenIdle(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	for name, drain := range map[string]bool{"drain": true, "abort": false} {
+		drain := drain
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			started, release := make(chan struct{}), make(chan struct{})
+			canceled := make(chan bool, 1)
+			handler := func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+				close(started)
+				select {
+				case <-release:
+					canceled <- false
+				case <-ctx.Done():
+					canceled <- true
+				}
+				return reply(ctx, nil, nil)
+			}
+
+			a, b := pipeConns(t)
+			a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+			b.Go(ctx, jsonrpc2.AsyncHandler(handler))
+
+			called := make(chan error, 1)
+			go func() {
+				_, err := a.Call(ctx, "slow", nil, nil)
+				called <- err
+			}()
+			<-started
+
+			closeCtx, cancel := context.WithCancel(ctx)
+			if drain {
+				time.AfterFunc(10*time.Millisecond, func() { close(release) })
+			} else {
+				cancel()
+			}
+			if err := jsonrpc2.CloseWhenIdle(closeCtx, b); err != nil {
+				t.Fatal(err)
+			}
+			cancel()
+
+			if got := <-canceled; got == drain {
+				t.Errorf("got handler context canceled %v, want %v", got, !drain)
+			}
+			if drain {
+				if err := <-called; err != nil {
+					t.Errorf("got %v for a call drained on close", err)
+				}
+			}
+			a.Close()
+			<-a.Done()
+			<-b.Done()
+		})
+	}
+}
+
+func TestConnectionClosed(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	received := make(chan struct{})
+	handler := func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		close(received)
+		return nil // never replied to
+	}
+
+	a, b := pipeConns(t)
+	a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+	b.Go(ctx, handler)
+
+	called := make(chan error, 1)
+	go func() {
+		_, err := a.Call(ctx, "pending", nil, nil)
+		called <- err
+	}()
+	<-received
+	b.Close()
+	<-a.Done()
+	<-b.Done()
+
+	var operr *jsonrpc2.OpError
+	err := <-called
+	if !errors.Is(err, jsonrpc2.ErrConnectionClosed) || !errors.As(err, &operr) || operr.Op != jsonrpc2.OpRead {
+		t.Errorf("got %v for a pending call, want %v with the read error", err, jsonrpc2.ErrConnectionClosed)
+	}
+	if _, err := a.Call(ctx, "late", nil, nil); !errors.Is(err, jsonrpc2.ErrConnectionClosed) {
+		t.Errorf("got %v for a call after close, want %v", err, jsonrpc2.ErrConnectionClosed)
+	}
+}
+
+// panicking panics when it is marshaled.
+type panicking struct{}
+
+func (panicking) MarshalJSON() ([]byte, error) { panic("broken marshaler") }
+
+func TestMarshalPanic(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	replyErr := make(chan error, 1)
+	handler := func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		replyErr <- reply(ctx, panicking{}, nil)
+		return nil
+	}
+
+	a, b := pipeConns(t)
+	a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+	b.Go(ctx, handler)
+
+	if _, err := a.Call(ctx, "method", panicking{}, nil); err == nil || !strings.Contains(err.Error(), "broken marshaler") {
+		t.Errorf("got %v for params panicking, want the panic as error", err)
+	}
+
+	var werr *jsonrpc2.Error
+	_, err := a.Call(ctx, "method", nil, nil)
+	if !errors.As(err, &werr) || werr.Code != jsonrpc2.InternalError {
+		t.Fatalf("got %v for a result panicking, want an internal error", err)
+	}
+	if data, ok := werr.ErrorData(); !ok || len(data.Details) != 1 || data.Details[0] != "broken marshaler" {
+		t.Errorf("got error data %s, want the panic value", *werr.Data)
+	}
+	if err := <-replyErr; err == nil {
+		t.Error("got no error from the reply of a result panicking")
+	}
+}
+
+func TestIDPartitioning(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		opts []jsonrpc2.ConnOption
+		want []jsonrpc2.ID
+	}{
+		"default": {
+			want: []jsonrpc2.ID{jsonrpc2.NewNumberID(1), jsonrpc2.NewNumberID(2)},
+		},
+		"even": {
+			opts: []jsonrpc2.ConnOption{jsonrpc2.WithIDSequence(2, 2)},
+			want: []jsonrpc2.ID{jsonrpc2.NewNumberID(2), jsonrpc2.NewNumberID(4)},
+		},
+		"prefix": {
+			opts: []jsonrpc2.ConnOption{jsonrpc2.WithIDPrefix("c-")},
+			want: []jsonrpc2.ID{jsonrpc2.NewStringID("c-1"), jsonrpc2.NewStringID("c-2")},
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			a, b := pipeConns(t, tt.opts...)
+			a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+			b.Go(ctx, testHandler())
+
+			for _, want := range tt.want {
+				var got string
+				id, err := a.Call(ctx, methodOneString, "fish", &got)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if id != want {
+					t.Errorf("got id %q, want %q", id, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRawResultForwarding(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	raw := json.RawMessage(`{"html":"<b>",  "spaced":true}`)
+	reply := func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		return reply(ctx, raw, nil)
+	}
+
+	a, b := pipeConns(t)
+	a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+	b.Go(ctx, reply)
+
+	var got json.RawMessage
+	if _, err := a.Call(ctx, "forward", nil, &got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(raw) {
+		t.Errorf("got result %s, want %s unchanged", got, raw)
+	}
+}
+
+func TestBackground(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	a, b := pipeConns(t)
+	a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+	b.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+
+	started := make(chan struct{})
+	var stopped int32
+	jsonrpc2.Background(a, func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		time.Sleep(10 * time.Millisecond)
+		atomic.StoreInt32(&stopped, 1)
+		return ctx.Err()
+	})
+	<-started
+
+	a.Close()
+	<-a.Done()
+	<-b.Done()
+	if atomic.LoadInt32(&stopped) != 1 {
+		t.Error("Done was closed before the background work returned")
+	}
+	if err := a.Err(); errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want the background cancellation ignored", err)
+	}
+
+	late := make(chan error)
+	jsonrpc2.Background(a, func(ctx context.Context) error {
+		late <- ctx.Err()
+		return nil
+	})
+	if err := <-late; err == nil {
+		t.Error("background work started after close got a live context")
+	}
+
+	errBackground := errors.New("background failed")
+	a, b = pipeConns(t)
+	a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+	b.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+	jsonrpc2.Background(a, func(ctx context.Context) error { return errBackground })
+	<-a.Done()
+	<-b.Done()
+
+	var operr *jsonrpc2.OpError
+	if err := a.Err(); !errors.As(err, &operr) || operr.Op != jsonrpc2.OpBackground || !errors.Is(err, errBackground) {
+		t.Errorf("got error %v, want %s: %v", err, jsonrpc2.OpBackground, errBackground)
+	}
+}
@@ -0,0 +1,103 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Endpoint is a network address a server may be reached at.
+type Endpoint struct {
+	// Network is the network name as accepted by net.Dial, such as "tcp" or "unix".
+	Network string
+
+	// Address is the address on Network.
+	Address string
+}
+
+// String implements fmt.Stringer.
+func (e Endpoint) String() string {
+	return e.Network + ":" + e.Address
+}
+
+// DialFirst dials the endpoints in order and returns the first connection
+// established.
+//
+// Each endpoint is given delay to connect before the next one is dialed in
+// parallel, and the next one is dialed at once if it fails. Connections
+// established after the first one are closed. If every endpoint fails,
+// DialFirst returns the error of the first one.
+func DialFirst(ctx context.Context, delay time.Duration, endpoints ...Endpoint) (net.Conn, error) {
+	if len(endpoints) == 0 {
+		return nil, errors.New("no endpoints to dial")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		index int
+		conn  net.Conn
+		err   error
+	}
+	results := make(chan result, len(endpoints))
+
+	var dialer net.Dialer
+	next, pending := 0, 0
+	dialNext := func() {
+		i, e := next, endpoints[next]
+		next++
+		pending++
+		go func() {
+			conn, err := dialer.DialContext(ctx, e.Network, e.Address)
+			if err != nil {
+				err = fmt.Errorf("dial %s: %w", e, err)
+			}
+			results <- result{index: i, conn: conn, err: err}
+		}()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	errs := make([]error, len(endpoints))
+	dialNext()
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				// close the connections that are still being established
+				go func(pending int) {
+					for ; pending > 0; pending-- {
+						if r := <-results; r.conn != nil {
+							r.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, nil
+			}
+			errs[r.index] = r.err
+			if next < len(endpoints) {
+				if !timer.Stop() {
+					<-timer.C
+				}
+				dialNext()
+				timer.Reset(delay)
+			}
+
+		case <-timer.C:
+			if next < len(endpoints) {
+				dialNext()
+				timer.Reset(delay)
+			}
+		}
+	}
+
+	return nil, errs[0]
+}
@@ -0,0 +1,43 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+func TestDialFirst(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	missing := jsonrpc2.Endpoint{Network: "unix", Address: filepath.Join(t.TempDir(), "missing.sock")}
+	listening := jsonrpc2.Endpoint{Network: "tcp", Address: ln.Addr().String()}
+
+	conn, err := jsonrpc2.DialFirst(ctx, time.Minute, missing, listening)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if got := conn.RemoteAddr().String(); got != listening.Address {
+		t.Errorf("got connection to %s, want %s", got, listening.Address)
+	}
+
+	if _, err := jsonrpc2.DialFirst(ctx, time.Minute, missing); err == nil {
+		t.Error("got nil error dialing a missing endpoint")
+	}
+}
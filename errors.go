@@ -6,6 +6,8 @@ package jsonrpc2
 import (
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/segmentio/encoding/json"
 )
@@ -55,6 +57,89 @@ func Errorf(c Code, format string, args ...interface{}) *Error {
 	}
 }
 
+// ErrorData is a convention for the structured data of errors, so that the
+// clients of different servers can decide alike whether to retry a call. It is
+// encoded as:
+//
+//	{"retryable": true, "retryAfter": 500, "details": ["index is rebuilding"]}
+type ErrorData struct {
+	// Retryable reports whether the call may succeed if it is sent again.
+	Retryable bool
+
+	// RetryAfter is the time to wait before sending the call again, encoded
+	// in milliseconds.
+	RetryAfter time.Duration
+
+	// Details describes the error further.
+	Details []string
+}
+
+// errorData is the wire form of ErrorData.
+type errorData struct {
+	Retryable  bool     `json:"retryable,omitempty"`
+	RetryAfter int64    `json:"retryAfter,omitempty"`
+	Details    []string `json:"details,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d ErrorData) MarshalJSON() ([]byte, error) {
+	return json.Marshal(errorData{
+		Retryable:  d.Retryable,
+		RetryAfter: d.RetryAfter.Milliseconds(),
+		Details:    d.Details,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *ErrorData) UnmarshalJSON(data []byte) error {
+	var w errorData
+	if err := json.Unmarshal(data, &w); err != nil {
+		return err
+	}
+	*d = ErrorData{
+		Retryable:  w.Retryable,
+		RetryAfter: time.Duration(w.RetryAfter) * time.Millisecond,
+		Details:    w.Details,
+	}
+	return nil
+}
+
+// WithData returns a copy of e with v encoded in its Data, such as an
+// ErrorData.
+func (e *Error) WithData(v interface{}) (*Error, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling error data: %w", err)
+	}
+	raw := json.RawMessage(data)
+	return &Error{Code: e.Code, Message: e.Message, Data: &raw}, nil
+}
+
+// ErrorData returns the data of e decoded as an ErrorData. It returns false
+// if e has no data, or data that does not follow the convention.
+func (e *Error) ErrorData() (ErrorData, bool) {
+	var d ErrorData
+	if e == nil || e.Data == nil {
+		return d, false
+	}
+	if err := json.Unmarshal(*e.Data, &d); err != nil {
+		return ErrorData{}, false
+	}
+	return d, true
+}
+
+// Retryable reports whether the ErrorData of e marks the call as retryable.
+func (e *Error) Retryable() bool {
+	d, _ := e.ErrorData()
+	return d.Retryable
+}
+
+// RetryAfter returns the RetryAfter of the ErrorData of e, or 0.
+func (e *Error) RetryAfter() time.Duration {
+	d, _ := e.ErrorData()
+	return d.RetryAfter
+}
+
 // constErr represents a error constant.
 type constErr string
 
@@ -67,4 +152,87 @@ func (e constErr) Error() string { return string(e) }
 const (
 	// ErrIdleTimeout is returned when serving timed out waiting for new connections.
 	ErrIdleTimeout = constErr("timed out waiting for new connections")
+
+	// ErrConnectionClosed is returned by the calls that were pending, or made,
+	// when the read loop of a Conn terminated. It is joined with the errors of
+	// the Conn, so that the cause can be matched as well.
+	ErrConnectionClosed = constErr("connection closed")
 )
+
+// Operations of a Conn reported by OpError.
+const (
+	// OpRead is the reading of messages from the stream.
+	OpRead = "read"
+
+	// OpWrite is the writing of the error response to a message that could
+	// not be decoded, by the Conns created WithDecodeErrorRecovery.
+	OpWrite = "write"
+
+	// OpHandle is the handling of a request by the Handler.
+	OpHandle = "handle"
+
+	// OpBackground is work started with Background.
+	OpBackground = "background"
+
+	// OpPeerClose is the close of the connection by the peer with
+	// CloseWithError, the error is the *Error it sent. It is only reported
+	// by the Conns created WithPeerClose.
+	OpPeerClose = "peer close"
+)
+
+// OpError is an error that made a Conn fail, with the operation that failed.
+type OpError struct {
+	// Op is the operation that failed, OpRead, OpWrite, OpHandle,
+	// OpBackground or OpPeerClose.
+	Op string
+
+	// Err is the error returned by the operation.
+	Err error
+}
+
+// compile time check whether the OpError implements error interface.
+var _ error = (*OpError)(nil)
+
+// Error implements error.Error.
+func (e *OpError) Error() string { return e.Op + ": " + e.Err.Error() }
+
+// Unwrap implements errors.Unwrap.
+func (e *OpError) Unwrap() error { return e.Err }
+
+// joinedError is a list of errors that occurred in sequence.
+type joinedError []error
+
+// compile time check whether the joinedError implements error interface.
+var _ error = joinedError(nil)
+
+// Error implements error.Error.
+func (e joinedError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the joined errors.
+func (e joinedError) Unwrap() []error { return e }
+
+// Is reports whether any of the joined errors matches target.
+func (e joinedError) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first of the joined errors that matches target.
+func (e joinedError) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,196 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// Extension is an optional subsystem of a connection, such as compression or
+// progress reporting, with its semantic version.
+type Extension struct {
+	// Name is the name of the extension.
+	Name string `json:"name"`
+
+	// Version is the "MAJOR.MINOR.PATCH" semantic version of the extension.
+	// The minor and patch numbers may be omitted.
+	Version string `json:"version"`
+}
+
+// ExtensionsParams is the params and the result of the MethodExtensions
+// call.
+type ExtensionsParams struct {
+	// Extensions is the list of extensions.
+	Extensions []Extension `json:"extensions"`
+}
+
+// Extensions negotiates the extensions enabled on a Conn, so that optional
+// subsystems are only used when both peers support them.
+//
+// The client advertises the extensions it supports with Negotiate, and the
+// server replies with the accepted set: the extensions supported by both peers
+// with the same major version, at the lower of their two versions.
+type Extensions struct {
+	conn      Conn
+	supported map[string]Extension
+
+	mu       sync.Mutex
+	accepted map[string]Extension
+}
+
+// NewExtensions returns Extensions supporting the supported extensions on
+// conn.
+func NewExtensions(conn Conn, supported []Extension) *Extensions {
+	e := &Extensions{
+		conn:      conn,
+		supported: make(map[string]Extension, len(supported)),
+		accepted:  make(map[string]Extension),
+	}
+	for _, ext := range supported {
+		e.supported[ext.Name] = ext
+	}
+	return e
+}
+
+// Negotiate advertises the supported extensions to the peer, and enables the
+// extensions it accepts.
+func (e *Extensions) Negotiate(ctx context.Context) error {
+	supported := make([]Extension, 0, len(e.supported))
+	for _, ext := range e.supported {
+		supported = append(supported, ext)
+	}
+	sortExtensions(supported)
+
+	var result ExtensionsParams
+	if _, err := e.conn.Call(ctx, MethodExtensions, ExtensionsParams{Extensions: supported}, &result); err != nil {
+		return fmt.Errorf("negotiating extensions: %w", err)
+	}
+	// the peer may only narrow the advertised set down
+	e.setAccepted(e.accept(result.Extensions))
+	return nil
+}
+
+// Accepted returns the extensions enabled on the connection, sorted by name.
+func (e *Extensions) Accepted() []Extension {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	accepted := make([]Extension, 0, len(e.accepted))
+	for _, ext := range e.accepted {
+		accepted = append(accepted, ext)
+	}
+	sortExtensions(accepted)
+	return accepted
+}
+
+// Enabled returns the version of the extension name enabled on the
+// connection, and whether it is enabled.
+func (e *Extensions) Enabled(name string) (Extension, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ext, ok := e.accepted[name]
+	return ext, ok
+}
+
+// Handler returns a handler that answers the MethodExtensions call of the
+// peer, and passes the other requests to handler.
+//
+// MethodExtensions is a reserved method, so the handler must wrap a
+// ReservedHandler rather than be wrapped by one.
+func (e *Extensions) Handler(handler Handler) (h Handler) {
+	h = Handler(func(ctx context.Context, reply Replier, req Request) error {
+		if req.Method() != MethodExtensions {
+			return handler(ctx, reply, req)
+		}
+
+		var params ExtensionsParams
+		if err := json.Unmarshal(req.Params(), &params); err != nil {
+			return reply(ctx, nil, fmt.Errorf("%s params: %v: %w", req.Method(), err, ErrInvalidParams))
+		}
+		accepted := e.accept(params.Extensions)
+		e.setAccepted(accepted)
+		return reply(ctx, ExtensionsParams{Extensions: accepted}, nil)
+	})
+
+	return h
+}
+
+// accept returns the extensions of offered that are compatible with the
+// supported ones, at the lower of the two versions, sorted by name.
+func (e *Extensions) accept(offered []Extension) []Extension {
+	accepted := make([]Extension, 0, len(offered))
+	for _, ext := range offered {
+		own, ok := e.supported[ext.Name]
+		if !ok {
+			continue
+		}
+		theirs, ok1 := parseVersion(ext.Version)
+		ours, ok2 := parseVersion(own.Version)
+		if !ok1 || !ok2 || theirs[0] != ours[0] {
+			continue
+		}
+		if compareVersions(theirs, ours) < 0 {
+			own = ext
+		}
+		accepted = append(accepted, own)
+	}
+	sortExtensions(accepted)
+	return accepted
+}
+
+// setAccepted replaces the extensions enabled on the connection.
+func (e *Extensions) setAccepted(accepted []Extension) {
+	set := make(map[string]Extension, len(accepted))
+	for _, ext := range accepted {
+		set[ext.Name] = ext
+	}
+
+	e.mu.Lock()
+	e.accepted = set
+	e.mu.Unlock()
+}
+
+// parseVersion parses a "MAJOR.MINOR.PATCH" semantic version, with optional
+// minor and patch numbers.
+func parseVersion(version string) ([3]int, bool) {
+	var v [3]int
+	parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	if len(parts) > len(v) {
+		return v, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, false
+		}
+		v[i] = n
+	}
+	return v, true
+}
+
+// compareVersions returns -1, 0 or 1 whether a is lower than, equal to or
+// greater than b.
+func compareVersions(a, b [3]int) int {
+	for i := range a {
+		switch {
+		case a[i] < b[i]:
+			return -1
+		case a[i] > b[i]:
+			return 1
+		}
+	}
+	return 0
+}
+
+// sortExtensions sorts exts by name.
+func sortExtensions(exts []Extension) {
+	sort.Slice(exts, func(i, j int) bool { return exts[i].Name < exts[j].Name })
+}
@@ -0,0 +1,54 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+func TestExtensions(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	a, b := pipeConns(t)
+	client := jsonrpc2.NewExtensions(a, []jsonrpc2.Extension{
+		{Name: "compression", Version: "1.2.0"},
+		{Name: "mux", Version: "2.0"},
+		{Name: "progress", Version: "1.0.0"},
+		{Name: "trace", Version: "1"},
+	})
+	server := jsonrpc2.NewExtensions(b, []jsonrpc2.Extension{
+		{Name: "compression", Version: "1.1.3"},
+		{Name: "mux", Version: "1.4"},
+		{Name: "progress", Version: "1.3.0"},
+	})
+	a.Go(ctx, client.Handler(jsonrpc2.MethodNotFoundHandler))
+	b.Go(ctx, server.Handler(jsonrpc2.MethodNotFoundHandler))
+
+	if err := client.Negotiate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []jsonrpc2.Extension{
+		{Name: "compression", Version: "1.1.3"},
+		{Name: "progress", Version: "1.0.0"},
+	}
+	if got := client.Accepted(); !reflect.DeepEqual(got, want) {
+		t.Errorf("client.Accepted(): got %v, want %v", got, want)
+	}
+	if got := server.Accepted(); !reflect.DeepEqual(got, want) {
+		t.Errorf("server.Accepted(): got %v, want %v", got, want)
+	}
+	if _, ok := client.Enabled("mux"); ok {
+		t.Error(`client.Enabled("mux"): enabled across major versions`)
+	}
+	if ext, ok := server.Enabled("progress"); !ok || ext.Version != "1.0.0" {
+		t.Errorf(`server.Enabled("progress"): got %v, %t, want version 1.0.0`, ext, ok)
+	}
+}
@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package fake
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+// Chaos describes the faults injected into a connection to test the
+// robustness of a protocol implementation against slow or lossy links.
+//
+// The zero value injects no fault.
+type Chaos struct {
+	// Latency returns the delay before each write, it may be nil.
+	//
+	// It is called for every write, so that it can draw the delays from a
+	// distribution.
+	Latency func() time.Duration
+
+	// BytesPerSecond caps the bandwidth of writes, 0 means unlimited.
+	BytesPerSecond int
+
+	// MaxChunk splits writes into chunks of at most MaxChunk bytes, so that
+	// messages reach the reader in several partial reads. 0 means no split.
+	MaxChunk int
+
+	// DisconnectAfter closes the connection once that many bytes have been
+	// written, in the middle of a message if needed. 0 means never.
+	DisconnectAfter int64
+
+	// Drop reports whether a message written must be silently dropped, it may
+	// be nil. It only applies to streams created by Framer.
+	Drop func(msg jsonrpc2.Message) bool
+}
+
+// Wrap returns conn with the faults of c injected in its writes.
+func (c Chaos) Wrap(conn io.ReadWriteCloser) io.ReadWriteCloser {
+	return &chaosConn{
+		ReadWriteCloser: conn,
+		chaos:           c,
+	}
+}
+
+// Framer returns a Framer that frames the connections with framer after
+// injecting the faults of c.
+func (c Chaos) Framer(framer jsonrpc2.Framer) jsonrpc2.Framer {
+	return func(conn io.ReadWriteCloser) jsonrpc2.Stream {
+		s := framer(c.Wrap(conn))
+		if c.Drop == nil {
+			return s
+		}
+		return &dropStream{
+			Stream: s,
+			drop:   c.Drop,
+		}
+	}
+}
+
+// chaosConn is a connection with faults injected in its writes.
+type chaosConn struct {
+	io.ReadWriteCloser
+	chaos Chaos
+
+	mu      sync.Mutex // serializes writes
+	written int64
+}
+
+// Write implements io.Writer.
+func (c *chaosConn) Write(p []byte) (n int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.chaos.Latency != nil {
+		time.Sleep(c.chaos.Latency())
+	}
+
+	for len(p) > 0 {
+		chunk := p
+		if c.chaos.MaxChunk > 0 && len(chunk) > c.chaos.MaxChunk {
+			chunk = chunk[:c.chaos.MaxChunk]
+		}
+		disconnect := false
+		if limit := c.chaos.DisconnectAfter; limit > 0 && c.written+int64(len(chunk)) >= limit {
+			chunk = chunk[:limit-c.written]
+			disconnect = true
+		}
+		if c.chaos.BytesPerSecond > 0 {
+			time.Sleep(time.Duration(len(chunk)) * time.Second / time.Duration(c.chaos.BytesPerSecond))
+		}
+
+		m, err := c.ReadWriteCloser.Write(chunk)
+		n += m
+		c.written += int64(m)
+		if err != nil {
+			return n, err
+		}
+		if disconnect {
+			c.ReadWriteCloser.Close()
+			return n, io.ErrClosedPipe
+		}
+		p = p[m:]
+	}
+
+	return n, nil
+}
+
+// dropStream is a Stream that drops some of the messages written.
+type dropStream struct {
+	jsonrpc2.Stream
+	drop func(msg jsonrpc2.Message) bool
+}
+
+// Write implements jsonrpc2.Stream.Write.
+func (s *dropStream) Write(ctx context.Context, msg jsonrpc2.Message) (int64, error) {
+	if s.drop(msg) {
+		return 0, nil
+	}
+	return s.Stream.Write(ctx, msg)
+}
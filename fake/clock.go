@@ -0,0 +1,110 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package fake
+
+import (
+	"sync"
+	"time"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+// Clock is a jsonrpc2.Clock whose time only moves when it is advanced, so
+// that timeouts can be tested deterministically.
+type Clock struct {
+	mu     sync.Mutex
+	cond   *sync.Cond // signaled when a timer is armed
+	now    time.Time
+	timers map[*timer]struct{} // armed timers
+}
+
+// compile time check whether the Clock implements jsonrpc2.Clock interface.
+var _ jsonrpc2.Clock = (*Clock)(nil)
+
+// NewClock returns a Clock set to now.
+func NewClock(now time.Time) *Clock {
+	c := &Clock{
+		now:    now,
+		timers: make(map[*timer]struct{}),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now implements jsonrpc2.Clock.Now.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTimer implements jsonrpc2.Clock.NewTimer.
+func (c *Clock) NewTimer(d time.Duration) jsonrpc2.Timer {
+	t := &timer{
+		clock: c,
+		c:     make(chan time.Time, 1),
+	}
+	t.Reset(d)
+	return t
+}
+
+// Advance moves the time forward by d, and fires the timers that are due.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	for t := range c.timers {
+		if !t.when.After(c.now) {
+			delete(c.timers, t)
+			select {
+			case t.c <- c.now:
+			default:
+			}
+		}
+	}
+}
+
+// WaitTimers blocks until at least n timers are armed, so that a test can
+// make sure the code under test is waiting before it advances the time.
+func (c *Clock) WaitTimers(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.timers) < n {
+		c.cond.Wait()
+	}
+}
+
+// timer is a jsonrpc2.Timer of a Clock.
+type timer struct {
+	clock *Clock
+	c     chan time.Time
+	when  time.Time // guarded by the mutex of the clock
+}
+
+// C implements jsonrpc2.Timer.C.
+func (t *timer) C() <-chan time.Time { return t.c }
+
+// Stop implements jsonrpc2.Timer.Stop.
+func (t *timer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	_, armed := t.clock.timers[t]
+	delete(t.clock.timers, t)
+	return armed
+}
+
+// Reset implements jsonrpc2.Timer.Reset.
+func (t *timer) Reset(d time.Duration) bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, armed := c.timers[t]
+	t.when = c.now.Add(d)
+	c.timers[t] = struct{}{}
+	c.cond.Broadcast()
+	return armed
+}
@@ -5,6 +5,7 @@ package fake_test
 
 import (
 	"context"
+	"net"
 	"testing"
 	"time"
 
@@ -58,3 +59,130 @@ func TestTestServer(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckGoroutines(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	defer fake.CheckGoroutines(t, 5*time.Second)()
+
+	server := jsonrpc2.HandlerServer(fakeHandler)
+	ts := fake.NewPipeServer(ctx, server, nil)
+
+	conn := ts.Connect(ctx)
+	conn.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+
+	var got msg
+	if _, err := conn.Call(ctx, "ping", &msg{"ping"}, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	ts.Close()
+	<-conn.Done()
+}
+
+func TestLeakTracker(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tracker := fake.NewLeakTracker()
+	aPipe, bPipe := net.Pipe()
+	a := jsonrpc2.NewConn(jsonrpc2.NewStream(aPipe), tracker.Option())
+	b := jsonrpc2.NewConn(jsonrpc2.NewStream(bPipe), tracker.Option())
+	a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+	b.Go(ctx, fakeHandler)
+
+	if got, want := jsonrpc2.Goroutines(a), 2; got != want {
+		t.Errorf("jsonrpc2.Goroutines(a): got %d, want %d", got, want)
+	}
+
+	var got msg
+	if _, err := a.Call(ctx, "ping", &msg{"ping"}, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	a.Close()
+	<-a.Done()
+	<-b.Done()
+	tracker.Check(t, 5*time.Second)
+
+	if got := jsonrpc2.Goroutines(a); got != 0 {
+		t.Errorf("jsonrpc2.Goroutines(a): got %d after shutdown, want 0", got)
+	}
+}
+
+func TestChaos(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	server := jsonrpc2.HandlerServer(fakeHandler)
+
+	slow := fake.Chaos{
+		Latency:        func() time.Duration { return time.Millisecond },
+		BytesPerSecond: 1 << 20,
+		MaxChunk:       3,
+	}
+	ts := fake.NewPipeServer(ctx, server, slow.Framer(jsonrpc2.NewStream))
+	defer ts.Close()
+
+	conn := ts.Connect(ctx)
+	conn.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+
+	var got msg
+	if _, err := conn.Call(ctx, "ping", &msg{"ping"}, &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := "pong"; got.Msg != want {
+		t.Errorf("conn.Call(...): returned %q, want %q", got, want)
+	}
+
+	dropped := fake.Chaos{
+		Drop: func(jsonrpc2.Message) bool { return true },
+	}
+	ts = fake.NewPipeServer(ctx, server, dropped.Framer(jsonrpc2.NewStream))
+	defer ts.Close()
+
+	conn = ts.Connect(ctx)
+	conn.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+
+	callCtx, callCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer callCancel()
+	if _, err := conn.Call(callCtx, "ping", &msg{"ping"}, &got); err == nil {
+		t.Error("conn.Call(...): got a response to a dropped call")
+	}
+
+	disconnected := fake.Chaos{
+		DisconnectAfter: 10,
+	}
+	ts = fake.NewPipeServer(ctx, server, disconnected.Framer(jsonrpc2.NewStream))
+	defer ts.Close()
+
+	conn = ts.Connect(ctx)
+	conn.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+
+	if _, err := conn.Call(ctx, "ping", &msg{"ping"}, &got); err == nil {
+		t.Error("conn.Call(...): got a response over a disconnected link")
+	}
+}
+
+func TestSoak(t *testing.T) {
+	t.Parallel()
+
+	report := fake.Soak(t, fake.SoakOptions{
+		Duration:       200 * time.Millisecond,
+		Seed:           1,
+		MaxPayload:     4096,
+		Methods:        []string{"a", "b"},
+		SampleInterval: 50 * time.Millisecond,
+	})
+	if report.Calls == 0 || report.Notifications == 0 {
+		t.Errorf("fake.Soak(...): sent %d calls and %d notifications, want some of each", report.Calls, report.Notifications)
+	}
+	if report.Errors != 0 {
+		t.Errorf("fake.Soak(...): %d calls failed", report.Errors)
+	}
+}
@@ -0,0 +1,137 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package fake
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/jsonrpc2/internal/stacks"
+)
+
+// CheckGoroutines records the running goroutines and returns a function that
+// fails t if any goroutine started after the call is still running once
+// timeout has elapsed.
+//
+// It is meant to be deferred at the start of a test to assert that every
+// jsonrpc2.Conn created by the test has shut down cleanly:
+//
+//	defer fake.CheckGoroutines(t, time.Second)()
+//
+// Tests using it must not run in parallel with other tests, as their
+// goroutines would be reported as leaked. Parallel tests can use a
+// LeakTracker instead.
+func CheckGoroutines(tb testing.TB, timeout time.Duration) func() {
+	tb.Helper()
+
+	before := goroutines()
+
+	return func() {
+		tb.Helper()
+
+		deadline := time.Now().Add(timeout)
+		for {
+			var leaked []string
+			for id, stack := range goroutines() {
+				if _, ok := before[id]; !ok {
+					leaked = append(leaked, stack)
+				}
+			}
+			if len(leaked) == 0 {
+				return
+			}
+
+			if time.Now().After(deadline) {
+				tb.Errorf("%d goroutines leaked:\n%s", len(leaked), strings.Join(leaked, "\n\n"))
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// goroutines returns the stack traces of the running goroutines, keyed by their
+// "goroutine N" header.
+func goroutines() map[string]string {
+	all := make(map[string]string)
+	for _, stack := range bytes.Split(stacks.All(), []byte("\n\n")) {
+		header := stack
+		if i := bytes.IndexByte(stack, '['); i > 0 {
+			header = stack[:i]
+		}
+		all[string(bytes.TrimSpace(header))] = string(stack)
+	}
+
+	return all
+}
+
+// LeakTracker tracks the internal goroutines of the jsonrpc2.Conns it is
+// registered with, and checks that they all exit.
+//
+// Unlike CheckGoroutines it only looks at the goroutines of its own Conns, so
+// it can be used by tests running in parallel.
+type LeakTracker struct {
+	mu      sync.Mutex
+	running map[string]int
+}
+
+// NewLeakTracker returns a new LeakTracker.
+func NewLeakTracker() *LeakTracker {
+	return &LeakTracker{
+		running: make(map[string]int),
+	}
+}
+
+// Option returns a ConnOption that registers the internal goroutines of a Conn
+// with the tracker.
+func (t *LeakTracker) Option() jsonrpc2.ConnOption {
+	return jsonrpc2.WithGoroutineHook(t.hook)
+}
+
+// hook implements jsonrpc2.GoroutineHook.
+func (t *LeakTracker) hook(name string, running bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if running {
+		t.running[name]++
+		return
+	}
+	t.running[name]--
+	if t.running[name] == 0 {
+		delete(t.running, name)
+	}
+}
+
+// Check fails tb if goroutines of the registered Conns are still running once
+// timeout has elapsed.
+func (t *LeakTracker) Check(tb testing.TB, timeout time.Duration) {
+	tb.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		t.mu.Lock()
+		leaked := make([]string, 0, len(t.running))
+		for name, n := range t.running {
+			leaked = append(leaked, fmt.Sprintf("%s (%d)", name, n))
+		}
+		t.mu.Unlock()
+
+		if len(leaked) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			sort.Strings(leaked)
+			tb.Errorf("jsonrpc2.Conn goroutines leaked: %s", strings.Join(leaked, ", "))
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
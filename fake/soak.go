@@ -0,0 +1,202 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package fake
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+// SoakOptions configures the traffic of Soak and the bounds it asserts.
+type SoakOptions struct {
+	// Duration is how long traffic is sent.
+	Duration time.Duration
+
+	// Seed makes the sequence of messages reproducible.
+	Seed int64
+
+	// Senders is the number of goroutines sending messages concurrently, 4 if
+	// 0.
+	Senders int
+
+	// MaxPayload is the maximum size in bytes of the params of a message, 1024
+	// if 0.
+	MaxPayload int
+
+	// Methods lists the methods of the messages, picked at random. It is
+	// "soak" if empty.
+	Methods []string
+
+	// Handler handles the messages on the server side, wrapped in an
+	// AsyncHandler. The params of calls are echoed back if it is nil.
+	Handler jsonrpc2.Handler
+
+	// MaxHeapGrowth bounds the growth of the heap in use over its size when
+	// the traffic started, unbounded if 0.
+	MaxHeapGrowth uint64
+
+	// MaxGoroutineGrowth bounds the growth of the number of goroutines over
+	// their number when the traffic started, unbounded if 0.
+	MaxGoroutineGrowth int
+
+	// SampleInterval is the interval the heap and goroutines are sampled at,
+	// a second if 0.
+	SampleInterval time.Duration
+}
+
+// SoakReport summarizes the traffic sent by Soak.
+type SoakReport struct {
+	// Calls is the number of calls sent.
+	Calls int64
+
+	// Notifications is the number of notifications sent.
+	Notifications int64
+
+	// Canceled is the number of calls canceled before their response.
+	Canceled int64
+
+	// Errors is the number of calls that failed otherwise.
+	Errors int64
+
+	// PeakHeapGrowth is the largest growth of the heap in use sampled.
+	PeakHeapGrowth uint64
+
+	// PeakGoroutineGrowth is the largest growth of the number of goroutines
+	// sampled.
+	PeakGoroutineGrowth int
+}
+
+// Soak sends randomized traffic between two in-process jsonrpc2.Conns for
+// opts.Duration, such as calls of random sizes, some canceled before their
+// response, and notifications, to qualify a Handler against long runs.
+//
+// It fails tb if the heap or the goroutines grow beyond the bounds of opts,
+// and if the goroutines of the Conns are still running after they are closed.
+// The bounds are measured for the whole process, so tests using them must not
+// run in parallel with other tests.
+func Soak(tb testing.TB, opts SoakOptions) SoakReport {
+	tb.Helper()
+
+	if opts.Senders <= 0 {
+		opts.Senders = 4
+	}
+	if opts.MaxPayload <= 0 {
+		opts.MaxPayload = 1024
+	}
+	if len(opts.Methods) == 0 {
+		opts.Methods = []string{"soak"}
+	}
+	if opts.SampleInterval <= 0 {
+		opts.SampleInterval = time.Second
+	}
+	handler := opts.Handler
+	if handler == nil {
+		handler = func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+			return reply(ctx, req.Params(), nil)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Duration)
+	defer cancel()
+
+	tracker := NewLeakTracker()
+	clientPipe, serverPipe := net.Pipe()
+	client := jsonrpc2.NewConn(jsonrpc2.NewStream(clientPipe), tracker.Option())
+	server := jsonrpc2.NewConn(jsonrpc2.NewStream(serverPipe), tracker.Option())
+	client.Go(context.Background(), jsonrpc2.MethodNotFoundHandler)
+	server.Go(context.Background(), jsonrpc2.AsyncHandler(handler))
+
+	heap0, goroutines0 := heapInUse(), runtime.NumGoroutine()
+	var report SoakReport
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Senders; i++ {
+		wg.Add(1)
+		go func(rnd *rand.Rand) {
+			defer wg.Done()
+			soakSend(ctx, client, rnd, opts, &report)
+		}(rand.New(rand.NewSource(opts.Seed + int64(i))))
+	}
+
+	ticker := time.NewTicker(opts.SampleInterval)
+	defer ticker.Stop()
+	for sampling := true; sampling; {
+		select {
+		case <-ctx.Done():
+			sampling = false
+		case <-ticker.C:
+		}
+
+		if heap := heapInUse(); heap > heap0 && heap-heap0 > report.PeakHeapGrowth {
+			report.PeakHeapGrowth = heap - heap0
+		}
+		if n := runtime.NumGoroutine() - goroutines0; n > report.PeakGoroutineGrowth {
+			report.PeakGoroutineGrowth = n
+		}
+	}
+	wg.Wait()
+
+	client.Close()
+	<-client.Done()
+	<-server.Done()
+	tracker.Check(tb, 5*time.Second)
+
+	if opts.MaxHeapGrowth > 0 && report.PeakHeapGrowth > opts.MaxHeapGrowth {
+		tb.Errorf("heap grew by %d bytes, want at most %d", report.PeakHeapGrowth, opts.MaxHeapGrowth)
+	}
+	if opts.MaxGoroutineGrowth > 0 && report.PeakGoroutineGrowth > opts.MaxGoroutineGrowth {
+		tb.Errorf("goroutines grew by %d, want at most %d", report.PeakGoroutineGrowth, opts.MaxGoroutineGrowth)
+	}
+	return report
+}
+
+// soakSend sends random messages on conn until ctx is done.
+func soakSend(ctx context.Context, conn jsonrpc2.Conn, rnd *rand.Rand, opts SoakOptions, report *SoakReport) {
+	for ctx.Err() == nil {
+		method := opts.Methods[rnd.Intn(len(opts.Methods))]
+		params := json.RawMessage(`"` + strings.Repeat("x", rnd.Intn(opts.MaxPayload)) + `"`)
+
+		if rnd.Intn(4) == 0 {
+			atomic.AddInt64(&report.Notifications, 1)
+			conn.Notify(ctx, method, params)
+			continue
+		}
+
+		atomic.AddInt64(&report.Calls, 1)
+		callCtx, cancel := ctx, context.CancelFunc(func() {})
+		if rnd.Intn(10) == 0 {
+			// cancel some calls before their response
+			callCtx, cancel = context.WithTimeout(ctx, time.Duration(rnd.Intn(100))*time.Microsecond)
+		}
+		_, err := conn.Call(callCtx, method, params, nil)
+		cancel()
+		switch {
+		case err == nil:
+		case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+			atomic.AddInt64(&report.Canceled, 1)
+		default:
+			atomic.AddInt64(&report.Errors, 1)
+		}
+	}
+}
+
+// heapInUse returns the bytes of the heap in use after a garbage collection.
+func heapInUse() uint64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapInuse
+}
@@ -0,0 +1,219 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package jsonrpc2
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// MaxFilesPerSet is the maximum number of files in a FileSet.
+const MaxFilesPerSet = 64
+
+// fileFrameSize is the size of the frame carrying a FileSet, an 8 bytes id
+// followed by a 4 bytes count.
+const fileFrameSize = 12
+
+// FileSet describes open files sent on a FileChannel, to be referenced from
+// the params of a message.
+type FileSet struct {
+	// ID identifies the set on the channel.
+	ID uint64 `json:"id"`
+
+	// Count is the number of files in the set.
+	Count int `json:"count"`
+}
+
+// FileChannel passes open files to the peer of a Conn over a unix socket,
+// with SCM_RIGHTS control messages, such as a second connection to a local
+// server.
+type FileChannel struct {
+	conn *net.UnixConn
+
+	writeMu sync.Mutex
+	next    uint64
+
+	mu       sync.Mutex
+	received map[uint64][]*os.File
+	waiting  map[uint64]chan struct{}
+	err      error // error that ended the reading of the channel
+	done     chan struct{}
+}
+
+// NewFileChannel returns a FileChannel over conn, and starts reading the files
+// sent by the peer on it.
+//
+// Received files are owned by the channel until they are received with
+// Receive, and closed with the channel otherwise.
+func NewFileChannel(conn *net.UnixConn) *FileChannel {
+	c := &FileChannel{
+		conn:     conn,
+		received: make(map[uint64][]*os.File),
+		waiting:  make(map[uint64]chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+// Send sends duplicates of the descriptors of files to the peer, and returns
+// the FileSet to send it in a message. The files remain owned by the caller.
+func (c *FileChannel) Send(files ...*os.File) (FileSet, error) {
+	if len(files) == 0 || len(files) > MaxFilesPerSet {
+		return FileSet{}, fmt.Errorf("sending %d files, want 1 to %d", len(files), MaxFilesPerSet)
+	}
+
+	fds := make([]int, len(files))
+	for i, f := range files {
+		fds[i] = int(f.Fd())
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.next++
+	set := FileSet{ID: c.next, Count: len(files)}
+	var frame [fileFrameSize]byte
+	binary.BigEndian.PutUint64(frame[:8], set.ID)
+	binary.BigEndian.PutUint32(frame[8:], uint32(set.Count))
+
+	n, _, err := c.conn.WriteMsgUnix(frame[:], syscall.UnixRights(fds...), nil)
+	if err == nil && n < len(frame) {
+		_, err = c.conn.Write(frame[n:])
+	}
+	if err != nil {
+		return FileSet{}, fmt.Errorf("sending files: %w", err)
+	}
+	return set, nil
+}
+
+// Receive returns the files of set, waiting for them to be received. The
+// caller owns the files, and must close them.
+//
+// A FileSet can only be received once.
+func (c *FileChannel) Receive(ctx context.Context, set FileSet) ([]*os.File, error) {
+	for {
+		c.mu.Lock()
+		files, ok := c.received[set.ID]
+		if ok {
+			delete(c.received, set.ID)
+			c.mu.Unlock()
+			return files, nil
+		}
+		if c.err != nil {
+			err := c.err
+			c.mu.Unlock()
+			return nil, fmt.Errorf("file set %d: %w", set.ID, err)
+		}
+		wait, ok := c.waiting[set.ID]
+		if !ok {
+			wait = make(chan struct{})
+			c.waiting[set.ID] = wait
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-wait:
+		case <-c.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Close closes the channel, and the received files nobody received.
+func (c *FileChannel) Close() error {
+	err := c.conn.Close()
+	<-c.done
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, files := range c.received {
+		for _, f := range files {
+			f.Close()
+		}
+		delete(c.received, id)
+	}
+	return err
+}
+
+// run reads the file sets from the channel until it fails.
+func (c *FileChannel) run() {
+	err := c.read()
+
+	c.mu.Lock()
+	c.err = err
+	c.mu.Unlock()
+	close(c.done)
+}
+
+// read reads file sets from the channel.
+func (c *FileChannel) read() error {
+	oob := make([]byte, syscall.CmsgSpace(MaxFilesPerSet*4))
+	for {
+		var frame [fileFrameSize]byte
+		n, oobn, flags, _, err := c.conn.ReadMsgUnix(frame[:], oob)
+		if err != nil {
+			return fmt.Errorf("reading file set: %w", err)
+		}
+		if flags&syscall.MSG_CTRUNC != 0 {
+			return errors.New("reading file set: control message truncated")
+		}
+		if n < len(frame) {
+			if _, err := io.ReadFull(c.conn, frame[n:]); err != nil {
+				return fmt.Errorf("reading file set: %w", err)
+			}
+		}
+
+		files, err := parseRights(oob[:oobn])
+		if err != nil {
+			return err
+		}
+		id := binary.BigEndian.Uint64(frame[:8])
+		if count := int(binary.BigEndian.Uint32(frame[8:])); count != len(files) {
+			for _, f := range files {
+				f.Close()
+			}
+			return fmt.Errorf("file set %d: got %d files, want %d", id, len(files), count)
+		}
+
+		c.mu.Lock()
+		c.received[id] = files
+		if wait, ok := c.waiting[id]; ok {
+			delete(c.waiting, id)
+			close(wait)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// parseRights returns the files of the SCM_RIGHTS control messages of oob.
+func parseRights(oob []byte) ([]*os.File, error) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, fmt.Errorf("parsing control message: %w", err)
+	}
+
+	var files []*os.File
+	for i := range msgs {
+		fds, err := syscall.ParseUnixRights(&msgs[i])
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			files = append(files, os.NewFile(uintptr(fd), "fd"))
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,82 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package jsonrpc2_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+func TestFileChannel(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dir := t.TempDir()
+	addr := &net.UnixAddr{Name: filepath.Join(dir, "files.sock"), Net: "unix"}
+	ln, err := net.ListenUnix("unix", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan *net.UnixConn, 1)
+	go func() {
+		conn, err := ln.AcceptUnix()
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- conn
+	}()
+	clientConn, err := net.DialUnix("unix", nil, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := jsonrpc2.NewFileChannel(clientConn)
+	defer client.Close()
+	server := jsonrpc2.NewFileChannel(<-accepted)
+	defer server.Close()
+
+	path := filepath.Join(dir, "shared.txt")
+	if err := os.WriteFile(path, []byte("shared"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	set, err := client.Send(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := server.Receive(ctx, set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("got %d files, want 1", len(files))
+	}
+	defer files[0].Close()
+
+	data, err := io.ReadAll(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != "shared" {
+		t.Errorf("read %q from the received file, want %q", got, "shared")
+	}
+}
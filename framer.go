@@ -0,0 +1,143 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// TeeFramer returns a Framer that frames connections with primary, and writes a
+// copy of every message read from or written to them to tap.
+//
+// Errors writing to tap are ignored, they never affect the primary stream. The
+// copies are written to tap one at a time, even when the connections are read
+// and written concurrently or share the same tap.
+func TeeFramer(primary Framer, tap Stream) Framer {
+	mu := &sync.Mutex{}
+	return func(conn io.ReadWriteCloser) Stream {
+		return &teeStream{
+			Stream: primary(conn),
+			tap:    tap,
+			tapMu:  mu,
+		}
+	}
+}
+
+// teeStream is a Stream that copies messages to a tap Stream.
+type teeStream struct {
+	Stream
+	tap   Stream
+	tapMu *sync.Mutex // serializes the writes to tap
+}
+
+// Read implements Stream.Read.
+func (s *teeStream) Read(ctx context.Context) (Message, int64, error) {
+	msg, n, err := s.Stream.Read(ctx)
+	if err == nil {
+		s.copy(ctx, msg)
+	}
+	return msg, n, err
+}
+
+// Write implements Stream.Write.
+func (s *teeStream) Write(ctx context.Context, msg Message) (int64, error) {
+	n, err := s.Stream.Write(ctx, msg)
+	if err == nil {
+		s.copy(ctx, msg)
+	}
+	return n, err
+}
+
+// copy writes a copy of msg to the tap.
+func (s *teeStream) copy(ctx context.Context, msg Message) {
+	s.tapMu.Lock()
+	defer s.tapMu.Unlock()
+	s.tap.Write(ctx, msg)
+}
+
+// TransformFramer returns a Framer that frames connections with framer, and
+// replaces every message read from or written to them with the result of
+// transform.
+//
+// inbound reports whether msg was read from the connection.
+func TransformFramer(framer Framer, transform func(msg Message, inbound bool) Message) Framer {
+	return func(conn io.ReadWriteCloser) Stream {
+		return &transformStream{
+			Stream:    framer(conn),
+			transform: transform,
+		}
+	}
+}
+
+// transformStream is a Stream that transforms messages.
+type transformStream struct {
+	Stream
+	transform func(msg Message, inbound bool) Message
+}
+
+// Read implements Stream.Read.
+func (s *transformStream) Read(ctx context.Context) (Message, int64, error) {
+	msg, n, err := s.Stream.Read(ctx)
+	if err != nil {
+		return nil, n, err
+	}
+	return s.transform(msg, true), n, nil
+}
+
+// Write implements Stream.Write.
+func (s *transformStream) Write(ctx context.Context, msg Message) (int64, error) {
+	return s.Stream.Write(ctx, s.transform(msg, false))
+}
+
+// LimitFramer returns a Framer that frames connections with framer, and fails
+// to read messages larger than max bytes with an error wrapping ErrParse.
+//
+// The streams created by NewStream and NewStreamFramer check the size of a
+// message from its header, and skip the body of a message too large without
+// reading it into memory. Other streams are checked once the message is read.
+// Either way the stream stays in sync and can still be read from.
+func LimitFramer(framer Framer, max int64) Framer {
+	return func(conn io.ReadWriteCloser) Stream {
+		stream := framer(conn)
+		if limiter, ok := stream.(readLimiter); ok {
+			limiter.setReadLimit(max)
+		}
+		return &limitStream{
+			Stream: stream,
+			max:    max,
+		}
+	}
+}
+
+// readLimiter is implemented by the streams that can check the size of a
+// message before reading it.
+type readLimiter interface {
+	// setReadLimit makes the stream fail to read the messages larger than max
+	// bytes with the error returned by limitError.
+	setReadLimit(max int64)
+}
+
+// limitError returns the error of reading a message of n bytes, larger than
+// max bytes.
+func limitError(n, max int64) error {
+	return fmt.Errorf("message of %d bytes exceeds the limit of %d bytes: %w", n, max, ErrParse)
+}
+
+// limitStream is a Stream that rejects large messages.
+type limitStream struct {
+	Stream
+	max int64
+}
+
+// Read implements Stream.Read.
+func (s *limitStream) Read(ctx context.Context) (Message, int64, error) {
+	msg, n, err := s.Stream.Read(ctx)
+	if err == nil && n > s.max {
+		return nil, n, limitError(n, s.max)
+	}
+	return msg, n, err
+}
@@ -0,0 +1,110 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+func TestFramers(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	body := `{"jsonrpc":"2.0","method":"a"}`
+	input := "Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+
+	var tap bytes.Buffer
+	rename := func(msg jsonrpc2.Message, inbound bool) jsonrpc2.Message {
+		if !inbound {
+			return msg
+		}
+		renamed, _ := jsonrpc2.NewNotification("renamed", nil)
+		return renamed
+	}
+	framer := jsonrpc2.TransformFramer(
+		jsonrpc2.TeeFramer(jsonrpc2.NewStream, jsonrpc2.NewStream(rwc{strings.NewReader(""), &tap})),
+		rename,
+	)
+
+	var out bytes.Buffer
+	s := framer(rwc{strings.NewReader(input), &out})
+	msg, _, err := s.Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notify, ok := msg.(*jsonrpc2.Notification); !ok || notify.Method() != "renamed" {
+		t.Fatalf("got %#v, want notification of renamed", msg)
+	}
+
+	written, _ := jsonrpc2.NewNotification("b", nil)
+	if _, err := s.Write(ctx, written); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `"method":"b"`) {
+		t.Errorf("got %q written, want notification of b", out.String())
+	}
+	if got := tap.String(); !strings.Contains(got, `"method":"a"`) || !strings.Contains(got, `"method":"b"`) {
+		t.Errorf("got %q tapped, want notifications of a and b", got)
+	}
+
+	small := `{"jsonrpc":"2.0","method":"b"}`
+	large := `{"jsonrpc":"2.0","method":"a","params":"` + strings.Repeat("x", 64) + `"}`
+	for name, tt := range map[string]struct {
+		framer jsonrpc2.Framer
+		input  string
+	}{
+		"header": {
+			framer: jsonrpc2.NewStream,
+			input: "Content-Length: " + strconv.Itoa(len(large)) + "\r\n\r\n" + large +
+				"Content-Length: " + strconv.Itoa(len(small)) + "\r\n\r\n" + small,
+		},
+		"raw": {
+			framer: jsonrpc2.NewRawStream,
+			input:  large + small,
+		},
+	} {
+		limited := jsonrpc2.LimitFramer(tt.framer, 64)(rwc{strings.NewReader(tt.input), io.Discard})
+		if _, _, err := limited.Read(ctx); !errors.Is(err, jsonrpc2.ErrParse) {
+			t.Errorf("%s: got %v for a large message, want %v", name, err, jsonrpc2.ErrParse)
+		}
+		if msg, _, err := limited.Read(ctx); err != nil {
+			t.Errorf("%s: got %v for the next message, want %#v", name, err, msg)
+		}
+	}
+}
+
+func TestTeeFramerConcurrent(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var tap bytes.Buffer // not safe for concurrent use
+	framer := jsonrpc2.TeeFramer(jsonrpc2.NewStream, jsonrpc2.NewStream(rwc{strings.NewReader(""), &tap}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		s := framer(rwc{strings.NewReader(""), io.Discard})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 16; j++ {
+				notify, _ := jsonrpc2.NewNotification("tapped", j)
+				s.Write(ctx, notify)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := strings.Count(tap.String(), `"method":"tapped"`); got != 64 {
+		t.Errorf("got %d messages tapped, want 64", got)
+	}
+}
@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import (
+	"context"
+	"time"
+)
+
+// ConnGauges is a snapshot of the load of a Conn.
+type ConnGauges struct {
+	// Pending is the number of outgoing calls waiting for their response.
+	Pending int `json:"pending"`
+
+	// Handling is the number of requests being handled, including the ones
+	// queued by an AsyncHandler.
+	Handling int `json:"handling"`
+}
+
+// Gauges returns a snapshot of the load of c.
+//
+// It returns zero gauges for Conns that were not created by NewConn.
+func Gauges(c Conn) ConnGauges {
+	cc, ok := c.(*conn)
+	if !ok {
+		return ConnGauges{}
+	}
+
+	var g ConnGauges
+	cc.pendingMu.Lock()
+	g.Pending = len(cc.pending)
+	cc.pendingMu.Unlock()
+	cc.idleMu.Lock()
+	g.Handling = cc.inflight
+	cc.idleMu.Unlock()
+	return g
+}
+
+// ReportGauges passes the gauges of c to report every interval, as measured
+// by clock, SystemClock if nil, until c is closed.
+//
+// report may forward the gauges to an exporter, such as a Telemetry:
+//
+//	ReportGauges(c, nil, time.Second, func(g ConnGauges) { telemetry.Emit(g) })
+func ReportGauges(c Conn, clock Clock, interval time.Duration, report func(ConnGauges)) {
+	if clock == nil {
+		clock = SystemClock
+	}
+
+	Background(c, func(ctx context.Context) error {
+		timer := clock.NewTimer(interval)
+		defer timer.Stop()
+		for {
+			select {
+			case <-timer.C():
+				report(Gauges(c))
+				timer.Reset(interval)
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	})
+}
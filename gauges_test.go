@@ -0,0 +1,66 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/jsonrpc2/fake"
+)
+
+func TestReportGauges(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	release := make(chan struct{})
+	a, b := pipeConns(t)
+	a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+	b.Go(ctx, jsonrpc2.AsyncHandler(func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		<-release
+		return reply(ctx, nil, nil)
+	}))
+
+	aGauges := make(chan jsonrpc2.ConnGauges, 1)
+	bGauges := make(chan jsonrpc2.ConnGauges, 1)
+	forward := func(gauges chan jsonrpc2.ConnGauges) func(jsonrpc2.ConnGauges) {
+		return func(g jsonrpc2.ConnGauges) {
+			select {
+			case gauges <- g:
+			default:
+			}
+		}
+	}
+	clock := fake.NewClock(time.Now())
+	jsonrpc2.ReportGauges(a, clock, time.Second, forward(aGauges))
+	jsonrpc2.ReportGauges(b, clock, time.Second, forward(bGauges))
+	// advances the clock until the gauges of a Conn are reported as want
+	await := func(gauges chan jsonrpc2.ConnGauges, want jsonrpc2.ConnGauges) {
+		for {
+			clock.WaitTimers(2)
+			clock.Advance(time.Second)
+			if <-gauges == want {
+				return
+			}
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := a.Call(ctx, "slow", nil, nil)
+		done <- err
+	}()
+
+	await(aGauges, jsonrpc2.ConnGauges{Pending: 1})
+	await(bGauges, jsonrpc2.ConnGauges{Handling: 1})
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got := jsonrpc2.Gauges(a); got != (jsonrpc2.ConnGauges{}) {
+		t.Errorf("jsonrpc2.Gauges(a): got %+v once the call returned, want none pending", got)
+	}
+}
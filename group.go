@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import (
+	"context"
+	"sync"
+)
+
+// Group is a set of connections, such as all the clients of a server, that
+// notifications can be broadcast to.
+//
+// Connections leave the group when they are closed.
+type Group struct {
+	mu    sync.Mutex
+	conns map[Conn]struct{}
+}
+
+// NewGroup returns an empty Group.
+func NewGroup() *Group {
+	return &Group{
+		conns: make(map[Conn]struct{}),
+	}
+}
+
+// Add adds c to the group, until it is closed.
+func (g *Group) Add(c Conn) {
+	g.mu.Lock()
+	g.conns[c] = struct{}{}
+	g.mu.Unlock()
+
+	OnClose(c, func() { g.Remove(c) })
+}
+
+// Remove removes c from the group.
+func (g *Group) Remove(c Conn) {
+	g.mu.Lock()
+	delete(g.conns, c)
+	g.mu.Unlock()
+}
+
+// Conns returns the connections of the group.
+func (g *Group) Conns() []Conn {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	conns := make([]Conn, 0, len(g.conns))
+	for c := range g.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// Broadcast sends a notification of method with params to every connection
+// of the group, and returns the errors of the connections it failed to be
+// sent to, or nil if it was sent to all of them.
+func (g *Group) Broadcast(ctx context.Context, method string, params interface{}) map[Conn]error {
+	return g.BroadcastFunc(ctx, nil, method, params)
+}
+
+// BroadcastFunc is like Broadcast, but only sends the notification to the
+// connections match returns true for. A nil match matches all connections.
+//
+// The notifications are sent concurrently, so that a slow connection does not
+// hold back the others.
+func (g *Group) BroadcastFunc(
+	ctx context.Context, match func(Conn) bool, method string, params interface{},
+) map[Conn]error {
+	var (
+		mu   sync.Mutex
+		errs map[Conn]error
+		wg   sync.WaitGroup
+	)
+	for _, c := range g.Conns() {
+		if match != nil && !match(c) {
+			continue
+		}
+		wg.Add(1)
+		go func(c Conn) {
+			defer wg.Done()
+			if err := c.Notify(ctx, method, params); err != nil {
+				mu.Lock()
+				if errs == nil {
+					errs = make(map[Conn]error)
+				}
+				errs[c] = err
+				mu.Unlock()
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	return errs
+}
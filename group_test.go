@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+func TestGroupBroadcast(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	group := jsonrpc2.NewGroup()
+	server := jsonrpc2.HandlerServer(jsonrpc2.MethodNotFoundHandler)
+	go jsonrpc2.Serve(ctx, ln, server, 0, jsonrpc2.WithGroup(group))
+
+	received := make(chan string, 4)
+	clients := make([]jsonrpc2.Conn, 2)
+	for i := range clients {
+		nc, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients[i] = jsonrpc2.NewConn(jsonrpc2.NewStream(nc))
+		clients[i].Go(ctx, func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+			received <- req.Method()
+			return reply(ctx, nil, nil)
+		})
+		defer clients[i].Close()
+
+		// the reply proves the connection is being served, and in the group
+		var werr *jsonrpc2.Error
+		if _, err := clients[i].Call(ctx, "ping", nil, nil); !errors.As(err, &werr) {
+			t.Fatalf("got %v, want %v", err, jsonrpc2.ErrMethodNotFound)
+		}
+	}
+
+	if errs := group.Broadcast(ctx, "configChanged", nil); errs != nil {
+		t.Fatalf("got errors %v", errs)
+	}
+	for range clients {
+		if got := <-received; got != "configChanged" {
+			t.Errorf("got notification of %s, want configChanged", got)
+		}
+	}
+
+	none := func(jsonrpc2.Conn) bool { return false }
+	if errs := group.BroadcastFunc(ctx, none, "filtered", nil); errs != nil {
+		t.Fatalf("got errors %v", errs)
+	}
+
+	clients[0].Close()
+	for len(group.Conns()) != 1 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("closed connection was not removed from the group")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	if errs := group.Broadcast(ctx, "last", nil); errs != nil {
+		t.Fatalf("got errors %v", errs)
+	}
+	if got := <-received; got != "last" {
+		t.Errorf("got notification of %s, want last", got)
+	}
+}
@@ -4,9 +4,16 @@
 package jsonrpc2
 
 import (
+	"container/heap"
 	"context"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+
+	"go.lsp.dev/jsonrpc2/internal/stacks"
 )
 
 // Handler is invoked to handle incoming requests.
@@ -96,25 +103,665 @@ func CancelHandler(handler Handler) (h Handler, canceller func(id ID)) {
 // This allows the stream to unblock at the cost of unbounded goroutines
 // all stalled on the previous one.
 func AsyncHandler(handler Handler) (h Handler) {
-	nextRequest := make(chan struct{})
-	close(nextRequest)
+	return OrderedAsyncHandler(handler, OrderFIFO)
+}
+
+// OrderingPolicy is the order in which an OrderedAsyncHandler processes
+// requests.
+type OrderingPolicy int
+
+const (
+	// OrderFIFO processes every request after the previous one was replied to,
+	// in the order they were received.
+	OrderFIFO OrderingPolicy = iota
+
+	// OrderNotificationsFirst processes notifications as soon as they are
+	// received, without waiting for the queued calls to be replied to.
+	//
+	// Notifications are still processed in the order they were received
+	// relative to each other.
+	OrderNotificationsFirst
+)
+
+// OrderedAsyncHandler is like AsyncHandler, but processes requests in the
+// order defined by policy.
+//
+// Under OrderNotificationsFirst notifications are queued apart from the calls,
+// each in its own goroutine waiting for the previous notification to be
+// replied to. A notification handler may therefore make calls on the Conn
+// without blocking the read loop.
+func OrderedAsyncHandler(handler Handler, policy OrderingPolicy) (h Handler) {
+	calls, notifications := newRequestQueue(), newRequestQueue()
 
 	h = Handler(func(ctx context.Context, reply Replier, req Request) error {
-		waitForPrevious := nextRequest
-		nextRequest = make(chan struct{})
-		unlockNext := nextRequest
+		q := calls
+		if _, ok := req.(*Notification); ok && policy == OrderNotificationsFirst {
+			q = notifications
+		}
+		q.enqueue(ctx, handler, reply, req)
+		return nil
+	})
+
+	return h
+}
+
+// requestQueue processes requests one after the other, each in its own
+// goroutine that waits for the previous request to be replied to.
+//
+// It is not safe for concurrent use, requests are enqueued from the Conn read
+// loop.
+type requestQueue struct {
+	nextRequest chan struct{}
+}
+
+// newRequestQueue returns an empty requestQueue.
+func newRequestQueue() *requestQueue {
+	q := &requestQueue{
+		nextRequest: make(chan struct{}),
+	}
+	close(q.nextRequest)
+	return q
+}
+
+// enqueue passes req to handler once the previously enqueued request was
+// replied to.
+func (q *requestQueue) enqueue(ctx context.Context, handler Handler, reply Replier, req Request) {
+	waitForPrevious := q.nextRequest
+	q.nextRequest = make(chan struct{})
+	unlockNext := q.nextRequest
+	innerReply := reply
+	reply = func(ctx context.Context, result interface{}, err error) error {
+		close(unlockNext)
+		return innerReply(ctx, result, err)
+	}
+
+	go func() {
+		<-waitForPrevious
+		_ = handler(ctx, reply, req)
+	}()
+}
+
+// WatchdogReport describes a request that was not replied to within the
+// watchdog threshold.
+type WatchdogReport struct {
+	// Method is the method name of the request.
+	Method string
+
+	// ID is the id of the request, it is only set if IsCall is true.
+	ID ID
+
+	// IsCall reports whether the request is a call.
+	IsCall bool
+
+	// Elapsed is the time since the request was passed to the handler.
+	Elapsed time.Duration
+
+	// Stack holds the stack traces of all goroutines, captured when the
+	// threshold was exceeded.
+	Stack []byte
+}
+
+// WatchdogHandler returns a handler that calls report for every request that
+// has not been replied to within threshold, as measured by clock, SystemClock
+// if nil.
+//
+// report is called from its own goroutine while the request is still being
+// processed, and may be used to log the report or notify the peer that the
+// server is busy.
+//
+// The watchdog is also stopped if the wrapped handler returns an error without
+// replying, as the request will then never be replied to. When it wraps an
+// AsyncHandler, the measured time includes the time the request spent waiting
+// in the queue.
+func WatchdogHandler(
+	handler Handler,
+	clock Clock,
+	threshold time.Duration,
+	report func(ctx context.Context, r WatchdogReport),
+) (h Handler) {
+	if clock == nil {
+		clock = SystemClock
+	}
+
+	h = Handler(func(ctx context.Context, reply Replier, req Request) error {
+		start := clock.Now()
+		stop := afterFunc(clock, threshold, func() {
+			r := WatchdogReport{
+				Method:  req.Method(),
+				Elapsed: clock.Now().Sub(start),
+				Stack:   stacks.All(),
+			}
+			if call, ok := req.(*Call); ok {
+				r.ID = call.ID()
+				r.IsCall = true
+			}
+			report(ctx, r)
+		})
+
 		innerReply := reply
 		reply = func(ctx context.Context, result interface{}, err error) error {
-			close(unlockNext)
+			stop()
 			return innerReply(ctx, result, err)
 		}
+		if err := handler(ctx, reply, req); err != nil {
+			stop()
+			return err
+		}
+		return nil
+	})
+
+	return h
+}
+
+// PriorityHandler returns a handler that passes requests for the listed
+// methods to preempt as soon as they are received, and all other requests to
+// queue.
+//
+// It is typically used with an AsyncHandler as queue, so that methods such as
+// "$/cancelRequest" or "exit" are not held back behind queued requests:
+//
+//	PriorityHandler(h, AsyncHandler(h), "$/cancelRequest", "exit")
+//
+// preempt is called from the read loop, so a slow preempt stalls reading. It
+// can be run on a bounded pool of goroutines instead with a
+// PriorityQueueHandler:
+//
+//	PriorityHandler(PriorityQueueHandler(h, 4, nil), AsyncHandler(h), methods...)
+func PriorityHandler(preempt, queue Handler, methods ...string) (h Handler) {
+	priority := make(map[string]bool, len(methods))
+	for _, method := range methods {
+		priority[method] = true
+	}
+
+	h = Handler(func(ctx context.Context, reply Replier, req Request) error {
+		if priority[req.Method()] {
+			return preempt(ctx, reply, req)
+		}
+		return queue(ctx, reply, req)
+	})
+
+	return h
+}
+
+// Priority is the priority of a request in a PriorityQueueHandler, requests
+// of higher priority are processed first.
+type Priority int
+
+// list of common priorities.
+const (
+	// PriorityBackground is the priority of requests nobody waits for, such as
+	// the computation of diagnostics.
+	PriorityBackground Priority = -1
+
+	// PriorityNormal is the default priority.
+	PriorityNormal Priority = 0
+
+	// PriorityInteractive is the priority of requests a user waits for, such
+	// as completion or hover.
+	PriorityInteractive Priority = 1
+)
+
+// prioritizedRequest is a request waiting in a PriorityQueueHandler.
+type prioritizedRequest struct {
+	ctx      context.Context
+	reply    Replier
+	req      Request
+	priority Priority
+	seq      uint64 // order of reception
+}
+
+// priorityQueue is a heap of prioritizedRequests.
+type priorityQueue []*prioritizedRequest
+
+func (q priorityQueue) Len() int { return len(q) }
+
+func (q priorityQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q priorityQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *priorityQueue) Push(x interface{}) { *q = append(*q, x.(*prioritizedRequest)) }
+
+func (q *priorityQueue) Pop() interface{} {
+	old := *q
+	r := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return r
+}
+
+// PriorityQueueHandler returns a handler that queues requests, and passes
+// them to handler from at most workers goroutines, in the order of the
+// priority classify returns for them. Requests of the same priority are
+// processed in the order they were received. A nil classify gives all
+// requests PriorityNormal, so that requests are processed in order by a
+// bounded pool of workers.
+//
+// Like AsyncHandler, it returns immediately. A call occupies its worker
+// until it is replied to, or until handler returns an error without replying,
+// and a notification until handler returns. Workers are started as requests
+// are queued, and exit when the queue is empty.
+//
+// PriorityQueueHandler panics if workers is not positive.
+func PriorityQueueHandler(handler Handler, workers int, classify func(Request) Priority) (h Handler) {
+	if workers <= 0 {
+		panic("jsonrpc2: workers must be positive")
+	}
+
+	var (
+		mu      sync.Mutex
+		queue   priorityQueue
+		seq     uint64
+		running int
+	)
+
+	// process passes r to handler, and waits for it to be processed.
+	process := func(r *prioritizedRequest) {
+		done := make(chan struct{})
+		var once sync.Once
+		finish := func() { once.Do(func() { close(done) }) }
+
+		reply := func(ctx context.Context, result interface{}, err error) error {
+			finish()
+			return r.reply(ctx, result, err)
+		}
+		_, isCall := r.req.(*Call)
+		if err := handler(r.ctx, reply, r.req); !isCall || err != nil {
+			finish()
+		}
+		<-done
+	}
 
-		go func() {
-			<-waitForPrevious
-			_ = handler(ctx, reply, req)
-		}()
+	// work processes queued requests until the queue is empty.
+	work := func() {
+		for {
+			mu.Lock()
+			if queue.Len() == 0 {
+				running--
+				mu.Unlock()
+				return
+			}
+			r := heap.Pop(&queue).(*prioritizedRequest)
+			mu.Unlock()
+
+			process(r)
+		}
+	}
+
+	h = Handler(func(ctx context.Context, reply Replier, req Request) error {
+		priority := PriorityNormal
+		if classify != nil {
+			priority = classify(req)
+		}
+
+		mu.Lock()
+		seq++
+		heap.Push(&queue, &prioritizedRequest{
+			ctx:      ctx,
+			reply:    reply,
+			req:      req,
+			priority: priority,
+			seq:      seq,
+		})
+		start := running < workers
+		if start {
+			running++
+		}
+		mu.Unlock()
+
+		if start {
+			go work()
+		}
 		return nil
 	})
 
 	return h
 }
+
+// invalidateKey is the context key of the queuedRequest of a request.
+type invalidateKey struct{}
+
+// queuedRequest is a request waiting in the queue of an InvalidateHandler.
+type queuedRequest struct {
+	ctx     context.Context
+	req     Request
+	reply   Replier
+	replied bool // guarded by the mutex of the InvalidateHandler
+	stale   bool // guarded by the mutex of the InvalidateHandler
+}
+
+// InvalidateHandler returns a handler that processes requests like
+// OrderedAsyncHandler with policy, and a function that can be used to drop
+// queued requests that became stale.
+//
+// invalidate replies to every queued request for which match returns true with
+// ErrContentModified, and the request is then never passed to handler. A
+// request counts as queued from the time it is received until its goroutine
+// starts processing it; requests that are already being processed are not
+// affected. match is called with the lock of the handler held, and must not
+// call invalidate. invalidate returns the number of invalidated requests.
+func InvalidateHandler(handler Handler, policy OrderingPolicy) (h Handler, invalidate func(match func(Request) bool) int) {
+	var mu sync.Mutex
+	queued := make(map[*queuedRequest]struct{})
+
+	// replyOnce sends the first reply of q, later ones are dropped.
+	replyOnce := func(q *queuedRequest, ctx context.Context, result interface{}, err error) error {
+		mu.Lock()
+		replied := q.replied
+		q.replied = true
+		mu.Unlock()
+		if replied {
+			return nil
+		}
+		return q.reply(ctx, result, err)
+	}
+
+	async := OrderedAsyncHandler(func(ctx context.Context, reply Replier, req Request) error {
+		q := ctx.Value(invalidateKey{}).(*queuedRequest)
+
+		mu.Lock()
+		stale := q.stale
+		delete(queued, q)
+		mu.Unlock()
+
+		if stale {
+			// already replied to by invalidate, only release the queue
+			return reply(ctx, nil, nil)
+		}
+		return handler(ctx, reply, req)
+	}, policy)
+
+	h = Handler(func(ctx context.Context, reply Replier, req Request) error {
+		q := &queuedRequest{ctx: ctx, req: req, reply: reply}
+		mu.Lock()
+		queued[q] = struct{}{}
+		mu.Unlock()
+
+		ctx = context.WithValue(ctx, invalidateKey{}, q)
+		return async(ctx, func(ctx context.Context, result interface{}, err error) error {
+			return replyOnce(q, ctx, result, err)
+		}, req)
+	})
+
+	invalidate = func(match func(Request) bool) int {
+		var stale []*queuedRequest
+		mu.Lock()
+		for q := range queued {
+			if !q.stale && match(q.req) {
+				q.stale = true
+				stale = append(stale, q)
+			}
+		}
+		mu.Unlock()
+
+		for _, q := range stale {
+			_ = replyOnce(q, q.ctx, nil, fmt.Errorf("%q: %w", q.req.Method(), ErrContentModified))
+		}
+		return len(stale)
+	}
+
+	return h, invalidate
+}
+
+// InboundRequest describes a call that was received but not yet replied to.
+type InboundRequest struct {
+	// Method is the method name of the call.
+	Method string
+
+	// ID is the id of the call.
+	ID ID
+
+	// Received is when the call was passed to the handler.
+	Received time.Time
+}
+
+// Age returns the time elapsed since the call was received.
+func (r InboundRequest) Age() time.Duration {
+	return time.Since(r.Received)
+}
+
+// TrackingHandler returns a handler that keeps track of the calls passed to the
+// wrapped handler, and a function that lists the calls that have not been
+// replied to yet, oldest first.
+//
+// The list includes both calls waiting in a queue, such as the one of an
+// AsyncHandler, and calls being processed. A call is dropped from the list when
+// it is replied to, or when the wrapped handler returns an error without
+// replying.
+func TrackingHandler(handler Handler) (h Handler, inbound func() []InboundRequest) {
+	type tracked struct {
+		InboundRequest
+		seq uint64 // orders calls received at the same time
+	}
+
+	var (
+		mu       sync.Mutex
+		seq      uint64
+		handling = make(map[ID]tracked)
+	)
+
+	h = Handler(func(ctx context.Context, reply Replier, req Request) error {
+		call, ok := req.(*Call)
+		if !ok {
+			return handler(ctx, reply, req)
+		}
+
+		mu.Lock()
+		seq++
+		handling[call.ID()] = tracked{
+			InboundRequest: InboundRequest{
+				Method:   call.Method(),
+				ID:       call.ID(),
+				Received: time.Now(),
+			},
+			seq: seq,
+		}
+		mu.Unlock()
+
+		done := func() {
+			mu.Lock()
+			delete(handling, call.ID())
+			mu.Unlock()
+		}
+		innerReply := reply
+		reply = func(ctx context.Context, result interface{}, err error) error {
+			done()
+			return innerReply(ctx, result, err)
+		}
+		if err := handler(ctx, reply, req); err != nil {
+			done()
+			return err
+		}
+		return nil
+	})
+
+	inbound = func() []InboundRequest {
+		mu.Lock()
+		all := make([]tracked, 0, len(handling))
+		for _, r := range handling {
+			all = append(all, r)
+		}
+		mu.Unlock()
+
+		sort.Slice(all, func(i, j int) bool {
+			return all[i].seq < all[j].seq
+		})
+		reqs := make([]InboundRequest, len(all))
+		for i, r := range all {
+			reqs[i] = r.InboundRequest
+		}
+		return reqs
+	}
+
+	return h, inbound
+}
+
+// DeadlineHandler returns a handler that passes requests to handler with a
+// context that expires at the deadline returned by deadline, for protocols
+// that carry a timeout or deadline in the params of their requests. The
+// deadline is measured by clock, SystemClock if nil.
+//
+// Requests for which deadline returns false are passed unchanged. Calls whose
+// deadline has already passed are replied to with context.DeadlineExceeded
+// without being passed to handler. The context of a call is released when it is
+// replied to, or when handler returns an error without replying.
+func DeadlineHandler(handler Handler, clock Clock, deadline func(Request) (time.Time, bool)) (h Handler) {
+	if clock == nil {
+		clock = SystemClock
+	}
+
+	h = Handler(func(ctx context.Context, reply Replier, req Request) error {
+		d, ok := deadline(req)
+		if !ok {
+			return handler(ctx, reply, req)
+		}
+
+		call, isCall := req.(*Call)
+		if isCall && !clock.Now().Before(d) {
+			return reply(ctx, nil, fmt.Errorf("%q: %w", call.Method(), context.DeadlineExceeded))
+		}
+
+		ctx, cancel := withDeadline(ctx, clock, d)
+		if !isCall {
+			// the handler may still be processing the notification when it
+			// returns, the context releases itself at the deadline, without
+			// masking context.DeadlineExceeded by a cancellation
+			_ = cancel
+			return handler(ctx, reply, req)
+		}
+
+		innerReply := reply
+		reply = func(ctx context.Context, result interface{}, err error) error {
+			defer cancel()
+			return innerReply(ctx, result, err)
+		}
+		if err := handler(ctx, reply, req); err != nil {
+			cancel()
+			return err
+		}
+		return nil
+	})
+
+	return h
+}
+
+// TimeoutParam returns a deadline function for DeadlineHandler that reads a
+// timeout in milliseconds from the field of the params object of requests,
+// such as "timeout" for {"timeout": 500}. The deadline is relative to when the
+// request is passed to the handler, as measured by clock, SystemClock if nil,
+// which should be the clock of the DeadlineHandler.
+func TimeoutParam(field string, clock Clock) func(Request) (time.Time, bool) {
+	if clock == nil {
+		clock = SystemClock
+	}
+
+	return func(req Request) (time.Time, bool) {
+		var params map[string]json.RawMessage
+		if err := json.Unmarshal(req.Params(), &params); err != nil {
+			return time.Time{}, false
+		}
+		var ms float64
+		if err := json.Unmarshal(params[field], &ms); err != nil || ms < 0 {
+			return time.Time{}, false
+		}
+		return clock.Now().Add(time.Duration(ms * float64(time.Millisecond))), true
+	}
+}
+
+// BarrierHandler returns a handler that holds back requests until a call to
+// method, such as "initialize", has been replied to without an error.
+//
+// Calls to method and requests for the unblocked methods, such as "exit", are
+// passed to handler at once. The held requests are passed to handler in the
+// order they were received once the barrier opens, before any later request,
+// from the goroutine that replied to the call to method. An error returned by
+// handler for a held request is returned from that reply.
+//
+// The barrier does not block the read loop, and the call to method may be
+// processed by an AsyncHandler in handler.
+func BarrierHandler(handler Handler, method string, unblocked ...string) (h Handler) {
+	type heldRequest struct {
+		ctx   context.Context
+		reply Replier
+		req   Request
+	}
+
+	pass := make(map[string]bool, len(unblocked))
+	for _, m := range unblocked {
+		pass[m] = true
+	}
+
+	var (
+		mu   sync.Mutex
+		open bool // no request is held back once set
+		held []heldRequest
+	)
+
+	// release passes the held requests to handler until there are none left,
+	// then opens the barrier.
+	release := func() error {
+		var errs joinedError
+		for {
+			mu.Lock()
+			if len(held) == 0 {
+				open = true
+				mu.Unlock()
+				break
+			}
+			r := held[0]
+			held[0] = heldRequest{}
+			held = held[1:]
+			mu.Unlock()
+
+			if err := handler(r.ctx, r.reply, r.req); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if len(errs) == 0 {
+			return nil
+		}
+		return errs
+	}
+
+	var releasing bool // protected by mu, set while a reply releases requests
+	h = Handler(func(ctx context.Context, reply Replier, req Request) error {
+		if pass[req.Method()] {
+			return handler(ctx, reply, req)
+		}
+
+		_, isCall := req.(*Call)
+		if !isCall || req.Method() != method {
+			mu.Lock()
+			if !open {
+				held = append(held, heldRequest{ctx: ctx, reply: reply, req: req})
+				mu.Unlock()
+				return nil
+			}
+			mu.Unlock()
+			return handler(ctx, reply, req)
+		}
+
+		innerReply := reply
+		reply = func(ctx context.Context, result interface{}, err error) error {
+			if rerr := innerReply(ctx, result, err); rerr != nil || err != nil {
+				return rerr
+			}
+
+			mu.Lock()
+			if open || releasing {
+				mu.Unlock()
+				return nil
+			}
+			releasing = true
+			mu.Unlock()
+			return release()
+		}
+		return handler(ctx, reply, req)
+	})
+
+	return h
+}
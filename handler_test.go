@@ -0,0 +1,574 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/jsonrpc2/fake"
+)
+
+func noopReplier(context.Context, interface{}, error) error { return nil }
+
+func TestWatchdogHandler(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	clock := fake.NewClock(time.Now())
+	reports := make(chan jsonrpc2.WatchdogReport, 1)
+	release := make(chan struct{})
+	h := jsonrpc2.WatchdogHandler(func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		if req.Method() == "slow" {
+			<-release
+		}
+		return reply(ctx, nil, nil)
+	}, clock, 10*time.Millisecond, func(ctx context.Context, r jsonrpc2.WatchdogReport) {
+		reports <- r
+	})
+
+	fast, _ := jsonrpc2.NewCall(jsonrpc2.NewNumberID(1), "fast", nil)
+	if err := h(ctx, noopReplier, fast); err != nil {
+		t.Fatal(err)
+	}
+
+	slow, _ := jsonrpc2.NewCall(jsonrpc2.NewNumberID(2), "slow", nil)
+	done := make(chan error)
+	go func() { done <- h(ctx, noopReplier, slow) }()
+
+	// the timer of the fast call was stopped when it was replied to
+	clock.WaitTimers(1)
+	clock.Advance(10 * time.Millisecond)
+	r := <-reports
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Method != "slow" || !r.IsCall || r.ID != slow.ID() {
+		t.Errorf("got report for %s %v, want slow %v", r.Method, r.ID, slow.ID())
+	}
+	if r.Elapsed != 10*time.Millisecond {
+		t.Errorf("got elapsed %v, want 10ms", r.Elapsed)
+	}
+	if len(r.Stack) == 0 {
+		t.Error("got empty stack")
+	}
+
+	clock.Advance(time.Hour)
+	select {
+	case r := <-reports:
+		t.Errorf("unexpected report for %s", r.Method)
+	default:
+	}
+}
+
+func TestOrderedAsyncHandler(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		policy          jsonrpc2.OrderingPolicy
+		wantNotifyFirst bool
+	}{
+		"fifo": {
+			policy:          jsonrpc2.OrderFIFO,
+			wantNotifyFirst: false,
+		},
+		"notificationsFirst": {
+			policy:          jsonrpc2.OrderNotificationsFirst,
+			wantNotifyFirst: true,
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			release := make(chan struct{})
+			handled := make(chan string, 2)
+			h := jsonrpc2.OrderedAsyncHandler(func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+				if req.Method() == "slow" {
+					<-release
+				}
+				handled <- req.Method()
+				return reply(ctx, nil, nil)
+			}, tt.policy)
+
+			call, _ := jsonrpc2.NewCall(jsonrpc2.NewNumberID(1), "slow", nil)
+			if err := h(ctx, noopReplier, call); err != nil {
+				t.Fatal(err)
+			}
+			notify, _ := jsonrpc2.NewNotification("didChange", nil)
+			go h(ctx, noopReplier, notify)
+
+			select {
+			case method := <-handled:
+				if !tt.wantNotifyFirst {
+					t.Fatalf("%s handled before the queued call", method)
+				}
+				close(release)
+			case <-time.After(50 * time.Millisecond):
+				if tt.wantNotifyFirst {
+					t.Fatal("notification waited for the queued call")
+				}
+				close(release)
+				if method := <-handled; method != "slow" {
+					t.Fatalf("got %s handled first, want slow", method)
+				}
+			}
+			<-handled
+		})
+	}
+}
+
+func TestPriorityHandler(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	release := make(chan struct{})
+	inner := func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		if req.Method() == "slow" {
+			<-release
+		}
+		return reply(ctx, nil, nil)
+	}
+	h := jsonrpc2.PriorityHandler(inner, jsonrpc2.AsyncHandler(inner), "exit")
+
+	slow, _ := jsonrpc2.NewCall(jsonrpc2.NewNumberID(1), "slow", nil)
+	if err := h(ctx, noopReplier, slow); err != nil {
+		t.Fatal(err)
+	}
+
+	exited := make(chan struct{})
+	exit, _ := jsonrpc2.NewNotification("exit", nil)
+	go func() {
+		h(ctx, noopReplier, exit)
+		close(exited)
+	}()
+
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("priority method waited for the queued call")
+	}
+	close(release)
+}
+
+func TestPriorityQueueHandler(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	started, release := make(chan struct{}), make(chan struct{})
+	handled := make(chan string, 4)
+	inner := func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		if req.Method() == "slow" {
+			close(started)
+			<-release
+		}
+		handled <- req.Method()
+		return reply(ctx, nil, nil)
+	}
+	priorities := map[string]jsonrpc2.Priority{
+		"textDocument/completion":    jsonrpc2.PriorityInteractive,
+		"textDocument/documentColor": jsonrpc2.PriorityBackground,
+	}
+	h := jsonrpc2.PriorityQueueHandler(inner, 1, func(req jsonrpc2.Request) jsonrpc2.Priority {
+		return priorities[req.Method()]
+	})
+
+	for i, method := range []string{"slow", "textDocument/documentColor", "textDocument/hover", "textDocument/completion"} {
+		call, _ := jsonrpc2.NewCall(jsonrpc2.NewNumberID(int32(i)), method, nil)
+		if err := h(ctx, noopReplier, call); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			<-started // the others are queued behind the slow call
+		}
+	}
+	close(release)
+
+	want := []string{"slow", "textDocument/completion", "textDocument/hover", "textDocument/documentColor"}
+	for _, method := range want {
+		if got := <-handled; got != method {
+			t.Errorf("got %s handled, want %s", got, method)
+		}
+	}
+}
+
+func TestPriorityHandlerConcurrentPreempt(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	release := make(chan struct{})
+	handled := make(chan string, 3)
+	inner := func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		if req.Method() == "$/cancelRequest" {
+			<-release
+		}
+		handled <- req.Method()
+		return reply(ctx, nil, nil)
+	}
+	h := jsonrpc2.PriorityHandler(jsonrpc2.PriorityQueueHandler(inner, 1, nil), inner, "$/cancelRequest")
+
+	// the slow preempted notifications must not hold back the read loop
+	for _, method := range []string{"$/cancelRequest", "$/cancelRequest", "textDocument/hover"} {
+		notify, _ := jsonrpc2.NewNotification(method, nil)
+		if err := h(ctx, noopReplier, notify); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := <-handled; got != "textDocument/hover" {
+		t.Errorf("got %s handled first, want textDocument/hover", got)
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		<-handled
+	}
+}
+
+func TestWatchdogHandlerError(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	clock := fake.NewClock(time.Now())
+	reports := make(chan jsonrpc2.WatchdogReport, 1)
+	h := jsonrpc2.WatchdogHandler(func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		return errors.New("failed without replying")
+	}, clock, 10*time.Millisecond, func(ctx context.Context, r jsonrpc2.WatchdogReport) {
+		reports <- r
+	})
+
+	call, _ := jsonrpc2.NewCall(jsonrpc2.NewNumberID(1), "failing", nil)
+	if err := h(ctx, noopReplier, call); err == nil {
+		t.Fatal("got nil error from the failing handler")
+	}
+
+	clock.Advance(time.Hour)
+	select {
+	case r := <-reports:
+		t.Errorf("unexpected report for %s", r.Method)
+	default:
+	}
+}
+
+func TestOrderedAsyncHandlerNotificationCalls(t *testing.T) {
+	ctx := context.Background()
+
+	// a notification handler calling back into the peer must not block the read loop
+	a, b := pipeConns(t)
+	results := make(chan string, 1)
+	a.Go(ctx, jsonrpc2.OrderedAsyncHandler(func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		var got string
+		if _, err := a.Call(ctx, methodOneString, "config", &got); err != nil {
+			got = err.Error()
+		}
+		results <- got
+		return reply(ctx, nil, nil)
+	}, jsonrpc2.OrderNotificationsFirst))
+	b.Go(ctx, testHandler())
+
+	if err := b.Notify(ctx, "didOpen", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-results:
+		if want := "got:config"; got != want {
+			t.Errorf("got %q want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("notification handler deadlocked calling the peer")
+	}
+}
+
+func TestInvalidateHandler(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handled := make(chan string, 3)
+	h, invalidate := jsonrpc2.InvalidateHandler(func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		if req.Method() == "slow" {
+			close(started)
+			<-release
+		}
+		handled <- req.Method()
+		return reply(ctx, nil, nil)
+	}, jsonrpc2.OrderFIFO)
+
+	replies := make(chan error, 3)
+	reply := func(ctx context.Context, result interface{}, err error) error {
+		replies <- err
+		return nil
+	}
+	for i, method := range []string{"slow", "stale", "fresh"} {
+		call, _ := jsonrpc2.NewCall(jsonrpc2.NewNumberID(int32(i)), method, nil)
+		if err := h(ctx, reply, call); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// slow is no longer queued once it runs, so only stale is invalidated
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow was never started")
+	}
+	n := invalidate(func(req jsonrpc2.Request) bool {
+		return req.Method() != "fresh"
+	})
+	if n != 1 {
+		t.Errorf("got %d invalidated requests, want 1", n)
+	}
+	select {
+	case err := <-replies:
+		if !errors.Is(err, jsonrpc2.ErrContentModified) {
+			t.Errorf("got %v, want %v", err, jsonrpc2.ErrContentModified)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stale was never replied to")
+	}
+
+	close(release)
+	for _, want := range []string{"slow", "fresh"} {
+		select {
+		case got := <-handled:
+			if got != want {
+				t.Errorf("got %s handled, want %s", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s was never handled", want)
+		}
+		if err := <-replies; err != nil {
+			t.Errorf("got %v replied to %s, want nil", err, want)
+		}
+	}
+}
+
+func TestTrackingHandler(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	release := make(chan struct{})
+	h, inbound := jsonrpc2.TrackingHandler(jsonrpc2.AsyncHandler(func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		<-release
+		return reply(ctx, nil, nil)
+	}))
+
+	replied := make(chan struct{}, 3)
+	reply := func(context.Context, interface{}, error) error {
+		replied <- struct{}{}
+		return nil
+	}
+	first, _ := jsonrpc2.NewCall(jsonrpc2.NewNumberID(1), "first", nil)
+	second, _ := jsonrpc2.NewCall(jsonrpc2.NewStringID("second"), "second", nil)
+	notify, _ := jsonrpc2.NewNotification("notify", nil)
+	for _, req := range []jsonrpc2.Request{first, second, notify} {
+		if err := h(ctx, reply, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reqs := inbound()
+	if len(reqs) != 2 || reqs[0].ID != first.ID() || reqs[1].ID != second.ID() {
+		t.Fatalf("got %+v, want the two calls oldest first", reqs)
+	}
+
+	close(release)
+	for i := 0; i < 3; i++ {
+		select {
+		case <-replied:
+		case <-time.After(5 * time.Second):
+			t.Fatal("requests were never replied to")
+		}
+	}
+	if reqs := inbound(); len(reqs) != 0 {
+		t.Errorf("got %+v after replying, want none", reqs)
+	}
+}
+
+func BenchmarkCancelHandler(b *testing.B) {
+	ctx := context.Background()
+	h, _ := jsonrpc2.CancelHandler(func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		return reply(ctx, nil, nil)
+	})
+
+	notify, err := jsonrpc2.NewNotification("didChange", nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	call, err := jsonrpc2.NewCall(jsonrpc2.NewNumberID(1), "hover", nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for name, req := range map[string]jsonrpc2.Request{"notification": notify, "call": call} {
+		req := req
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := h(ctx, noopReplier, req); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestCancelHandlerNotificationAllocs(t *testing.T) {
+	ctx := context.Background()
+	h, _ := jsonrpc2.CancelHandler(func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		return reply(ctx, nil, nil)
+	})
+	notify, err := jsonrpc2.NewNotification("didChange", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		h(ctx, noopReplier, notify)
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocations per notification, want 0", allocs)
+	}
+}
+
+func TestDeadlineHandler(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	clock := fake.NewClock(time.Now())
+	h := jsonrpc2.DeadlineHandler(func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		_, ok := ctx.Deadline()
+		return reply(ctx, ok, nil)
+	}, clock, jsonrpc2.TimeoutParam("timeout", clock))
+
+	tests := map[string]struct {
+		params       interface{}
+		wantDeadline bool
+		wantErr      error
+	}{
+		"timeout": {
+			params:       map[string]int{"timeout": 1000},
+			wantDeadline: true,
+		},
+		"no timeout": {
+			params: map[string]int{"other": 1000},
+		},
+		"array": {
+			params: []int{1000},
+		},
+		"expired": {
+			params:  map[string]int{"timeout": 0},
+			wantErr: context.DeadlineExceeded,
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			call, err := jsonrpc2.NewCall(jsonrpc2.NewNumberID(1), "method", tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var (
+				gotDeadline interface{}
+				gotErr      error
+			)
+			reply := func(ctx context.Context, result interface{}, err error) error {
+				gotDeadline, gotErr = result, err
+				return nil
+			}
+			if err := h(ctx, reply, call); err != nil {
+				t.Fatal(err)
+			}
+			if !errors.Is(gotErr, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", gotErr, tt.wantErr)
+			}
+			if tt.wantErr == nil && gotDeadline != tt.wantDeadline {
+				t.Errorf("got deadline %v, want %v", gotDeadline, tt.wantDeadline)
+			}
+		})
+	}
+}
+
+func TestDeadlineHandlerExpires(t *testing.T) {
+	t.Parallel()
+
+	clock := fake.NewClock(time.Now())
+	var handled context.Context
+	h := jsonrpc2.DeadlineHandler(func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		handled = ctx
+		return nil
+	}, clock, jsonrpc2.TimeoutParam("timeout", clock))
+
+	notify, _ := jsonrpc2.NewNotification("method", map[string]int{"timeout": 1000})
+	if err := h(context.Background(), noopReplier, notify); err != nil {
+		t.Fatal(err)
+	}
+	if d, ok := handled.Deadline(); !ok || !d.Equal(clock.Now().Add(time.Second)) {
+		t.Errorf("got deadline %v, want in 1s", d)
+	}
+
+	clock.Advance(time.Second - time.Millisecond)
+	if err := handled.Err(); err != nil {
+		t.Fatalf("got %v before the deadline", err)
+	}
+	clock.Advance(time.Millisecond)
+	<-handled.Done()
+	if err := handled.Err(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestBarrierHandler(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var handled []string
+	fail := true
+	h := jsonrpc2.BarrierHandler(func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		handled = append(handled, req.Method())
+		if req.Method() == "initialize" && fail {
+			return reply(ctx, nil, errors.New("not yet"))
+		}
+		return reply(ctx, nil, nil)
+	}, "initialize", "exit")
+
+	send := func(method string, isCall bool) {
+		t.Helper()
+
+		var req jsonrpc2.Request
+		if isCall {
+			req, _ = jsonrpc2.NewCall(jsonrpc2.NewNumberID(1), method, nil)
+		} else {
+			req, _ = jsonrpc2.NewNotification(method, nil)
+		}
+		if err := h(ctx, noopReplier, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	send("a", true)
+	send("exit", false)
+	send("initialize", true)
+	send("b", false)
+	if got, want := strings.Join(handled, " "), "exit initialize"; got != want {
+		t.Fatalf("got %q handled before the barrier opened, want %q", got, want)
+	}
+
+	fail = false
+	send("initialize", true)
+	send("c", true)
+	if got, want := strings.Join(handled, " "), "exit initialize initialize a b c"; got != want {
+		t.Errorf("got %q handled, want %q", got, want)
+	}
+}
@@ -0,0 +1,196 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// hedgeWindow is the number of latencies a Hedger computes its delay from.
+const hedgeWindow = 128
+
+// HedgeStats are the statistics of the calls made by a Hedger.
+type HedgeStats struct {
+	// Calls is the number of calls made.
+	Calls int64
+
+	// Hedged is the number of calls that were sent to more than one backend.
+	Hedged int64
+
+	// HedgeWins is the number of calls answered first by a backend other than
+	// the first one.
+	HedgeWins int64
+}
+
+// Hedger sends latency sensitive calls to equivalent backends, sending a call
+// to the next backend when the previous ones did not answer within a delay.
+//
+// The delay is the given percentile of the latencies of the last successful
+// answered calls, so that only the slowest calls are hedged.
+type Hedger struct {
+	percentile float64
+	clock      Clock
+
+	mu        sync.Mutex
+	latencies []time.Duration // ring buffer of the last latencies
+	next      int             // next index in latencies once it is full
+	delay     time.Duration   // used until latencies are recorded
+	stats     HedgeStats
+}
+
+// NewHedger returns a Hedger delaying hedged calls by percentile, such as 0.95,
+// of the recent latencies, and by delay until latencies are known. Latencies
+// and delays are measured by clock, SystemClock if nil.
+func NewHedger(percentile float64, delay time.Duration, clock Clock) *Hedger {
+	if clock == nil {
+		clock = SystemClock
+	}
+	return &Hedger{
+		percentile: percentile,
+		clock:      clock,
+		delay:      delay,
+	}
+}
+
+// Delay returns the delay after which a call is sent to the next backend.
+func (h *Hedger) Delay() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.latencies) == 0 {
+		return h.delay
+	}
+	sorted := append([]time.Duration(nil), h.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(h.percentile * float64(len(sorted)))
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+// Stats returns the statistics of the calls made so far.
+func (h *Hedger) Stats() HedgeStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.stats
+}
+
+// record records the latency of an answered call.
+func (h *Hedger) record(latency time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.latencies) < hedgeWindow {
+		h.latencies = append(h.latencies, latency)
+		return
+	}
+	h.latencies[h.next] = latency
+	h.next = (h.next + 1) % hedgeWindow
+}
+
+// Call sends the call to backends in order, starting the next one after Delay,
+// or at once when the previous ones failed without a response, and returns the
+// backend that answered first along with the error of its response.
+//
+// The calls still in flight once a response arrived are abandoned by
+// canceling their context. If no backend answers, the first error that
+// occurred is returned.
+func (h *Hedger) Call(
+	ctx context.Context,
+	backends []Conn,
+	method string,
+	params, result interface{},
+) (Conn, error) {
+	if len(backends) == 0 {
+		return nil, ErrNoBackend
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type attempt struct {
+		index int
+		raw   json.RawMessage
+		err   error
+	}
+	attempts := make(chan attempt, len(backends))
+	start := h.clock.Now()
+	next, pending := 0, 0
+	send := func() {
+		i := next
+		next++
+		pending++
+		go func() {
+			var raw json.RawMessage
+			_, err := backends[i].Call(ctx, method, params, &raw)
+			attempts <- attempt{index: i, raw: raw, err: err}
+		}()
+	}
+
+	timer := h.clock.NewTimer(h.Delay())
+	defer timer.Stop()
+
+	var (
+		answer   *attempt
+		firstErr error
+	)
+	send()
+	for answer == nil && pending > 0 {
+		select {
+		case a := <-attempts:
+			pending--
+			var werr *Error
+			if a.err == nil || errors.As(a.err, &werr) {
+				answer = &a
+				break
+			}
+			if firstErr == nil {
+				firstErr = a.err
+			}
+			if next < len(backends) {
+				send()
+			}
+
+		case <-timer.C():
+			if next < len(backends) {
+				send()
+				timer.Reset(h.Delay())
+			}
+		}
+	}
+
+	h.mu.Lock()
+	h.stats.Calls++
+	if next > 1 {
+		h.stats.Hedged++
+	}
+	if answer != nil && answer.index > 0 {
+		h.stats.HedgeWins++
+	}
+	h.mu.Unlock()
+
+	if answer == nil {
+		return nil, firstErr
+	}
+	h.record(h.clock.Now().Sub(start))
+
+	backend := backends[answer.index]
+	if answer.err != nil {
+		return backend, answer.err
+	}
+	if result != nil && len(answer.raw) > 0 {
+		if err := json.Unmarshal(answer.raw, result); err != nil {
+			return backend, fmt.Errorf("unmarshaling result: %w", err)
+		}
+	}
+	return backend, nil
+}
@@ -0,0 +1,72 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/jsonrpc2/fake"
+)
+
+func TestHedger(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	release := make(chan struct{})
+	defer close(release)
+
+	backend := func(name string, slow bool) jsonrpc2.Conn {
+		handler := func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+			go func() {
+				if slow {
+					<-release
+				}
+				reply(ctx, name, nil)
+			}()
+			return nil
+		}
+		a, b := pipeConns(t)
+		a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+		b.Go(ctx, handler)
+		return a
+	}
+	slow, fast := backend("slow", true), backend("fast", false)
+
+	clock := fake.NewClock(time.Now())
+	h := jsonrpc2.NewHedger(0.95, 10*time.Millisecond, clock)
+	var (
+		got string
+		c   jsonrpc2.Conn
+	)
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		c, err = h.Call(ctx, []jsonrpc2.Conn{slow, fast}, "method", nil, &got)
+		done <- err
+	}()
+	clock.WaitTimers(1)
+	clock.Advance(10 * time.Millisecond)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if c != fast || got != "fast" {
+		t.Errorf("got %q answered, want fast", got)
+	}
+	if stats := h.Stats(); stats.Calls != 1 || stats.Hedged != 1 || stats.HedgeWins != 1 {
+		t.Errorf("got stats %+v, want one hedged call won by the hedge", stats)
+	}
+
+	if c, err := h.Call(ctx, []jsonrpc2.Conn{fast, slow}, "method", nil, &got); err != nil || c != fast {
+		t.Fatalf("got %v answered, want fast: %v", c, err)
+	}
+	if stats := h.Stats(); stats.Calls != 2 || stats.Hedged != 1 {
+		t.Errorf("got stats %+v, want the fast call not hedged", stats)
+	}
+	if d := h.Delay(); d != 10*time.Millisecond {
+		t.Errorf("got delay %v, want the 10ms latency of the hedged call", d)
+	}
+}
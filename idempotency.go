@@ -0,0 +1,207 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import (
+	"context"
+	"sync"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// IdempotentResponse is the response to a call kept by an IdempotencyStore.
+type IdempotentResponse struct {
+	// Result is the result of a successful call.
+	Result json.RawMessage
+
+	// Err is the error of a failed call.
+	Err *Error
+}
+
+// IdempotencyStore keeps the responses to the calls with an idempotency key,
+// by method and key.
+type IdempotencyStore interface {
+	// Load returns the response stored for key of method, and whether there
+	// is one.
+	Load(method, key string) (IdempotentResponse, bool)
+
+	// Store stores the response for key of method.
+	Store(method, key string, resp IdempotentResponse)
+}
+
+// IdempotencyKeyParam returns a key function for IdempotencyHandler reading
+// the idempotency key from the string field of a params object.
+func IdempotencyKeyParam(field string) func(Request) (string, bool) {
+	return func(req Request) (string, bool) {
+		var params map[string]json.RawMessage
+		if err := json.Unmarshal(req.Params(), &params); err != nil {
+			return "", false
+		}
+		var key string
+		if err := json.Unmarshal(params[field], &key); err != nil || key == "" {
+			return "", false
+		}
+		return key, true
+	}
+}
+
+// IdempotencyHandler returns a handler that executes the calls with the same
+// idempotency key at most once, for side-effecting methods that callers
+// retry, such as the calls replayed by an Outbox.
+//
+// key returns the idempotency key of a call, and whether it has one; the
+// notifications and the calls without a key are passed to handler unchanged.
+// The response to the first call with a key is kept in store, and replied to
+// the later calls of the same method with that key without passing them to
+// handler. The calls arriving while the first one is being handled are replied
+// to once it is, without blocking the read loop.
+//
+// Keys are only scoped by method. When the handler serves several callers
+// sharing store, such as the tenants of a TenantRouter, key must include the
+// identity of the caller, so that a caller cannot be replied to with the
+// response to another one.
+//
+// Errors marked Retryable are not kept, so that a retry executes the call
+// again.
+func IdempotencyHandler(handler Handler, key func(Request) (string, bool), store IdempotencyStore) (h Handler) {
+	type waiter struct {
+		ctx   context.Context
+		reply Replier
+	}
+	var (
+		mu       sync.Mutex
+		inflight = make(map[idempotencyKey][]waiter) // waiters for the calls being handled
+	)
+
+	h = Handler(func(ctx context.Context, reply Replier, req Request) error {
+		callKey, ok := key(req)
+		if _, isCall := req.(*Call); !isCall || !ok {
+			return handler(ctx, reply, req)
+		}
+		k := idempotencyKey{method: req.Method(), key: callKey}
+
+		mu.Lock()
+		if resp, ok := store.Load(k.method, k.key); ok {
+			mu.Unlock()
+			return replyIdempotent(ctx, reply, resp)
+		}
+		if waiters, ok := inflight[k]; ok {
+			inflight[k] = append(waiters, waiter{ctx: ctx, reply: reply})
+			mu.Unlock()
+			return nil
+		}
+		inflight[k] = nil
+		mu.Unlock()
+
+		// release records the response to the call before the first reply is
+		// written, so that a retry arriving after it is not queued, then
+		// replies to the call with first and to the waiting calls with resp
+		var once sync.Once
+		release := func(resp IdempotentResponse, keep bool, first func() error) error {
+			var waiters []waiter
+			once.Do(func() {
+				mu.Lock()
+				if keep {
+					store.Store(k.method, k.key, resp)
+				}
+				waiters = inflight[k]
+				delete(inflight, k)
+				mu.Unlock()
+			})
+
+			var errs joinedError
+			if first != nil {
+				if err := first(); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			for _, w := range waiters {
+				if err := replyIdempotent(w.ctx, w.reply, resp); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			if len(errs) == 0 {
+				return nil
+			}
+			return errs
+		}
+
+		err := handler(ctx, func(ctx context.Context, result interface{}, err error) error {
+			var resp IdempotentResponse
+			if err != nil {
+				resp.Err = toError(err)
+			} else if resp.Result, err = marshalInterface(result); err != nil {
+				resp.Err = toError(err)
+			}
+			keep := resp.Err == nil || !resp.Err.Retryable()
+			return release(resp, keep, func() error { return reply(ctx, result, err) })
+		}, req)
+		if err != nil {
+			// the call failed without a reply, fail the waiting ones with it
+			release(IdempotentResponse{Err: toError(err)}, false, nil)
+		}
+		return err
+	})
+
+	return h
+}
+
+// replyIdempotent replies to a call with the stored resp.
+func replyIdempotent(ctx context.Context, reply Replier, resp IdempotentResponse) error {
+	if resp.Err != nil {
+		return reply(ctx, nil, resp.Err)
+	}
+	return reply(ctx, resp.Result, nil)
+}
+
+// idempotencyKey is the key of a call of method.
+type idempotencyKey struct {
+	method string
+	key    string
+}
+
+// idempotencyCache is an IdempotencyStore keeping a bounded number of
+// responses in memory.
+type idempotencyCache struct {
+	mu    sync.Mutex
+	size  int
+	keys  []idempotencyKey // in insertion order, to evict the oldest response first
+	resps map[idempotencyKey]IdempotentResponse
+}
+
+// compile time check whether the idempotencyCache implements a IdempotencyStore interface.
+var _ IdempotencyStore = (*idempotencyCache)(nil)
+
+// NewIdempotencyCache returns an IdempotencyStore keeping the last size
+// responses in memory.
+func NewIdempotencyCache(size int) IdempotencyStore {
+	return &idempotencyCache{
+		size:  size,
+		resps: make(map[idempotencyKey]IdempotentResponse, size),
+	}
+}
+
+// Load implements IdempotencyStore.
+func (c *idempotencyCache) Load(method, key string) (IdempotentResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	resp, ok := c.resps[idempotencyKey{method: method, key: key}]
+	return resp, ok
+}
+
+// Store implements IdempotencyStore.
+func (c *idempotencyCache) Store(method, key string, resp IdempotentResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	k := idempotencyKey{method: method, key: key}
+	if _, ok := c.resps[k]; !ok {
+		c.keys = append(c.keys, k)
+	}
+	c.resps[k] = resp
+	for len(c.keys) > c.size {
+		delete(c.resps, c.keys[0])
+		c.keys = c.keys[1:]
+	}
+}
@@ -0,0 +1,89 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+func TestIdempotencyHandler(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var executed, flaky int32
+	started := make(chan struct{}, 8)
+	gate := make(chan struct{})
+	handler := func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		n := atomic.AddInt32(&executed, 1)
+		if req.Method() == "flaky" && atomic.AddInt32(&flaky, 1) == 1 {
+			werr, _ := jsonrpc2.NewError(jsonrpc2.InternalError, "busy").WithData(jsonrpc2.ErrorData{Retryable: true})
+			return reply(ctx, nil, werr)
+		}
+		started <- struct{}{}
+		<-gate
+		return reply(ctx, n, nil)
+	}
+
+	a, b := pipeConns(t)
+	store := jsonrpc2.NewIdempotencyCache(8)
+	a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+	b.Go(ctx, jsonrpc2.IdempotencyHandler(jsonrpc2.AsyncHandler(handler), jsonrpc2.IdempotencyKeyParam("key"), store))
+
+	type params struct {
+		Key string `json:"key,omitempty"`
+	}
+	var wg sync.WaitGroup
+	results := make([]int32, 3)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := a.Call(ctx, "charge", params{Key: "k"}, &results[i]); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	unkeyed := make(chan int32, 1)
+	go func() {
+		var n int32
+		if _, err := a.Call(ctx, "charge", params{}, &n); err != nil {
+			t.Error(err)
+		}
+		unkeyed <- n
+	}()
+	// the duplicates are either queued behind the first keyed call or answered
+	// from the store
+	<-started
+	close(gate)
+	wg.Wait()
+	<-unkeyed
+
+	if results[0] != results[1] || results[1] != results[2] {
+		t.Errorf("got results %v for the calls with the same key, want the same result", results)
+	}
+	if _, ok := store.Load("charge", "k"); !ok {
+		t.Error(`store.Load("charge", "k"): the response was not stored`)
+	}
+	// the keys are scoped by method
+	var refunded int32
+	if _, err := a.Call(ctx, "refund", params{Key: "k"}, &refunded); err != nil || refunded == results[0] {
+		t.Errorf("got %d, %v for another method with the same key, want it executed", refunded, err)
+	}
+
+	if _, err := a.Call(ctx, "flaky", params{Key: "f"}, nil); err == nil {
+		t.Fatal("got no error for the first flaky call")
+	}
+	var n int32
+	if _, err := a.Call(ctx, "flaky", params{Key: "f"}, &n); err != nil {
+		t.Errorf("got %v retrying after a retryable error, want it executed again", err)
+	}
+	if got := atomic.LoadInt32(&executed); got != 5 {
+		t.Errorf("got %d executions, want 5", got)
+	}
+}
@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import "context"
+
+// CallInvoker sends an outgoing call and waits for its response, as Conn.Call
+// does.
+type CallInvoker func(ctx context.Context, method string, params, result interface{}) (ID, error)
+
+// CallInterceptor intercepts outgoing calls made with Conn.Call.
+//
+// It may modify the method or params before passing them on to invoker,
+// inspect the result after invoker returns, or short-circuit the call by
+// returning without calling invoker at all.
+type CallInterceptor func(ctx context.Context, method string, params, result interface{}, invoker CallInvoker) (ID, error)
+
+// NotifyInvoker sends an outgoing notification, as Conn.Notify does.
+type NotifyInvoker func(ctx context.Context, method string, params interface{}) error
+
+// NotifyInterceptor intercepts outgoing notifications sent with Conn.Notify.
+//
+// It may modify the method or params before passing them on to invoker, or
+// drop the notification by returning without calling invoker.
+type NotifyInterceptor func(ctx context.Context, method string, params interface{}, invoker NotifyInvoker) error
+
+// WithCallInterceptors returns a ConnOption that applies interceptors to every
+// outgoing call.
+//
+// The first interceptor is the outermost one.
+func WithCallInterceptors(interceptors ...CallInterceptor) ConnOption {
+	return func(c *conn) {
+		c.callInterceptors = append(c.callInterceptors, interceptors...)
+	}
+}
+
+// WithNotifyInterceptors returns a ConnOption that applies interceptors to
+// every outgoing notification.
+//
+// The first interceptor is the outermost one.
+func WithNotifyInterceptors(interceptors ...NotifyInterceptor) ConnOption {
+	return func(c *conn) {
+		c.notifyInterceptors = append(c.notifyInterceptors, interceptors...)
+	}
+}
+
+// chainCallInterceptors wraps invoker with interceptors.
+func chainCallInterceptors(interceptors []CallInterceptor, invoker CallInvoker) CallInvoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, method string, params, result interface{}) (ID, error) {
+			return interceptor(ctx, method, params, result, next)
+		}
+	}
+	return invoker
+}
+
+// chainNotifyInterceptors wraps invoker with interceptors.
+func chainNotifyInterceptors(interceptors []NotifyInterceptor, invoker NotifyInvoker) NotifyInvoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, method string, params interface{}) error {
+			return interceptor(ctx, method, params, next)
+		}
+	}
+	return invoker
+}
@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"context"
+	"testing"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+func TestCallInterceptors(t *testing.T) {
+	ctx := context.Background()
+
+	var order []string
+	trace := func(name string) jsonrpc2.CallInterceptor {
+		return func(ctx context.Context, method string, params, result interface{}, invoker jsonrpc2.CallInvoker) (jsonrpc2.ID, error) {
+			order = append(order, name)
+			return invoker(ctx, method, params, result)
+		}
+	}
+	rewrite := func(ctx context.Context, method string, params, result interface{}, invoker jsonrpc2.CallInvoker) (jsonrpc2.ID, error) {
+		if method == methodOneString {
+			params = "rewritten"
+		}
+		return invoker(ctx, method, params, result)
+	}
+	cached := func(ctx context.Context, method string, params, result interface{}, invoker jsonrpc2.CallInvoker) (jsonrpc2.ID, error) {
+		if method == "cached" {
+			*(result.(*string)) = "from cache"
+			return jsonrpc2.ID{}, nil
+		}
+		return invoker(ctx, method, params, result)
+	}
+
+	a, b := pipeConns(t, jsonrpc2.WithCallInterceptors(trace("outer"), trace("inner"), rewrite, cached))
+	a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+	b.Go(ctx, testHandler())
+
+	var got string
+	if _, err := a.Call(ctx, methodOneString, "fish", &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := "got:rewritten"; got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+	if len(order) != 2 || order[0] != "outer" || order[1] != "inner" {
+		t.Errorf("got interceptor order %v, want [outer inner]", order)
+	}
+
+	if _, err := a.Call(ctx, "cached", nil, &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := "from cache"; got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+}
+
+func TestNotifyInterceptors(t *testing.T) {
+	ctx := context.Background()
+
+	received := make(chan string, 2)
+	drop := func(ctx context.Context, method string, params interface{}, invoker jsonrpc2.NotifyInvoker) error {
+		if method == "dropped" {
+			return nil
+		}
+		return invoker(ctx, method, params)
+	}
+
+	a, b := pipeConns(t, jsonrpc2.WithNotifyInterceptors(drop))
+	a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+	b.Go(ctx, func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		received <- req.Method()
+		return reply(ctx, nil, nil)
+	})
+
+	if err := a.Notify(ctx, "dropped", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Notify(ctx, "sent", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; got != "sent" {
+		t.Errorf("got %q want %q", got, "sent")
+	}
+}
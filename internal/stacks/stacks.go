@@ -0,0 +1,19 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package stacks captures goroutine stack traces.
+package stacks
+
+import "runtime"
+
+// All returns a formatted stack trace of all goroutines.
+func All() []byte {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
@@ -0,0 +1,328 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// JournalEntry is the record of a single message kept by a Journal.
+type JournalEntry struct {
+	// Time is when the message was read from or written to the stream.
+	Time time.Time
+
+	// Inbound reports whether the message was read from the stream.
+	Inbound bool
+
+	// Method is the method name of the request, or of the call a response
+	// replies to if that call was recorded.
+	Method string
+
+	// ID is the id of a call or response.
+	ID ID
+
+	// IsResponse reports whether the message is a response.
+	IsResponse bool
+
+	// Duration is the time elapsed between a call and its response, it is only
+	// set for responses to recorded calls.
+	Duration time.Duration
+
+	// Code is the error code of a failed response.
+	Code Code
+
+	// Err is the error message of a failed response.
+	Err string
+
+	// Payload is the params of a request or the result of a response,
+	// truncated to the journal payload limit.
+	Payload string
+}
+
+// journalCall is a call waiting for its response.
+type journalCall struct {
+	method string
+	start  time.Time
+
+	// entry is the entry of an outgoing call that is being written, and
+	// recorded reports whether it was added to the journal.
+	entry    JournalEntry
+	recorded bool
+}
+
+// journalKey identifies a call by its direction and ID.
+type journalKey struct {
+	inbound bool
+	id      ID
+}
+
+// Journal is a bounded in-memory record of the most recent messages
+// transferred over a stream.
+//
+// It is intended to reconstruct what happened on a connection without full
+// wire logging.
+type Journal struct {
+	mu         sync.Mutex
+	entries    []JournalEntry
+	next       int
+	full       bool
+	maxPayload int
+	redact     Redactor
+	calls      map[journalKey]*journalCall
+}
+
+// Redactor returns the payload of a message to record in a Journal in place of
+// payload.
+//
+// method is the method name of the request, or of the call a response replies
+// to; it is empty for responses to unknown calls.
+type Redactor func(method string, payload []byte) []byte
+
+// JournalOption configures a Journal created by NewJournal.
+type JournalOption func(*Journal)
+
+// WithRedactor returns a JournalOption that passes every payload through
+// redact before it is recorded.
+func WithRedactor(redact Redactor) JournalOption {
+	return func(j *Journal) {
+		j.redact = redact
+	}
+}
+
+// RedactedPayload replaces the payloads removed by RedactMethods.
+const RedactedPayload = "<redacted>"
+
+// RedactMethods returns a Redactor that replaces the payloads of the given
+// methods, and of the responses to calls of them, with RedactedPayload.
+//
+// Responses to calls that were not recorded are redacted too, as their method
+// is unknown.
+func RedactMethods(methods ...string) Redactor {
+	redacted := make(map[string]bool, len(methods))
+	for _, method := range methods {
+		redacted[method] = true
+	}
+
+	return func(method string, payload []byte) []byte {
+		if len(payload) == 0 || (method != "" && !redacted[method]) {
+			return payload
+		}
+		return []byte(RedactedPayload)
+	}
+}
+
+// NewJournal returns a Journal that keeps the last size messages, with their
+// payloads truncated to maxPayload bytes.
+//
+// A size below 1 keeps the last message only, and a negative maxPayload
+// records no payloads.
+func NewJournal(size, maxPayload int, opts ...JournalOption) *Journal {
+	if size <= 0 {
+		size = 1
+	}
+	if maxPayload < 0 {
+		maxPayload = 0
+	}
+	j := &Journal{
+		entries:    make([]JournalEntry, size),
+		maxPayload: maxPayload,
+		calls:      make(map[journalKey]*journalCall),
+	}
+	for _, opt := range opts {
+		opt(j)
+	}
+	return j
+}
+
+// Entries returns the recorded messages, oldest first.
+func (j *Journal) Entries() []JournalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if !j.full {
+		return append([]JournalEntry(nil), j.entries[:j.next]...)
+	}
+
+	entries := make([]JournalEntry, 0, len(j.entries))
+	entries = append(entries, j.entries[j.next:]...)
+	return append(entries, j.entries[:j.next]...)
+}
+
+// record adds msg to the journal.
+func (j *Journal) record(msg Message, inbound bool) {
+	now := time.Now()
+	e := JournalEntry{
+		Time:    now,
+		Inbound: inbound,
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	switch msg := msg.(type) {
+	case *Call:
+		e.Method, e.ID, e.Payload = msg.method, msg.id, j.payload(msg.method, msg.params)
+		j.track(msg, inbound, now)
+
+	case *Notification:
+		e.Method, e.Payload = msg.method, j.payload(msg.method, msg.params)
+
+	case *Response:
+		e.ID, e.IsResponse = msg.id, true
+		if msg.err != nil {
+			e.Err = msg.err.Error()
+			var werr *Error
+			if errors.As(msg.err, &werr) {
+				e.Code = werr.Code
+			}
+		}
+		key := journalKey{inbound: !inbound, id: msg.id}
+		if call, ok := j.calls[key]; ok {
+			e.Method, e.Duration = call.method, now.Sub(call.start)
+			delete(j.calls, key)
+			if inbound && !call.recorded {
+				// the response was read before the write of the call returned,
+				// the delivered call goes first
+				call.recorded = true
+				j.add(call.entry)
+			}
+		}
+		e.Payload = j.payload(e.Method, msg.result)
+	}
+
+	j.add(e)
+}
+
+// add appends e to the ring of entries.
+//
+// j.mu must be held.
+func (j *Journal) add(e JournalEntry) {
+	j.entries[j.next] = e
+	j.next++
+	if j.next == len(j.entries) {
+		j.next = 0
+		j.full = true
+	}
+}
+
+// track registers an inbound call so that its response can be matched to it.
+//
+// j.mu must be held.
+func (j *Journal) track(call *Call, inbound bool, start time.Time) {
+	if !inbound {
+		// outgoing calls are registered by startCall before they are written
+		return
+	}
+	j.register(journalKey{inbound: inbound, id: call.id}, &journalCall{method: call.method, start: start})
+}
+
+// register adds pending to the calls waiting for a response.
+//
+// j.mu must be held.
+func (j *Journal) register(key journalKey, pending *journalCall) {
+	if len(j.calls) >= len(j.entries) {
+		// drop an arbitrary call that was never answered to keep memory bounded
+		for k := range j.calls {
+			delete(j.calls, k)
+			break
+		}
+	}
+	j.calls[key] = pending
+}
+
+// startCall registers an outgoing call before it is written, as its response
+// may be read before the write returns.
+func (j *Journal) startCall(call *Call) *journalCall {
+	now := time.Now()
+	pending := &journalCall{
+		method: call.method,
+		start:  now,
+		entry: JournalEntry{
+			Time:    now,
+			Method:  call.method,
+			ID:      call.id,
+			Payload: j.payload(call.method, call.params),
+		},
+	}
+
+	j.mu.Lock()
+	j.register(journalKey{inbound: false, id: call.id}, pending)
+	j.mu.Unlock()
+
+	return pending
+}
+
+// endCall records an outgoing call once its write has returned, unless its
+// response was already recorded. Failed calls are forgotten.
+func (j *Journal) endCall(pending *journalCall, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	key := journalKey{inbound: false, id: pending.entry.ID}
+	if err != nil {
+		if j.calls[key] == pending {
+			delete(j.calls, key)
+		}
+		return
+	}
+	if !pending.recorded {
+		pending.recorded = true
+		j.add(pending.entry)
+	}
+}
+
+// payload returns the redacted payload of a message of method as a string of at
+// most maxPayload bytes.
+func (j *Journal) payload(method string, data []byte) string {
+	if j.redact != nil {
+		data = j.redact(method, data)
+	}
+	if len(data) > j.maxPayload {
+		data = data[:j.maxPayload]
+	}
+	return string(data)
+}
+
+// journalStream is a Stream that records messages to a Journal.
+type journalStream struct {
+	Stream
+	journal *Journal
+}
+
+// NewJournalStream returns a Stream that records every message read from or
+// written to s in j.
+func NewJournalStream(s Stream, j *Journal) Stream {
+	return &journalStream{
+		Stream:  s,
+		journal: j,
+	}
+}
+
+// Read implements Stream.Read.
+func (s *journalStream) Read(ctx context.Context) (Message, int64, error) {
+	msg, n, err := s.Stream.Read(ctx)
+	if err == nil {
+		s.journal.record(msg, true)
+	}
+	return msg, n, err
+}
+
+// Write implements Stream.Write.
+func (s *journalStream) Write(ctx context.Context, msg Message) (int64, error) {
+	if call, ok := msg.(*Call); ok {
+		pending := s.journal.startCall(call)
+		n, err := s.Stream.Write(ctx, msg)
+		s.journal.endCall(pending, err)
+		return n, err
+	}
+
+	n, err := s.Stream.Write(ctx, msg)
+	if err == nil {
+		s.journal.record(msg, false)
+	}
+	return n, err
+}
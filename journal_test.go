@@ -0,0 +1,118 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+func TestJournal(t *testing.T) {
+	ctx := context.Background()
+
+	aPipe, bPipe := net.Pipe()
+	journal := jsonrpc2.NewJournal(3, 4)
+	a := jsonrpc2.NewConn(jsonrpc2.NewJournalStream(jsonrpc2.NewStream(aPipe), journal))
+	b := jsonrpc2.NewConn(jsonrpc2.NewStream(bPipe))
+	a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+	b.Go(ctx, testHandler())
+	defer func() {
+		a.Close()
+		b.Close()
+		<-a.Done()
+		<-b.Done()
+	}()
+
+	if err := a.Notify(ctx, "dropped", nil); err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	id, err := a.Call(ctx, methodOneString, "fish", &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Call(ctx, "unknown", nil, nil); err == nil {
+		t.Fatal("got nil error, want method not found")
+	}
+
+	entries := journal.Entries()
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(entries))
+	}
+
+	if e := entries[0]; !e.Inbound || !e.IsResponse || e.Method != methodOneString || e.ID != id || e.Payload != `"got` {
+		t.Errorf("unexpected response entry %+v", e)
+	}
+	if e := entries[1]; e.Inbound || e.IsResponse || e.Method != "unknown" {
+		t.Errorf("unexpected call entry %+v", e)
+	}
+	if e := entries[2]; e.Method != "unknown" || e.Code != jsonrpc2.MethodNotFound || e.Err == "" {
+		t.Errorf("unexpected error entry %+v", e)
+	}
+}
+
+func TestJournalRedaction(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	aPipe, bPipe := net.Pipe()
+	journal := jsonrpc2.NewJournal(4, 64, jsonrpc2.WithRedactor(jsonrpc2.RedactMethods(methodOneString)))
+	a := jsonrpc2.NewConn(jsonrpc2.NewJournalStream(jsonrpc2.NewStream(aPipe), journal))
+	b := jsonrpc2.NewConn(jsonrpc2.NewStream(bPipe))
+	a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+	b.Go(ctx, testHandler())
+	defer func() {
+		a.Close()
+		b.Close()
+		<-a.Done()
+		<-b.Done()
+	}()
+
+	var got string
+	if _, err := a.Call(ctx, methodOneString, "secret", &got); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Notify(ctx, "public", "visible"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := journal.Entries()
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(entries))
+	}
+	for _, e := range entries[:2] {
+		if e.Method != methodOneString || e.Payload != jsonrpc2.RedactedPayload {
+			t.Errorf("unexpected redacted entry %+v", e)
+		}
+	}
+	if e := entries[2]; e.Method != "public" || e.Payload != `"visible"` {
+		t.Errorf("unexpected notification entry %+v", e)
+	}
+}
+
+func TestJournalNegativePayload(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	journal := jsonrpc2.NewJournal(1, -1)
+	stream := jsonrpc2.NewJournalStream(jsonrpc2.NewStream(rwc{nil, io.Discard}), journal)
+
+	notify, err := jsonrpc2.NewNotification("method", "payload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Write(ctx, notify); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := journal.Entries()
+	if len(entries) != 1 || entries[0].Method != "method" || entries[0].Payload != "" {
+		t.Errorf("got entries %+v, want one entry without payload", entries)
+	}
+}
@@ -131,6 +131,25 @@ func run(ctx context.Context, nc io.ReadWriteCloser) jsonrpc2.Conn {
 	return conn
 }
 
+// pipeConns returns two Conns connected by a net.Pipe, with opts applied to a,
+// that are closed once the test and its subtests complete. Both must be started
+// with Go.
+func pipeConns(t *testing.T, opts ...jsonrpc2.ConnOption) (a, b jsonrpc2.Conn) {
+	t.Helper()
+
+	aPipe, bPipe := net.Pipe()
+	a = jsonrpc2.NewConn(jsonrpc2.NewStream(aPipe), opts...)
+	b = jsonrpc2.NewConn(jsonrpc2.NewStream(bPipe))
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+		<-a.Done()
+		<-b.Done()
+	})
+
+	return a, b
+}
+
 func testHandler() jsonrpc2.Handler {
 	return func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
 		switch req.Method() {
@@ -0,0 +1,203 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Limiter caps the number of requests processed at once by the handlers it
+// wraps.
+//
+// A single Limiter may wrap the handlers of many connections, to cap the
+// resources used by a whole process, or the handler of a single connection.
+type Limiter struct {
+	sem chan struct{}
+
+	mu      sync.Mutex
+	waiting int
+	high    int                      // busy above high waiting requests, disabled if 0
+	low     int                      // recovered at low waiting requests or less
+	reject  func(method string) bool // methods rejected while busy, may be nil
+	busy    bool                     // whether the high watermark was crossed
+	peers   map[Conn]*busyPeer       // attached connections
+}
+
+// busyPeer is a Conn attached to a Limiter.
+type busyPeer struct {
+	mu     sync.Mutex // serializes the notifications
+	method string
+}
+
+// BusyParams is the params of the notifications sent by a Limiter to the
+// attached peers.
+type BusyParams struct {
+	// Busy reports whether the Limiter is busy.
+	Busy bool `json:"busy"`
+
+	// Waiting is the number of requests waiting to be processed.
+	Waiting int `json:"waiting"`
+}
+
+// NewLimiter returns a Limiter of n requests processed at once.
+//
+// NewLimiter panics if n is not positive.
+func NewLimiter(n int) *Limiter {
+	if n <= 0 {
+		panic("jsonrpc2: limit must be positive")
+	}
+	return &Limiter{
+		sem:   make(chan struct{}, n),
+		peers: make(map[Conn]*busyPeer),
+	}
+}
+
+// InFlight returns the number of requests being processed.
+func (l *Limiter) InFlight() int { return len(l.sem) }
+
+// Waiting returns the number of requests waiting to be processed.
+func (l *Limiter) Waiting() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.waiting
+}
+
+// Busy reports whether more than the high watermark of requests were waiting,
+// and have not yet dropped back to the low watermark.
+func (l *Limiter) Busy() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.busy
+}
+
+// SetWatermarks makes the Limiter busy when more than high requests are
+// waiting, and recovered when low requests or less are waiting. A high
+// watermark of 0 disables backpressure.
+//
+// While busy, the requests for methods reject returns true for are replied to
+// with ErrServerOverloaded at once, and notifications for them are dropped.
+// reject may be nil to keep every request waiting.
+func (l *Limiter) SetWatermarks(high, low int, reject func(method string) bool) {
+	l.mu.Lock()
+	l.high, l.low, l.reject = high, low, reject
+	l.mu.Unlock()
+	l.wait(0)
+}
+
+// Attach makes the Limiter notify c with method every time it becomes busy
+// or recovers, until c is closed. The params of the notification are
+// BusyParams, and method defaults to MethodBusy if empty.
+//
+// Notifications are sent asynchronously. Each one carries the state of the
+// Limiter at the time it is sent, so that the last one received is current.
+func (l *Limiter) Attach(c Conn, method string) {
+	if method == "" {
+		method = MethodBusy
+	}
+	p := &busyPeer{method: method}
+
+	l.mu.Lock()
+	l.peers[c] = p
+	busy := l.busy
+	l.mu.Unlock()
+
+	OnClose(c, func() {
+		l.mu.Lock()
+		delete(l.peers, c)
+		l.mu.Unlock()
+	})
+	if busy {
+		go l.notify(c, p)
+	}
+}
+
+// wait adds delta to the number of waiting requests, and notifies the
+// attached peers if it makes the Limiter busy or recovered.
+func (l *Limiter) wait(delta int) {
+	l.mu.Lock()
+	l.waiting += delta
+	changed := false
+	switch {
+	case l.high == 0:
+		changed = l.busy
+		l.busy = false
+	case !l.busy && l.waiting > l.high:
+		changed, l.busy = true, true
+	case l.busy && l.waiting <= l.low:
+		changed, l.busy = true, false
+	}
+	if changed {
+		for c, p := range l.peers {
+			go l.notify(c, p)
+		}
+	}
+	l.mu.Unlock()
+}
+
+// notify sends the current state of the Limiter to c.
+func (l *Limiter) notify(c Conn, p *busyPeer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	l.mu.Lock()
+	params := BusyParams{Busy: l.busy, Waiting: l.waiting}
+	l.mu.Unlock()
+	_ = c.Notify(context.Background(), p.method, params)
+}
+
+// rejects reports whether a request for method must be rejected.
+func (l *Limiter) rejects(method string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.busy && l.reject != nil && l.reject(method)
+}
+
+// Handler returns a handler that passes requests to handler once there are
+// less than the limit of requests being processed.
+//
+// A call is processed until it is replied to, or until handler returns an
+// error without replying, and a notification until handler returns. Wrapping
+// a synchronous handler makes the read loop of the Conn wait, which in turn
+// applies backpressure on the peer; wrapping the handler of an AsyncHandler
+// makes the queued requests wait instead.
+//
+// A request whose context is done while waiting is replied to with the
+// error of the context.
+func (l *Limiter) Handler(handler Handler) (h Handler) {
+	h = Handler(func(ctx context.Context, reply Replier, req Request) error {
+		if l.rejects(req.Method()) {
+			return reply(ctx, nil, fmt.Errorf("%q: %w", req.Method(), ErrServerOverloaded))
+		}
+
+		l.wait(1)
+		select {
+		case l.sem <- struct{}{}:
+			l.wait(-1)
+		case <-ctx.Done():
+			l.wait(-1)
+			return reply(ctx, nil, fmt.Errorf("waiting for the limiter: %w", ctx.Err()))
+		}
+
+		var once sync.Once
+		release := func() {
+			once.Do(func() { <-l.sem })
+		}
+
+		_, isCall := req.(*Call)
+		innerReply := reply
+		reply = func(ctx context.Context, result interface{}, err error) error {
+			release()
+			return innerReply(ctx, result, err)
+		}
+		err := handler(ctx, reply, req)
+		if !isCall || err != nil {
+			release()
+		}
+		return err
+	})
+
+	return h
+}
@@ -0,0 +1,143 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+func TestLimiter(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	const limit = 2
+	l := jsonrpc2.NewLimiter(limit)
+
+	var active, peak int32
+	release := make(chan struct{})
+	handler := func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		n := atomic.AddInt32(&active, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		<-release
+		atomic.AddInt32(&active, -1)
+		return reply(ctx, nil, nil)
+	}
+
+	// handlers of separate connections sharing the limiter
+	hs := []jsonrpc2.Handler{l.Handler(handler), l.Handler(handler)}
+	noReply := func(context.Context, interface{}, error) error { return nil }
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		call, err := jsonrpc2.NewCall(jsonrpc2.NewNumberID(int32(i)), "work", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func(h jsonrpc2.Handler) {
+			defer wg.Done()
+			if err := h(ctx, noReply, call); err != nil {
+				t.Error(err)
+			}
+		}(hs[i%len(hs)])
+	}
+	for l.InFlight() < limit || l.Waiting() < 4 {
+		runtime.Gosched() // wait for every request to reach the limiter
+	}
+	close(release)
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&peak); got > limit {
+		t.Errorf("got %d requests processed at once, want at most %d", got, limit)
+	}
+	if got := l.InFlight(); got != 0 {
+		t.Errorf("got %d requests in flight after all replied, want 0", got)
+	}
+}
+
+func TestLimiterBackpressure(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	l := jsonrpc2.NewLimiter(1)
+	l.SetWatermarks(1, 0, func(method string) bool { return method == "background" })
+
+	a, b := pipeConns(t)
+	signals := make(chan jsonrpc2.BusyParams, 4)
+	a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+	b.Go(ctx, func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		var params jsonrpc2.BusyParams
+		if err := json.Unmarshal(req.Params(), &params); err != nil {
+			t.Error(err)
+		}
+		signals <- params
+		return reply(ctx, nil, nil)
+	})
+	l.Attach(a, "")
+
+	release := make(chan struct{})
+	h := l.Handler(func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		<-release
+		return reply(ctx, nil, nil)
+	})
+	call := func(method string) error {
+		req, err := jsonrpc2.NewCall(jsonrpc2.NewNumberID(1), method, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var replied error
+		err = h(ctx, func(ctx context.Context, result interface{}, err error) error {
+			replied = err
+			return nil
+		}, req)
+		if err != nil {
+			return err
+		}
+		return replied
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := call("work"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	if got := <-signals; !got.Busy {
+		t.Errorf("got %+v, want a busy signal", got)
+	}
+	if err := call("background"); !errors.Is(err, jsonrpc2.ErrServerOverloaded) {
+		t.Errorf("got %v for a low priority call while busy, want %v", err, jsonrpc2.ErrServerOverloaded)
+	}
+
+	close(release)
+	wg.Wait()
+	if got := <-signals; got.Busy {
+		t.Errorf("got %+v, want a recovery signal", got)
+	}
+	if l.Busy() {
+		t.Error("limiter still busy after all requests were processed")
+	}
+}
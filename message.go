@@ -7,6 +7,8 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"strings"
+	"sync"
 
 	"github.com/segmentio/encoding/json"
 )
@@ -49,11 +51,14 @@ type Call struct {
 	params json.RawMessage
 	// id of this request, used to tie the Response back to the request.
 	id ID
+	// wire is the wire form of the call if it was read from a stream.
+	wire wireForm
 }
 
 // make sure a Call implements the Request, json.Marshaler and json.Unmarshaler and interfaces.
 var (
 	_ Request          = (*Call)(nil)
+	_ HeaderCarrier    = (*Call)(nil)
 	_ json.Marshaler   = (*Call)(nil)
 	_ json.Unmarshaler = (*Call)(nil)
 )
@@ -79,6 +84,22 @@ func (c *Call) Method() string { return c.method }
 // Params implements Request.
 func (c *Call) Params() json.RawMessage { return c.params }
 
+// Raw returns the bytes of the call as they were read from the stream.
+//
+// It returns nil if the call was not read from a stream, or was decoded
+// WithStreamingDecode.
+func (c *Call) Raw() json.RawMessage { return c.wire.raw }
+
+// Header returns the value of the named header the call was framed with, or
+// the empty string if there is none.
+func (c *Call) Header(name string) string { return c.wire.get(name) }
+
+// SetHeader implements HeaderCarrier.
+func (c *Call) SetHeader(name, value string) { c.wire.set(name, value) }
+
+// HeaderNames implements HeaderCarrier.
+func (c *Call) HeaderNames() []string { return c.wire.names() }
+
 // jsonrpc2Message implements Request.
 func (Call) jsonrpc2Message() {}
 
@@ -92,7 +113,7 @@ func (c Call) MarshalJSON() ([]byte, error) {
 		Params: &c.params,
 		ID:     &c.id,
 	}
-	data, err := json.Marshal(req)
+	data, err := marshalMessage(req)
 	if err != nil {
 		return data, fmt.Errorf("marshaling call: %w", err)
 	}
@@ -130,11 +151,15 @@ type Response struct {
 	err error
 	// ID of the request this is a response to.
 	id ID
+	// header holds the header names and values the response was framed with
+	// in turn, if it was read from a stream.
+	header wireForm
 }
 
 // make sure a Response implements the Message, json.Marshaler and json.Unmarshaler and interfaces.
 var (
 	_ Message          = (*Response)(nil)
+	_ HeaderCarrier    = (*Response)(nil)
 	_ json.Marshaler   = (*Response)(nil)
 	_ json.Unmarshaler = (*Response)(nil)
 )
@@ -160,6 +185,16 @@ func (r *Response) Result() json.RawMessage { return r.result }
 // Err returns the Response error.
 func (r *Response) Err() error { return r.err }
 
+// Header returns the value of the named header the response was framed with,
+// or the empty string if there is none.
+func (r *Response) Header(name string) string { return r.header.get(name) }
+
+// SetHeader implements HeaderCarrier.
+func (r *Response) SetHeader(name, value string) { r.header.set(name, value) }
+
+// HeaderNames implements HeaderCarrier.
+func (r *Response) HeaderNames() []string { return r.header.names() }
+
 // jsonrpc2Message implements Message.
 func (r *Response) jsonrpc2Message() {}
 
@@ -173,7 +208,7 @@ func (r Response) MarshalJSON() ([]byte, error) {
 		resp.Result = &r.result
 	}
 
-	data, err := json.Marshal(resp)
+	data, err := marshalMessage(resp)
 	if err != nil {
 		return data, fmt.Errorf("marshaling notification: %w", err)
 	}
@@ -196,6 +231,7 @@ func (r *Response) UnmarshalJSON(data []byte) error {
 	if resp.Error != nil {
 		r.err = resp.Error
 	}
+	r.id = NullID
 	if resp.ID != nil {
 		r.id = *resp.ID
 	}
@@ -232,11 +268,14 @@ type Notification struct {
 	method string
 
 	params json.RawMessage
+	// wire is the wire form of the notification if it was read from a stream.
+	wire wireForm
 }
 
 // make sure a Notification implements the Request, json.Marshaler and json.Unmarshaler and interfaces.
 var (
 	_ Request          = (*Notification)(nil)
+	_ HeaderCarrier    = (*Notification)(nil)
 	_ json.Marshaler   = (*Notification)(nil)
 	_ json.Unmarshaler = (*Notification)(nil)
 )
@@ -258,6 +297,22 @@ func (n *Notification) Method() string { return n.method }
 // Params implements Request.
 func (n *Notification) Params() json.RawMessage { return n.params }
 
+// Raw returns the bytes of the notification as they were read from the stream.
+//
+// It returns nil if the notification was not read from a stream, or was
+// decoded WithStreamingDecode.
+func (n *Notification) Raw() json.RawMessage { return n.wire.raw }
+
+// Header returns the value of the named header the notification was framed
+// with, or the empty string if there is none.
+func (n *Notification) Header(name string) string { return n.wire.get(name) }
+
+// SetHeader implements HeaderCarrier.
+func (n *Notification) SetHeader(name, value string) { n.wire.set(name, value) }
+
+// HeaderNames implements HeaderCarrier.
+func (n *Notification) HeaderNames() []string { return n.wire.names() }
+
 // jsonrpc2Message implements Request.
 func (Notification) jsonrpc2Message() {}
 
@@ -270,7 +325,7 @@ func (n Notification) MarshalJSON() ([]byte, error) {
 		Method: n.method,
 		Params: &n.params,
 	}
-	data, err := json.Marshal(req)
+	data, err := marshalMessage(req)
 	if err != nil {
 		return data, fmt.Errorf("marshaling notification: %w", err)
 	}
@@ -297,21 +352,43 @@ func (n *Notification) UnmarshalJSON(data []byte) error {
 
 // DecodeMessage decodes data to Message.
 func DecodeMessage(data []byte) (Message, error) {
-	var msg combined
+	return decodeBytes(data, false)
+}
+
+// decodeBytes decodes data to Message, with permissive IDs if permissive.
+func decodeBytes(data []byte, permissive bool) (Message, error) {
 	dec := json.NewDecoder(bytes.NewReader(data))
 	dec.ZeroCopy()
+	return decodeMessage(dec, permissive)
+}
+
+// decodeMessage decodes the next value read by dec to Message.
+//
+// The IDs that are neither int32 numbers nor strings fail decoding, unless
+// permissive, in which case they are kept as raw JSON.
+func decodeMessage(dec *json.Decoder, permissive bool) (Message, error) {
+	var msg combined
 	if err := dec.Decode(&msg); err != nil {
 		return nil, fmt.Errorf("unmarshaling jsonrpc message: %w", err)
 	}
+	id, hasID, err := decodeID(msg.ID, permissive)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshaling jsonrpc message: %w", err)
+	}
 
 	if msg.Method == "" {
 		// no method, should be a response
-		if msg.ID == nil {
-			return nil, ErrInvalidRequest
+		if !hasID || id == NullID {
+			if msg.Error == nil {
+				return nil, ErrInvalidRequest
+			}
+			// the error response to a message that could not be decoded has a
+			// null id
+			return &Response{id: NullID, err: msg.Error}, nil
 		}
 
 		resp := &Response{
-			id: *msg.ID,
+			id: id,
 		}
 		if msg.Error != nil {
 			resp.err = msg.Error
@@ -324,7 +401,7 @@ func DecodeMessage(data []byte) (Message, error) {
 	}
 
 	// has a method, must be a request
-	if msg.ID == nil {
+	if !hasID {
 		// request with no ID is a notify
 		notify := &Notification{
 			method: msg.Method,
@@ -339,7 +416,7 @@ func DecodeMessage(data []byte) (Message, error) {
 	// request with an ID, must be a call
 	call := &Call{
 		method: msg.Method,
-		id:     *msg.ID,
+		id:     id,
 	}
 	if msg.Params != nil {
 		call.params = *msg.Params
@@ -348,11 +425,177 @@ func DecodeMessage(data []byte) (Message, error) {
 	return call, nil
 }
 
+// decodeID decodes the raw id of a message, and reports whether the message
+// has one.
+//
+// A null id counts as no id, unless permissive, in which case it is NullID.
+func decodeID(raw json.RawMessage, permissive bool) (ID, bool, error) {
+	if len(raw) == 0 {
+		return ID{}, false, nil
+	}
+	if string(raw) == "null" {
+		return NullID, permissive, nil
+	}
+
+	var id ID
+	if err := id.UnmarshalJSON(raw); err != nil {
+		if !permissive {
+			return ID{}, false, fmt.Errorf("invalid id %s: %w", raw, err)
+		}
+		return ID{raw: string(raw)}, true, nil
+	}
+	return id, true, nil
+}
+
+// wireForm is the wire form of a request read from a stream.
+type wireForm struct {
+	raw    []byte
+	header []string // header names and values in turn
+}
+
+// get returns the value of the header name.
+func (w wireForm) get(name string) string {
+	for i := 0; i+1 < len(w.header); i += 2 {
+		if w.header[i] == name {
+			return w.header[i+1]
+		}
+	}
+	return ""
+}
+
+// set sets the value of the header name, replacing its current value.
+//
+// The CR and LF characters are stripped from name and value, so that a header
+// cannot end its line early to inject other headers or a message.
+func (w *wireForm) set(name, value string) {
+	name, value = stripNewlines(name), stripNewlines(value)
+	for i := 0; i+1 < len(w.header); i += 2 {
+		if w.header[i] == name {
+			w.header[i+1] = value
+			return
+		}
+	}
+	w.header = append(w.header, name, value)
+}
+
+// stripNewlines returns s without its CR and LF characters.
+func stripNewlines(s string) string {
+	if !strings.ContainsAny(s, "\r\n") {
+		return s
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// names returns the names of the headers, in order.
+func (w wireForm) names() []string {
+	names := make([]string, 0, len(w.header)/2)
+	for i := 0; i+1 < len(w.header); i += 2 {
+		names = append(names, w.header[i])
+	}
+	return names
+}
+
+// HeaderCarrier is implemented by the messages, which carry the headers they
+// were framed with when read by a Stream created by NewStream, so that proxies
+// forwarding messages keep headers such as Content-Type or tracing headers.
+//
+// The stream writes the headers carried by the messages it writes, except
+// the ones it computes itself, such as Content-Length, whatever their case.
+type HeaderCarrier interface {
+	// Header returns the value of the named header, or the empty string if
+	// there is none.
+	Header(name string) string
+
+	// SetHeader sets the value of the named header, with the CR and LF
+	// characters stripped from name and value. It must not be called
+	// concurrently with the writing of the message.
+	SetHeader(name, value string)
+
+	// HeaderNames returns the names of the headers, in order.
+	HeaderNames() []string
+}
+
+// setWireForm records the wire form of msg, and its header.
+func setWireForm(msg Message, raw []byte, header []string) {
+	switch msg := msg.(type) {
+	case *Call:
+		msg.wire = wireForm{raw: raw, header: header}
+	case *Notification:
+		msg.wire = wireForm{raw: raw, header: header}
+	case *Response:
+		msg.header = wireForm{header: header}
+	}
+}
+
+// marshalMessage marshals the message msg.
+//
+// HTML characters are not escaped, so that pre-marshaled params and results
+// are written as they are, only checked to be valid JSON.
+func marshalMessage(msg interface{}) ([]byte, error) {
+	buf := marshalPool.Get().(*[]byte)
+	encoded, err := json.Append((*buf)[:0], msg, 0)
+
+	var data []byte
+	if err == nil {
+		data = append(data, encoded...)
+	}
+	// the copy must be made before the buffer goes back to the pool
+	if cap(encoded) <= maxPooledWrite {
+		*buf = encoded
+		marshalPool.Put(buf)
+	}
+
+	return data, err
+}
+
+// marshalPool holds the buffers messages are marshaled to.
+var marshalPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 1024)
+		return &buf
+	},
+}
+
 // marshalInterface marshal obj to json.RawMessage.
+//
+// Pre-marshaled params and results, given as a json.RawMessage, are used as
+// they are, so that proxies do not pay for marshaling them again.
 func marshalInterface(obj interface{}) (json.RawMessage, error) {
-	data, err := json.Marshal(obj)
+	switch raw := obj.(type) {
+	case json.RawMessage:
+		if raw != nil {
+			return raw, nil
+		}
+	case *json.RawMessage:
+		if raw != nil && *raw != nil {
+			return *raw, nil
+		}
+	}
+
+	data, err := marshalRecover(obj)
 	if err != nil {
 		return json.RawMessage{}, fmt.Errorf("failed to marshal json: %w", err)
 	}
 	return json.RawMessage(data), nil
 }
+
+// marshalRecover marshals obj, turning a panic of a MarshalJSON method into an
+// *Error with code InternalError, and the panic value in the details of its
+// ErrorData.
+func marshalRecover(obj interface{}) (data []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			werr := Errorf(InternalError, "marshaling %T panicked: %v", obj, r)
+			if withData, derr := werr.WithData(ErrorData{Details: []string{fmt.Sprint(r)}}); derr == nil {
+				werr = withData
+			}
+			data, err = nil, werr
+		}
+	}()
+	return json.Marshal(obj)
+}
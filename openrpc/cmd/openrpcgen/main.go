@@ -0,0 +1,63 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Command openrpcgen generates a typed jsonrpc2 client and server router from
+// an OpenRPC document.
+//
+// Usage:
+//
+//	openrpcgen -pkg name [-o file] document.json
+//
+// It is meant to be run by go generate:
+//
+//	//go:generate go run go.lsp.dev/jsonrpc2/openrpc/cmd/openrpcgen -pkg api -o api.go api.openrpc.json
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/segmentio/encoding/json"
+
+	"go.lsp.dev/jsonrpc2/openrpc"
+)
+
+func main() {
+	pkg := flag.String("pkg", "", "name of the generated package")
+	out := flag.String("o", "", "file to write the generated code to, standard output if empty")
+	flag.Parse()
+
+	if *pkg == "" || flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: openrpcgen -pkg name [-o file] document.json")
+		os.Exit(2)
+	}
+
+	if err := run(flag.Arg(0), *pkg, *out); err != nil {
+		fmt.Fprintln(os.Stderr, "openrpcgen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(path, pkg, out string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var doc openrpc.Document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("decoding %s: %w", path, err)
+	}
+
+	src, err := openrpc.Generate(&doc, pkg)
+	if err != nil {
+		return err
+	}
+
+	if out == "" {
+		_, err = os.Stdout.Write(src)
+		return err
+	}
+	return os.WriteFile(out, src, 0o644)
+}
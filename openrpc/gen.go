@@ -0,0 +1,296 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package openrpc
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Generate returns the Go source of package pkg, with typed client stubs and
+// a server router for the methods of doc on top of the jsonrpc2 package.
+//
+// The generated package declares:
+//
+//   - a Client type, with a method per method of doc calling it on a Conn;
+//   - a Server interface, with a method per method of doc to implement;
+//   - a ServerHandler function, returning the Handler routing requests to a
+//     Server, and all other requests to a fallback Handler;
+//   - the params and result types of the methods.
+//
+// Params passed by name become a struct, and params passed by position become
+// arguments. Values of a schema without a single type are json.RawMessage.
+func Generate(doc *Document, pkg string) ([]byte, error) {
+	g := &generator{}
+	methods := make([]Method, len(doc.Methods))
+	copy(methods, doc.Methods)
+	sort.Slice(methods, func(i, j int) bool { return methods[i].Name < methods[j].Name })
+
+	for _, m := range methods {
+		g.types(m)
+	}
+	g.client(methods)
+	g.server(methods)
+	body := g.buf.String()
+
+	// the imports depend on the types and the decoding of params generated
+	g.buf.Reset()
+	g.printf("// Code generated by openrpc.Generate from %q version %s. DO NOT EDIT.\n\n", doc.Info.Title, doc.Info.Version)
+	g.printf("package %s\n\n", pkg)
+	g.printf("import (\n\t\"context\"\n")
+	if strings.Contains(body, "fmt.") {
+		g.printf("\t\"fmt\"\n")
+	}
+	if strings.Contains(body, "json.") {
+		g.printf("\n\t\"github.com/segmentio/encoding/json\"\n")
+	}
+	g.printf("\n\t\"go.lsp.dev/jsonrpc2\"\n)\n\n%s", body)
+
+	src, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code: %w", err)
+	}
+	return src, nil
+}
+
+// generator accumulates generated code.
+type generator struct {
+	buf bytes.Buffer
+}
+
+// printf appends formatted code.
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// exported returns name as an exported Go identifier, such as
+// "TextDocumentHover" for "textDocument/hover".
+func exported(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	if b.Len() == 0 || unicode.IsDigit([]rune(b.String())[0]) {
+		return "X" + b.String()
+	}
+	return b.String()
+}
+
+// unexported returns name as an unexported Go identifier.
+func unexported(name string) string {
+	id := []rune(exported(name))
+	id[0] = unicode.ToLower(id[0])
+	switch s := string(id); s {
+	case "ctx", "params", "result", "err", "c", "server", "handler", "reply", "req":
+		return s + "Param"
+	default:
+		return s
+	}
+}
+
+// byName reports whether the params of m are passed as a struct.
+func byName(m Method) bool {
+	return len(m.Params) > 0 && m.ParamStructure != ByPosition
+}
+
+// goType returns the Go type of the values of s.
+func goType(s *Schema) string {
+	if s == nil || len(s.Type) != 1 {
+		return "json.RawMessage"
+	}
+	switch s.Type[0] {
+	case typeBoolean:
+		return "bool"
+	case typeInteger:
+		return "int64"
+	case typeNumber:
+		return "float64"
+	case typeString:
+		return "string"
+	case typeArray:
+		return "[]" + goType(s.Items)
+	case typeObject:
+		if len(s.Properties) == 0 {
+			return "map[string]json.RawMessage"
+		}
+		return "struct {\n" + fields(s.Properties, nil) + "}"
+	default:
+		return "json.RawMessage"
+	}
+}
+
+// fields returns the fields of a struct of properties, with the required
+// ones not omitted when empty.
+func fields(properties map[string]*Schema, required map[string]bool) string {
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		tag := name
+		if !required[name] {
+			tag += ",omitempty"
+		}
+		fmt.Fprintf(&b, "%s %s `json:%q`\n", exported(name), goType(properties[name]), tag)
+	}
+	return b.String()
+}
+
+// resultType returns the Go type of the result of m.
+func resultType(m Method) string {
+	if s := m.Result.Schema; s != nil && len(s.Type) == 1 && s.Type[0] == typeObject && len(s.Properties) > 0 {
+		return exported(m.Name) + "Result"
+	}
+	return goType(m.Result.Schema)
+}
+
+// types generates the params and result types of m.
+func (g *generator) types(m Method) {
+	name := exported(m.Name)
+	if byName(m) {
+		properties := make(map[string]*Schema, len(m.Params))
+		required := make(map[string]bool, len(m.Params))
+		for _, p := range m.Params {
+			properties[p.Name] = p.Schema
+			required[p.Name] = p.Required
+		}
+		g.printf("// %sParams is the params of %s.\n", name, m.Name)
+		g.printf("type %sParams struct {\n%s}\n\n", name, fields(properties, required))
+	}
+	if m.Result != nil && resultType(m) == name+"Result" {
+		g.printf("// %sResult is the result of %s.\n", name, m.Name)
+		g.printf("type %sResult struct {\n%s}\n\n", name, fields(m.Result.Schema.Properties, nil))
+	}
+}
+
+// signature returns the parameters and results of the Go methods of m.
+func signature(m Method) (params, results string) {
+	params = "ctx context.Context"
+	switch {
+	case byName(m):
+		params += ", params *" + exported(m.Name) + "Params"
+	default:
+		for _, p := range m.Params {
+			params += ", " + unexported(p.Name) + " " + goType(p.Schema)
+		}
+	}
+	results = "error"
+	if m.Result != nil {
+		results = "(" + resultType(m) + ", error)"
+	}
+	return params, results
+}
+
+// paramsExpr returns the expression of the params of a request for m.
+func paramsExpr(m Method) string {
+	switch {
+	case byName(m):
+		return "params"
+	case len(m.Params) == 0:
+		return "nil"
+	default:
+		args := make([]string, len(m.Params))
+		for i, p := range m.Params {
+			args[i] = unexported(p.Name)
+		}
+		return "[]interface{}{" + strings.Join(args, ", ") + "}"
+	}
+}
+
+// client generates the Client type.
+func (g *generator) client(methods []Method) {
+	g.printf("// Client calls the methods of a server on a Conn.\n")
+	g.printf("type Client struct {\n\tConn jsonrpc2.Conn\n}\n\n")
+	for _, m := range methods {
+		params, results := signature(m)
+		name := exported(m.Name)
+		if m.Result == nil {
+			g.printf("// %s sends a notification of %s.\n", name, m.Name)
+			g.printf("func (c *Client) %s(%s) %s {\n", name, params, results)
+			g.printf("\treturn c.Conn.Notify(ctx, %q, %s)\n}\n\n", m.Name, paramsExpr(m))
+			continue
+		}
+		g.printf("// %s calls %s.\n", name, m.Name)
+		g.printf("func (c *Client) %s(%s) %s {\n", name, params, results)
+		g.printf("\tvar result %s\n", resultType(m))
+		g.printf("\t_, err := c.Conn.Call(ctx, %q, %s, &result)\n", m.Name, paramsExpr(m))
+		g.printf("\treturn result, err\n}\n\n")
+	}
+}
+
+// server generates the Server interface and ServerHandler.
+func (g *generator) server(methods []Method) {
+	g.printf("// Server implements the methods of the service.\n")
+	g.printf("type Server interface {\n")
+	for _, m := range methods {
+		params, results := signature(m)
+		g.printf("\t// %s implements %s.\n", exported(m.Name), m.Name)
+		g.printf("\t%s(%s) %s\n\n", exported(m.Name), params, results)
+	}
+	g.printf("}\n\n")
+
+	g.printf("// ServerHandler returns a handler that routes the requests for the methods of\n")
+	g.printf("// the service to server, and all other requests to handler.\n")
+	g.printf("func ServerHandler(server Server, handler jsonrpc2.Handler) jsonrpc2.Handler {\n")
+	g.printf("\treturn func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {\n")
+	g.printf("\t\tswitch req.Method() {\n")
+	for _, m := range methods {
+		g.printf("\t\tcase %q:\n", m.Name)
+		g.decodeParams(m)
+		call := "server." + exported(m.Name) + "(ctx"
+		switch {
+		case byName(m):
+			call += ", &params"
+		default:
+			for _, p := range m.Params {
+				call += ", " + unexported(p.Name)
+			}
+		}
+		call += ")"
+		if m.Result == nil {
+			g.printf("\t\t\treturn reply(ctx, nil, %s)\n", call)
+			continue
+		}
+		g.printf("\t\t\tresult, err := %s\n", call)
+		g.printf("\t\t\treturn reply(ctx, result, err)\n")
+	}
+	g.printf("\t\tdefault:\n\t\t\treturn handler(ctx, reply, req)\n\t\t}\n\t}\n}\n")
+}
+
+// decodeParams generates the decoding of the params of a request for m.
+func (g *generator) decodeParams(m Method) {
+	invalid := "\t\t\t\treturn reply(ctx, nil, fmt.Errorf(\"%%s: %%w\", err, jsonrpc2.ErrInvalidParams))\n"
+	switch {
+	case byName(m):
+		g.printf("\t\t\tvar params %sParams\n", exported(m.Name))
+		g.printf("\t\t\tif err := json.Unmarshal(req.Params(), &params); err != nil {\n" + invalid + "\t\t\t}\n")
+	case len(m.Params) > 0:
+		g.printf("\t\t\tvar args []json.RawMessage\n")
+		g.printf("\t\t\tif err := json.Unmarshal(req.Params(), &args); err != nil {\n" + invalid + "\t\t\t}\n")
+		g.printf("\t\t\targs = append(args, make([]json.RawMessage, %d)...)\n", len(m.Params))
+		for i, p := range m.Params {
+			name := unexported(p.Name)
+			g.printf("\t\t\tvar %s %s\n", name, goType(p.Schema))
+			g.printf("\t\t\tif args[%d] != nil {\n", i)
+			g.printf("\t\t\t\tif err := json.Unmarshal(args[%d], &%s); err != nil {\n\t"+invalid+"\t\t\t\t}\n", i, name)
+			g.printf("\t\t\t}\n")
+		}
+	}
+}
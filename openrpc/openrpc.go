@@ -0,0 +1,230 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package openrpc describes jsonrpc2 services with OpenRPC documents.
+//
+// A Recorder observes the requests of a live connection to produce a document,
+// and a Validator checks requests against a document. Generate turns a
+// document into a typed client and server router.
+//
+// See https://spec.open-rpc.org for the OpenRPC specification. The documents
+// only use the subset of JSON Schema needed to describe observed values.
+package openrpc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Version is the version of the OpenRPC specification of the documents.
+const Version = "1.2.6"
+
+// Document is an OpenRPC document.
+type Document struct {
+	// OpenRPC is the version of the OpenRPC specification.
+	OpenRPC string `json:"openrpc"`
+
+	// Info describes the service.
+	Info Info `json:"info"`
+
+	// Methods are the methods of the service.
+	Methods []Method `json:"methods"`
+}
+
+// Info describes a service.
+type Info struct {
+	// Title is the name of the service.
+	Title string `json:"title"`
+
+	// Version is the version of the service.
+	Version string `json:"version"`
+}
+
+// list of param structures of a Method.
+const (
+	// ByName is the structure of params passed as an object.
+	ByName = "by-name"
+
+	// ByPosition is the structure of params passed as an array.
+	ByPosition = "by-position"
+)
+
+// Method describes a method of a service.
+type Method struct {
+	// Name is the name of the method.
+	Name string `json:"name"`
+
+	// Params describes the params of the method.
+	Params []ContentDescriptor `json:"params"`
+
+	// ParamStructure is ByName or ByPosition, or empty if both are allowed.
+	ParamStructure string `json:"paramStructure,omitempty"`
+
+	// Result describes the result of the method, it is nil for notifications.
+	Result *ContentDescriptor `json:"result,omitempty"`
+}
+
+// ContentDescriptor describes a param or a result.
+type ContentDescriptor struct {
+	// Name is the name of the param or result.
+	Name string `json:"name"`
+
+	// Required reports whether the param must be passed.
+	Required bool `json:"required,omitempty"`
+
+	// Schema is the JSON Schema of the value.
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is the subset of JSON Schema used to describe values.
+type Schema struct {
+	// Type is the list of JSON types of the value, any type if empty.
+	Type []string `json:"type,omitempty"`
+
+	// Properties are the schemas of the properties of an object.
+	Properties map[string]*Schema `json:"properties,omitempty"`
+
+	// Items is the schema of the items of an array.
+	Items *Schema `json:"items,omitempty"`
+}
+
+// list of JSON Schema types.
+const (
+	typeNull    = "null"
+	typeBoolean = "boolean"
+	typeInteger = "integer"
+	typeNumber  = "number"
+	typeString  = "string"
+	typeArray   = "array"
+	typeObject  = "object"
+)
+
+// typeOf returns the JSON Schema type of v, as decoded by json.Unmarshal into
+// an empty interface.
+func typeOf(v interface{}) string {
+	switch v := v.(type) {
+	case bool:
+		return typeBoolean
+	case float64:
+		if v == float64(int64(v)) {
+			return typeInteger
+		}
+		return typeNumber
+	case string:
+		return typeString
+	case []interface{}:
+		return typeArray
+	case map[string]interface{}:
+		return typeObject
+	default:
+		return typeNull
+	}
+}
+
+// schemaOf returns the schema of v, as decoded by json.Unmarshal into an
+// empty interface.
+func schemaOf(v interface{}) *Schema {
+	s := &Schema{
+		Type: []string{typeOf(v)},
+	}
+	switch v := v.(type) {
+	case []interface{}:
+		for _, item := range v {
+			s.Items = merge(s.Items, schemaOf(item))
+		}
+	case map[string]interface{}:
+		s.Properties = make(map[string]*Schema, len(v))
+		for name, value := range v {
+			s.Properties[name] = schemaOf(value)
+		}
+	}
+	return s
+}
+
+// merge returns a schema matching the values of a and b, either may be nil.
+func merge(a, b *Schema) *Schema {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+
+	s := &Schema{
+		Type:  a.Type,
+		Items: merge(a.Items, b.Items),
+	}
+	for _, t := range b.Type {
+		if !hasType(s.Type, t) {
+			s.Type = append(append([]string(nil), s.Type...), t)
+		}
+	}
+	if hasType(s.Type, typeNumber) && hasType(s.Type, typeInteger) {
+		s.Type = removeType(s.Type, typeInteger)
+	}
+	sort.Strings(s.Type)
+
+	if a.Properties != nil || b.Properties != nil {
+		s.Properties = make(map[string]*Schema, len(a.Properties))
+		for name, p := range a.Properties {
+			s.Properties[name] = merge(p, b.Properties[name])
+		}
+		for name, p := range b.Properties {
+			if _, ok := s.Properties[name]; !ok {
+				s.Properties[name] = p
+			}
+		}
+	}
+	return s
+}
+
+// hasType reports whether types contains t.
+func hasType(types []string, t string) bool {
+	for _, typ := range types {
+		if typ == t {
+			return true
+		}
+	}
+	return false
+}
+
+// removeType returns types without t.
+func removeType(types []string, t string) []string {
+	out := make([]string, 0, len(types))
+	for _, typ := range types {
+		if typ != t {
+			out = append(out, typ)
+		}
+	}
+	return out
+}
+
+// check returns an error describing how v, as decoded by json.Unmarshal into
+// an empty interface, does not match s. path locates v in the message.
+func (s *Schema) check(path string, v interface{}) error {
+	if s == nil {
+		return nil
+	}
+
+	typ := typeOf(v)
+	if len(s.Type) > 0 && !hasType(s.Type, typ) && !(typ == typeInteger && hasType(s.Type, typeNumber)) {
+		return fmt.Errorf("%s: got %s, want %s", path, typ, strings.Join(s.Type, " or "))
+	}
+
+	switch v := v.(type) {
+	case []interface{}:
+		for i, item := range v {
+			if err := s.Items.check(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		for name, value := range v {
+			if err := s.Properties[name].check(path+"."+name, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
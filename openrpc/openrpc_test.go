@@ -0,0 +1,149 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package openrpc_test
+
+import (
+	"context"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/segmentio/encoding/json"
+
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/jsonrpc2/openrpc"
+)
+
+func noopReplier(context.Context, interface{}, error) error { return nil }
+
+func TestRecorderValidator(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	recorder := openrpc.NewRecorder()
+	h := recorder.Handler(func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		return reply(ctx, map[string]int{"line": 1}, nil)
+	})
+
+	traffic := []struct {
+		method string
+		params interface{}
+	}{
+		{"hover", map[string]interface{}{"uri": "file:///a.go", "line": 3}},
+		{"hover", map[string]interface{}{"uri": "file:///b.go", "line": 4, "column": 2}},
+		{"add", []float64{1, 2.5}},
+	}
+	for i, tt := range traffic {
+		call, err := jsonrpc2.NewCall(jsonrpc2.NewNumberID(int32(i)), tt.method, tt.params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := h(ctx, noopReplier, call); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	doc := recorder.Document(openrpc.Info{Title: "test", Version: "1"})
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"openrpc":"1.2.6","info":{"title":"test","version":"1"},"methods":[` +
+		`{"name":"add","params":[` +
+		`{"name":"param0","required":true,"schema":{"type":["integer"]}},` +
+		`{"name":"param1","required":true,"schema":{"type":["number"]}}],` +
+		`"paramStructure":"by-position","result":{"name":"result","schema":{"type":["object"],` +
+		`"properties":{"line":{"type":["integer"]}}}}},` +
+		`{"name":"hover","params":[` +
+		`{"name":"line","required":true,"schema":{"type":["integer"]}},` +
+		`{"name":"uri","required":true,"schema":{"type":["string"]}},` +
+		`{"name":"column","schema":{"type":["integer"]}}],` +
+		`"paramStructure":"by-name","result":{"name":"result","schema":{"type":["object"],` +
+		`"properties":{"line":{"type":["integer"]}}}}}]}`
+	if got := string(data); got != want {
+		t.Errorf("got document\n%s\nwant\n%s", got, want)
+	}
+
+	validator := openrpc.NewValidator(doc)
+	tests := map[string]struct {
+		method  string
+		params  string
+		wantErr bool
+	}{
+		"valid":         {method: "hover", params: `{"uri":"file:///c.go","line":1}`},
+		"optional":      {method: "hover", params: `{"uri":"file:///c.go","line":1,"column":3}`},
+		"unknownMethod": {method: "rename", params: `{}`, wantErr: true},
+		"missing":       {method: "hover", params: `{"uri":"file:///c.go"}`, wantErr: true},
+		"wrongType":     {method: "hover", params: `{"uri":1,"line":1}`, wantErr: true},
+		"unknownParam":  {method: "hover", params: `{"uri":"a","line":1,"extra":true}`, wantErr: true},
+		"byName":        {method: "add", params: `{"a":1}`, wantErr: true},
+		"tooMany":       {method: "add", params: `[1,2,3]`, wantErr: true},
+		"integerNumber": {method: "add", params: `[1,2]`},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := validator.Validate(tt.method, json.RawMessage(tt.params))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate(%s, %s): got %v, want error %t", tt.method, tt.params, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	t.Parallel()
+
+	doc := &openrpc.Document{
+		OpenRPC: openrpc.Version,
+		Info:    openrpc.Info{Title: "test", Version: "1"},
+		Methods: []openrpc.Method{
+			{
+				Name: "textDocument/hover",
+				Params: []openrpc.ContentDescriptor{
+					{Name: "uri", Required: true, Schema: &openrpc.Schema{Type: []string{"string"}}},
+				},
+				ParamStructure: openrpc.ByName,
+				Result: &openrpc.ContentDescriptor{Name: "result", Schema: &openrpc.Schema{
+					Type:       []string{"object"},
+					Properties: map[string]*openrpc.Schema{"contents": {Type: []string{"string"}}},
+				}},
+			},
+			{
+				Name: "add",
+				Params: []openrpc.ContentDescriptor{
+					{Name: "a", Required: true, Schema: &openrpc.Schema{Type: []string{"integer"}}},
+					{Name: "b", Required: true, Schema: &openrpc.Schema{Type: []string{"integer"}}},
+				},
+				ParamStructure: openrpc.ByPosition,
+				Result:         &openrpc.ContentDescriptor{Name: "result", Schema: &openrpc.Schema{Type: []string{"integer"}}},
+			},
+			{Name: "exit"},
+		},
+	}
+
+	src, err := openrpc.Generate(doc, "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "api.go", src, 0); err != nil {
+		t.Fatalf("generated code does not parse: %v\n%s", err, src)
+	}
+
+	for _, want := range []string{
+		"type TextDocumentHoverParams struct",
+		"type TextDocumentHoverResult struct",
+		"func (c *Client) Add(ctx context.Context, a int64, b int64) (int64, error)",
+		"func (c *Client) Exit(ctx context.Context) error",
+		"TextDocumentHover(ctx context.Context, params *TextDocumentHoverParams) (TextDocumentHoverResult, error)",
+		"func ServerHandler(server Server, handler jsonrpc2.Handler) jsonrpc2.Handler",
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("generated code does not contain %q:\n%s", want, src)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package openrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/segmentio/encoding/json"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+// Recorder builds an OpenRPC document from the requests it observes.
+type Recorder struct {
+	mu      sync.Mutex
+	methods map[string]*methodRecord
+}
+
+// methodRecord is what a Recorder observed of a method.
+type methodRecord struct {
+	requests   int  // number of requests observed
+	isCall     bool // whether the method was called, rather than notified
+	byName     bool // whether params were passed as an object
+	byPosition bool // whether params were passed as an array
+	params     []*paramRecord
+	result     *Schema
+}
+
+// paramRecord is what a Recorder observed of a param.
+type paramRecord struct {
+	name   string
+	seen   int // number of requests the param was passed in
+	schema *Schema
+}
+
+// NewRecorder returns an empty Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{
+		methods: make(map[string]*methodRecord),
+	}
+}
+
+// Handler returns a handler that records the requests passed to handler, and
+// the results they are replied to with.
+func (r *Recorder) Handler(handler jsonrpc2.Handler) (h jsonrpc2.Handler) {
+	h = jsonrpc2.Handler(func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		_, isCall := req.(*jsonrpc2.Call)
+		r.recordRequest(req.Method(), isCall, req.Params())
+
+		innerReply := reply
+		reply = func(ctx context.Context, result interface{}, err error) error {
+			if isCall && err == nil {
+				r.recordResult(req.Method(), result)
+			}
+			return innerReply(ctx, result, err)
+		}
+		return handler(ctx, reply, req)
+	})
+
+	return h
+}
+
+// method returns the record of name, creating it if needed. r.mu must be held.
+func (r *Recorder) method(name string) *methodRecord {
+	m, ok := r.methods[name]
+	if !ok {
+		m = &methodRecord{}
+		r.methods[name] = m
+	}
+	return m
+}
+
+// recordRequest records a request for method with params.
+func (r *Recorder) recordRequest(method string, isCall bool, params json.RawMessage) {
+	var v interface{}
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, &v); err != nil {
+			return
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m := r.method(method)
+	m.requests++
+	m.isCall = m.isCall || isCall
+	switch v := v.(type) {
+	case []interface{}:
+		m.byPosition = true
+		for i, value := range v {
+			m.param("param" + strconv.Itoa(i)).observe(value)
+		}
+	case map[string]interface{}:
+		m.byName = true
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			m.param(name).observe(v[name])
+		}
+	}
+}
+
+// recordResult records a result of method.
+func (r *Recorder) recordResult(method string, result interface{}) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m := r.method(method)
+	m.result = merge(m.result, schemaOf(v))
+}
+
+// param returns the record of the param name, creating it if needed.
+func (m *methodRecord) param(name string) *paramRecord {
+	for _, p := range m.params {
+		if p.name == name {
+			return p
+		}
+	}
+	p := &paramRecord{name: name}
+	m.params = append(m.params, p)
+	return p
+}
+
+// observe records a value of the param.
+func (p *paramRecord) observe(v interface{}) {
+	p.seen++
+	p.schema = merge(p.schema, schemaOf(v))
+}
+
+// Document returns the document of the methods observed so far.
+//
+// Params passed in every request are required. Methods are sorted by name.
+func (r *Recorder) Document(info Info) *Document {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	doc := &Document{
+		OpenRPC: Version,
+		Info:    info,
+		Methods: make([]Method, 0, len(r.methods)),
+	}
+	for name, m := range r.methods {
+		method := Method{
+			Name:   name,
+			Params: make([]ContentDescriptor, 0, len(m.params)),
+		}
+		switch {
+		case m.byName && !m.byPosition:
+			method.ParamStructure = ByName
+		case m.byPosition && !m.byName:
+			method.ParamStructure = ByPosition
+		}
+		for _, p := range m.params {
+			method.Params = append(method.Params, ContentDescriptor{
+				Name:     p.name,
+				Required: p.seen == m.requests,
+				Schema:   p.schema,
+			})
+		}
+		if m.isCall {
+			schema := m.result
+			if schema == nil {
+				schema = &Schema{}
+			}
+			method.Result = &ContentDescriptor{Name: "result", Schema: schema}
+		}
+		doc.Methods = append(doc.Methods, method)
+	}
+	sort.Slice(doc.Methods, func(i, j int) bool { return doc.Methods[i].Name < doc.Methods[j].Name })
+
+	return doc
+}
+
+// Validator checks requests against an OpenRPC document.
+type Validator struct {
+	methods map[string]*Method
+}
+
+// NewValidator returns a Validator of the methods of doc.
+func NewValidator(doc *Document) *Validator {
+	v := &Validator{
+		methods: make(map[string]*Method, len(doc.Methods)),
+	}
+	for i := range doc.Methods {
+		v.methods[doc.Methods[i].Name] = &doc.Methods[i]
+	}
+	return v
+}
+
+// Validate returns an error describing how a request for method with params
+// does not match the document.
+func (v *Validator) Validate(method string, params json.RawMessage) error {
+	m, ok := v.methods[method]
+	if !ok {
+		return fmt.Errorf("method %q is not in the document", method)
+	}
+
+	var value interface{}
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, &value); err != nil {
+			return fmt.Errorf("%s: params: %w", method, err)
+		}
+	}
+
+	switch value := value.(type) {
+	case []interface{}:
+		if m.ParamStructure == ByName {
+			return fmt.Errorf("%s: params passed by position, want by name", method)
+		}
+		if len(value) > len(m.Params) {
+			return fmt.Errorf("%s: got %d params, want at most %d", method, len(value), len(m.Params))
+		}
+		for i, p := range m.Params {
+			if i >= len(value) {
+				if p.Required {
+					return fmt.Errorf("%s: missing required param %s", method, p.Name)
+				}
+				continue
+			}
+			if err := p.Schema.check(method+": "+p.Name, value[i]); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		if m.ParamStructure == ByPosition {
+			return fmt.Errorf("%s: params passed by name, want by position", method)
+		}
+		known := make(map[string]bool, len(m.Params))
+		for _, p := range m.Params {
+			known[p.Name] = true
+			param, ok := value[p.Name]
+			if !ok {
+				if p.Required {
+					return fmt.Errorf("%s: missing required param %s", method, p.Name)
+				}
+				continue
+			}
+			if err := p.Schema.check(method+": "+p.Name, param); err != nil {
+				return err
+			}
+		}
+		for name := range value {
+			if !known[name] {
+				return fmt.Errorf("%s: unknown param %s", method, name)
+			}
+		}
+	case nil:
+		for _, p := range m.Params {
+			if p.Required {
+				return fmt.Errorf("%s: missing required param %s", method, p.Name)
+			}
+		}
+	default:
+		return errors.New(method + ": params must be an array or an object")
+	}
+	return nil
+}
+
+// Handler returns a handler that validates the requests passed to handler,
+// and calls report with the error of every request that does not match the
+// document. Requests are passed to handler either way.
+func (v *Validator) Handler(
+	handler jsonrpc2.Handler, report func(ctx context.Context, req jsonrpc2.Request, err error),
+) (h jsonrpc2.Handler) {
+	h = jsonrpc2.Handler(func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		if err := v.Validate(req.Method(), req.Params()); err != nil {
+			report(ctx, req, err)
+		}
+		return handler(ctx, reply, req)
+	})
+
+	return h
+}
@@ -0,0 +1,241 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// OutboxEntry is a call journaled by an Outbox until the peer answers it.
+type OutboxEntry struct {
+	// Key identifies the call across process restarts.
+	Key string `json:"key"`
+
+	// Method is the method of the call.
+	Method string `json:"method"`
+
+	// Params is the params of the call.
+	Params json.RawMessage `json:"params,omitempty"`
+
+	// Time is when the call was journaled.
+	Time time.Time `json:"time"`
+}
+
+// OutboxStore persists the entries of an Outbox.
+type OutboxStore interface {
+	// Put stores entry, replacing the entry with the same key.
+	Put(entry OutboxEntry) error
+
+	// Delete removes the entry with key, if any.
+	Delete(key string) error
+
+	// Load returns the stored entries.
+	Load() ([]OutboxEntry, error)
+}
+
+// Outbox journals outgoing calls in an OutboxStore until the peer answers
+// them, so that the calls left unanswered when the process stopped are sent
+// again after a restart with Replay.
+//
+// A call is delivered at least once: the peer may execute a replayed call it
+// already executed if its response was lost, unless it deduplicates the calls
+// by their key.
+type Outbox struct {
+	store OutboxStore
+
+	mu       sync.Mutex
+	inflight map[string]bool // keys of the calls being sent
+}
+
+// NewOutbox returns an Outbox journaling the calls in store.
+func NewOutbox(store OutboxStore) *Outbox {
+	return &Outbox{
+		store:    store,
+		inflight: make(map[string]bool),
+	}
+}
+
+// Call journals the call identified by key, then sends it on c and decodes
+// its result into result.
+//
+// The call is removed from the journal once the peer answered it, with either
+// a result or an error response. It stays journaled if it fails otherwise,
+// such as when the connection is lost.
+func (o *Outbox) Call(ctx context.Context, c Conn, key, method string, params, result interface{}) error {
+	raw, err := marshalInterface(params)
+	if err != nil {
+		return err
+	}
+	entry := OutboxEntry{Key: key, Method: method, Params: raw, Time: time.Now()}
+	if err := o.store.Put(entry); err != nil {
+		return fmt.Errorf("journaling %q: %w", key, err)
+	}
+
+	var rawResult json.RawMessage
+	if err := o.send(ctx, c, entry, &rawResult); err != nil {
+		return err
+	}
+	if result != nil && len(rawResult) > 0 {
+		if err := json.Unmarshal(rawResult, result); err != nil {
+			return fmt.Errorf("unmarshaling result: %w", err)
+		}
+	}
+	return nil
+}
+
+// Replay sends again the journaled calls that are not being sent, in the order
+// they were journaled, and passes each answer to onResult.
+//
+// Replay stops at the first call that fails without an answer from the peer,
+// and returns its error.
+func (o *Outbox) Replay(ctx context.Context, c Conn, onResult func(entry OutboxEntry, result json.RawMessage, err error)) error {
+	entries, err := o.store.Load()
+	if err != nil {
+		return fmt.Errorf("loading the journal: %w", err)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+
+	for _, entry := range entries {
+		o.mu.Lock()
+		busy := o.inflight[entry.Key]
+		o.mu.Unlock()
+		if busy {
+			continue
+		}
+
+		var result json.RawMessage
+		err := o.send(ctx, c, entry, &result)
+		var werr *Error
+		if err != nil && !errors.As(err, &werr) {
+			return err
+		}
+		if onResult != nil {
+			onResult(entry, result, err)
+		}
+	}
+	return nil
+}
+
+// send sends the call of entry on c, and removes entry from the journal once
+// the peer answered it.
+func (o *Outbox) send(ctx context.Context, c Conn, entry OutboxEntry, result *json.RawMessage) error {
+	o.mu.Lock()
+	o.inflight[entry.Key] = true
+	o.mu.Unlock()
+	defer func() {
+		o.mu.Lock()
+		delete(o.inflight, entry.Key)
+		o.mu.Unlock()
+	}()
+
+	_, err := c.Call(ctx, entry.Method, entry.Params, result)
+	var werr *Error
+	if err != nil && !errors.As(err, &werr) {
+		// no answer, keep the call journaled
+		return err
+	}
+	if derr := o.store.Delete(entry.Key); derr != nil && err == nil {
+		err = fmt.Errorf("removing %q from the journal: %w", entry.Key, derr)
+	}
+	return err
+}
+
+// fileOutboxStore is an OutboxStore keeping each entry in a file.
+type fileOutboxStore struct {
+	dir string
+}
+
+// compile time check whether the fileOutboxStore implements a OutboxStore interface.
+var _ OutboxStore = (*fileOutboxStore)(nil)
+
+// NewFileOutboxStore returns an OutboxStore keeping each entry in a JSON file
+// in dir, which is created if needed.
+func NewFileOutboxStore(dir string) (OutboxStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating the outbox directory: %w", err)
+	}
+	return &fileOutboxStore{dir: dir}, nil
+}
+
+// outboxExt is the extension of the files of a fileOutboxStore.
+const outboxExt = ".json"
+
+// path returns the path of the file of the entry with key.
+func (s *fileOutboxStore) path(key string) string {
+	return filepath.Join(s.dir, hex.EncodeToString([]byte(key))+outboxExt)
+}
+
+// Put implements OutboxStore.
+//
+// The entry is written to a temporary file renamed into place, so that a
+// crash never leaves a partial entry.
+func (s *fileOutboxStore) Put(entry OutboxEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, "put-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(entry.Key))
+}
+
+// Delete implements OutboxStore.
+func (s *fileOutboxStore) Delete(key string) error {
+	if err := os.Remove(s.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Load implements OutboxStore.
+func (s *fileOutboxStore) Load() ([]OutboxEntry, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []OutboxEntry
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), outboxExt) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var entry OutboxEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return nil, fmt.Errorf("%s: %w", file.Name(), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
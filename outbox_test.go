@@ -0,0 +1,83 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+func TestOutbox(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	connect := func(handler jsonrpc2.Handler) (jsonrpc2.Conn, func()) {
+		a, b := pipeConns(t)
+		a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+		b.Go(ctx, jsonrpc2.AsyncHandler(handler))
+		return a, func() {
+			a.Close()
+			<-a.Done()
+			<-b.Done()
+		}
+	}
+
+	store, err := jsonrpc2.NewFileOutboxStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unresponsive, closeUnresponsive := connect(func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		<-ctx.Done()
+		return nil
+	})
+	outbox := jsonrpc2.NewOutbox(store)
+	for _, key := range []string{"job/1", "job/2"} {
+		callCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		err := outbox.Call(callCtx, unresponsive, key, "run", key, nil)
+		cancel()
+		if err == nil {
+			t.Fatalf("outbox.Call(%q): got an answer from an unresponsive peer", key)
+		}
+	}
+	closeUnresponsive()
+
+	// restart with the journal left on disk
+	store, err = jsonrpc2.NewFileOutboxStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo, closeEcho := connect(func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		return reply(ctx, req.Params(), nil)
+	})
+	defer closeEcho()
+
+	outbox = jsonrpc2.NewOutbox(store)
+	var replayed []string
+	err = outbox.Replay(ctx, echo, func(entry jsonrpc2.OutboxEntry, result json.RawMessage, err error) {
+		if err != nil {
+			t.Errorf("replaying %q: %v", entry.Key, err)
+		}
+		replayed = append(replayed, entry.Key+"="+string(result))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(replayed), 2; got != want || replayed[0] != `job/1="job/1"` || replayed[1] != `job/2="job/2"` {
+		t.Errorf("got replayed calls %v, want job/1 and job/2 in order", replayed)
+	}
+
+	var got string
+	if err := outbox.Call(ctx, echo, "job/3", "run", "job/3", &got); err != nil || got != "job/3" {
+		t.Errorf(`outbox.Call("job/3"): got %q, %v, want job/3`, got, err)
+	}
+	if entries, err := store.Load(); err != nil || len(entries) != 0 {
+		t.Errorf("got %d entries left journaled, %v, want none", len(entries), err)
+	}
+}
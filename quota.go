@@ -0,0 +1,134 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Usage is the usage of a method by an identity.
+type Usage struct {
+	// Requests is the number of requests.
+	Requests int64
+
+	// Bytes is the size of the request messages, as reported by ReceivedBytes.
+	Bytes int64
+}
+
+// UsageStore stores the usage accounted by an Accounting, so that it can be
+// persisted or shared by several processes.
+type UsageStore interface {
+	// Add adds delta to the usage of method by identity, and returns the usage
+	// after the addition.
+	Add(identity, method string, delta Usage) (Usage, error)
+}
+
+// usageKey is the key of a usage in a MemoryUsageStore.
+type usageKey struct {
+	identity string
+	method   string
+}
+
+// MemoryUsageStore is a UsageStore keeping the usage in memory.
+type MemoryUsageStore struct {
+	mu    sync.Mutex
+	usage map[usageKey]Usage
+}
+
+// compile time check whether the MemoryUsageStore implements UsageStore interface.
+var _ UsageStore = (*MemoryUsageStore)(nil)
+
+// NewMemoryUsageStore returns an empty MemoryUsageStore.
+func NewMemoryUsageStore() *MemoryUsageStore {
+	return &MemoryUsageStore{
+		usage: make(map[usageKey]Usage),
+	}
+}
+
+// Add implements UsageStore.Add.
+func (s *MemoryUsageStore) Add(identity, method string, delta Usage) (Usage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := usageKey{identity: identity, method: method}
+	u := s.usage[key]
+	u.Requests += delta.Requests
+	u.Bytes += delta.Bytes
+	s.usage[key] = u
+	return u, nil
+}
+
+// Usage returns the usage of method by identity.
+func (s *MemoryUsageStore) Usage(identity, method string) Usage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.usage[usageKey{identity: identity, method: method}]
+}
+
+// Quota limits the usage of a method by an identity. A zero limit is
+// unlimited.
+type Quota struct {
+	// Requests is the maximum number of requests.
+	Requests int64
+
+	// Bytes is the maximum size of the request messages.
+	Bytes int64
+}
+
+// exceeded reports whether u exceeds q.
+func (q Quota) exceeded(u Usage) bool {
+	return (q.Requests > 0 && u.Requests > q.Requests) || (q.Bytes > 0 && u.Bytes > q.Bytes)
+}
+
+// Accounting accounts the usage of methods per identity, and enforces quotas
+// on it.
+type Accounting struct {
+	// Store stores the usage.
+	Store UsageStore
+
+	// Identity returns the identity of the peer of a request from its context.
+	// If nil, all requests are accounted to the empty identity.
+	Identity func(ctx context.Context) string
+
+	// Quota returns the quota of method for identity. If nil, the usage is only
+	// accounted.
+	Quota func(identity, method string) Quota
+
+	// Code is the error code of the requests over quota, ServerOverloaded if 0.
+	Code Code
+}
+
+// Handler returns a handler that accounts the requests passed to handler,
+// and rejects the requests over quota.
+//
+// Every request is accounted, including the rejected ones, so that the usage
+// reflects the traffic of each identity. Calls over quota are replied to with
+// an error of the configured code, notifications over quota are dropped. The
+// error of the store is returned by the handler, which makes the Conn fail.
+func (a *Accounting) Handler(handler Handler) (h Handler) {
+	h = Handler(func(ctx context.Context, reply Replier, req Request) error {
+		var identity string
+		if a.Identity != nil {
+			identity = a.Identity(ctx)
+		}
+
+		u, err := a.Store.Add(identity, req.Method(), Usage{Requests: 1, Bytes: ReceivedBytes(ctx)})
+		if err != nil {
+			return fmt.Errorf("accounting %q: %w", req.Method(), err)
+		}
+
+		if a.Quota != nil && a.Quota(identity, req.Method()).exceeded(u) {
+			code := a.Code
+			if code == 0 {
+				code = ServerOverloaded
+			}
+			return reply(ctx, nil, Errorf(code, "quota of %q exceeded", req.Method()))
+		}
+		return handler(ctx, reply, req)
+	})
+
+	return h
+}
@@ -0,0 +1,74 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+type identityKey struct{}
+
+func TestAccounting(t *testing.T) {
+	t.Parallel()
+
+	store := jsonrpc2.NewMemoryUsageStore()
+	const quotaExceeded jsonrpc2.Code = -32010
+	accounting := &jsonrpc2.Accounting{
+		Store: store,
+		Identity: func(ctx context.Context) string {
+			identity, _ := ctx.Value(identityKey{}).(string)
+			return identity
+		},
+		Quota: func(identity, method string) jsonrpc2.Quota {
+			if method == "expensive" {
+				return jsonrpc2.Quota{Requests: 2}
+			}
+			return jsonrpc2.Quota{}
+		},
+		Code: quotaExceeded,
+	}
+	h := accounting.Handler(func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		return reply(ctx, true, nil)
+	})
+
+	call := func(identity, method string) error {
+		ctx := context.WithValue(context.Background(), identityKey{}, identity)
+		req, err := jsonrpc2.NewCall(jsonrpc2.NewNumberID(1), method, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var replied error
+		if err := h(ctx, func(ctx context.Context, result interface{}, err error) error {
+			replied = err
+			return nil
+		}, req); err != nil {
+			t.Fatal(err)
+		}
+		return replied
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := call("alice", "expensive"); err != nil {
+			t.Fatalf("call %d within quota: %v", i, err)
+		}
+	}
+	var werr *jsonrpc2.Error
+	if err := call("alice", "expensive"); !errors.As(err, &werr) || werr.Code != quotaExceeded {
+		t.Errorf("got %v over quota, want an error of code %d", err, quotaExceeded)
+	}
+	if err := call("bob", "expensive"); err != nil {
+		t.Errorf("got %v for another identity, want the quota to be per identity", err)
+	}
+	if err := call("alice", "cheap"); err != nil {
+		t.Errorf("got %v for another method, want the quota to be per method", err)
+	}
+
+	if got := store.Usage("alice", "expensive").Requests; got != 3 {
+		t.Errorf("got %d requests accounted, want 3", got)
+	}
+}
@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ReservedMethodPrefix is the prefix of method names reserved for rpc-internal
+// methods and extensions.
+//
+// The JSON-RPC spec forbids it from being used for anything else.
+const ReservedMethodPrefix = "rpc."
+
+// list of built-in rpc-internal methods.
+const (
+	// MethodListMethods is the built-in method that replies with the sorted list
+	// of method names served by the connection.
+	MethodListMethods = ReservedMethodPrefix + "listMethods"
+
+	// MethodClose is the notification sent by CloseWithError, with the reason
+	// of the close as an Error in its params. It stops the Conns created
+	// WithPeerClose.
+	MethodClose = ReservedMethodPrefix + "close"
+
+	// MethodBusy is the default notification sent by a Limiter to the attached
+	// peers when it becomes busy or recovers, with BusyParams.
+	MethodBusy = ReservedMethodPrefix + "busy"
+
+	// MethodChunk is the notification sent by a ChunkingHandler with each
+	// chunk of a large result, with ChunkParams.
+	MethodChunk = ReservedMethodPrefix + "chunk"
+
+	// MethodCapabilities is the call exchanging the methods served by both
+	// peers of a Capabilities, with CapabilitiesParams as params and result.
+	MethodCapabilities = ReservedMethodPrefix + "capabilities"
+
+	// MethodRegister is the notification sent by Capabilities.Register with
+	// the methods a peer starts serving, with CapabilitiesParams.
+	MethodRegister = ReservedMethodPrefix + "register"
+
+	// MethodUnregister is the notification sent by Capabilities.Unregister with
+	// the methods a peer stops serving, with CapabilitiesParams.
+	MethodUnregister = ReservedMethodPrefix + "unregister"
+
+	// MethodExtensions is the call negotiating the extensions enabled on a
+	// connection, with ExtensionsParams as params and result.
+	MethodExtensions = ReservedMethodPrefix + "extensions"
+
+	// MethodAckNotify is the call sent by NotifyAcked to deliver a
+	// notification that the peer acknowledges, with AckParams.
+	MethodAckNotify = ReservedMethodPrefix + "ackNotify"
+)
+
+// IsReservedMethod reports whether the method is in the reserved "rpc." namespace.
+func IsReservedMethod(method string) bool {
+	return strings.HasPrefix(method, ReservedMethodPrefix)
+}
+
+// ReservedHandler returns a handler that keeps the reserved "rpc." method
+// namespace away from the wrapped handler.
+//
+// Requests for reserved methods never reach the wrapped handler; they are
+// answered by the built-in introspection methods or rejected with the standard
+// method not found response.
+//
+// methods is the list of method names served by the wrapped handler, it is
+// reported by the MethodListMethods built-in. If methods is nil, the
+// introspection built-ins are disabled. ReservedHandler panics if methods
+// contains a reserved method name, as the wrapped handler can never serve it.
+//
+// The reservation is opt-in: a Conn does not enforce it unless its handler is
+// wrapped with ReservedHandler.
+func ReservedHandler(handler Handler, methods []string) (h Handler) {
+	var list []string
+	if methods != nil {
+		list = make([]string, 0, len(methods))
+		for _, method := range methods {
+			if IsReservedMethod(method) {
+				panic(fmt.Errorf("method %q is in the reserved %q namespace", method, ReservedMethodPrefix))
+			}
+			list = append(list, method)
+		}
+		sort.Strings(list)
+	}
+
+	h = Handler(func(ctx context.Context, reply Replier, req Request) error {
+		if !IsReservedMethod(req.Method()) {
+			return handler(ctx, reply, req)
+		}
+
+		if list != nil && req.Method() == MethodListMethods {
+			return reply(ctx, list, nil)
+		}
+
+		return reply(ctx, nil, fmt.Errorf("%q: %w", req.Method(), ErrMethodNotFound))
+	})
+
+	return h
+}
@@ -0,0 +1,89 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+func TestReservedHandler(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	called := false
+	inner := func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		called = true
+		return reply(ctx, true, nil)
+	}
+	h := jsonrpc2.ReservedHandler(inner, []string{"b", "a"})
+
+	tests := map[string]struct {
+		method     string
+		wantResult interface{}
+		wantErr    error
+		wantCalled bool
+	}{
+		"user": {
+			method:     "a",
+			wantResult: true,
+			wantCalled: true,
+		},
+		"listMethods": {
+			method:     jsonrpc2.MethodListMethods,
+			wantResult: []string{"a", "b"},
+		},
+		"unknownReserved": {
+			method:  "rpc.unknown",
+			wantErr: jsonrpc2.ErrMethodNotFound,
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			called = false
+			call, err := jsonrpc2.NewCall(jsonrpc2.NewNumberID(1), tt.method, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var (
+				gotResult interface{}
+				gotErr    error
+			)
+			reply := func(ctx context.Context, result interface{}, err error) error {
+				gotResult, gotErr = result, err
+				return nil
+			}
+			if err := h(ctx, reply, call); err != nil {
+				t.Fatal(err)
+			}
+
+			if called != tt.wantCalled {
+				t.Errorf("handler called: got %v want %v", called, tt.wantCalled)
+			}
+			if !reflect.DeepEqual(gotResult, tt.wantResult) {
+				t.Errorf("result: got %v want %v", gotResult, tt.wantResult)
+			}
+			if !errors.Is(gotErr, tt.wantErr) {
+				t.Errorf("error: got %v want %v", gotErr, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReservedHandlerPanics(t *testing.T) {
+	t.Parallel()
+
+	defer func() {
+		if recover() == nil {
+			t.Error("ReservedHandler did not panic on a reserved method name")
+		}
+	}()
+	jsonrpc2.ReservedHandler(jsonrpc2.MethodNotFoundHandler, []string{"a", "rpc.hidden"})
+}
@@ -0,0 +1,120 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// RetryPolicy retries the outgoing calls to idempotent methods that failed
+// with a transient error.
+//
+// Each attempt is a new call, with a new ID.
+type RetryPolicy struct {
+	// Methods lists the idempotent methods, calls to other methods are never
+	// retried.
+	Methods []string
+
+	// MaxAttempts is the maximum number of attempts of a call, including the
+	// first one.
+	MaxAttempts int
+
+	// Backoff returns the delay before the given retry, counting from 1. No
+	// delay is observed if it is nil.
+	Backoff func(retry int) time.Duration
+
+	// Codes lists the error codes of the responses that are retried, such as
+	// ServerOverloaded. The responses whose ErrorData is Retryable are retried
+	// as well, not before its RetryAfter.
+	Codes []Code
+
+	// Transport reports whether calls that failed without a response, such as
+	// on a write error, are retried. Calls failed by their context are never
+	// retried.
+	Transport bool
+
+	// OnRetry is called before each retry with the error of the previous
+	// attempt, if not nil.
+	OnRetry func(ctx context.Context, method string, retry int, err error)
+
+	// Clock measures the delays before the retries, SystemClock if nil.
+	Clock Clock
+}
+
+// ExponentialBackoff returns a Backoff function for a RetryPolicy doubling the
+// delay from base for each retry, up to limit.
+func ExponentialBackoff(base, limit time.Duration) func(retry int) time.Duration {
+	return func(retry int) time.Duration {
+		d := base
+		for i := 1; i < retry && d < limit; i++ {
+			d *= 2
+		}
+		if d > limit {
+			d = limit
+		}
+		return d
+	}
+}
+
+// Interceptor returns a CallInterceptor applying the policy, to be installed
+// with WithCallInterceptors.
+func (p RetryPolicy) Interceptor() CallInterceptor {
+	clock := p.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
+	idempotent := make(map[string]bool, len(p.Methods))
+	for _, method := range p.Methods {
+		idempotent[method] = true
+	}
+	codes := make(map[Code]bool, len(p.Codes))
+	for _, code := range p.Codes {
+		codes[code] = true
+	}
+
+	retryable := func(err error) bool {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return false
+		}
+		var werr *Error
+		if errors.As(err, &werr) {
+			return codes[werr.Code] || werr.Retryable()
+		}
+		return p.Transport
+	}
+
+	return func(ctx context.Context, method string, params, result interface{}, invoker CallInvoker) (ID, error) {
+		id, err := invoker(ctx, method, params, result)
+		if !idempotent[method] {
+			return id, err
+		}
+
+		for retry := 1; retry < p.MaxAttempts && err != nil && retryable(err); retry++ {
+			var delay time.Duration
+			if p.Backoff != nil {
+				delay = p.Backoff(retry)
+			}
+			var werr *Error
+			if errors.As(err, &werr) && werr.RetryAfter() > delay {
+				delay = werr.RetryAfter()
+			}
+			if delay > 0 {
+				timer := clock.NewTimer(delay)
+				select {
+				case <-timer.C():
+				case <-ctx.Done():
+					timer.Stop()
+					return id, err
+				}
+			}
+			if p.OnRetry != nil {
+				p.OnRetry(ctx, method, retry, err)
+			}
+			id, err = invoker(ctx, method, params, result)
+		}
+		return id, err
+	}
+}
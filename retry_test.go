@@ -0,0 +1,116 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/jsonrpc2/fake"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var ids []jsonrpc2.ID
+	handler := func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		call := req.(*jsonrpc2.Call)
+		ids = append(ids, call.ID())
+		if len(ids)%3 != 0 {
+			return reply(ctx, nil, jsonrpc2.ErrServerOverloaded)
+		}
+		return reply(ctx, "done", nil)
+	}
+
+	var retries []int
+	clock := fake.NewClock(time.Now())
+	policy := jsonrpc2.RetryPolicy{
+		Methods:     []string{"idempotent"},
+		MaxAttempts: 3,
+		Backoff:     jsonrpc2.ExponentialBackoff(time.Millisecond, 10*time.Millisecond),
+		Codes:       []jsonrpc2.Code{jsonrpc2.ServerOverloaded},
+		OnRetry: func(ctx context.Context, method string, retry int, err error) {
+			retries = append(retries, retry)
+		},
+		Clock: clock,
+	}
+
+	a, b := pipeConns(t, jsonrpc2.WithCallInterceptors(policy.Interceptor()))
+	a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+	b.Go(ctx, handler)
+
+	var got string
+	done := make(chan error, 1)
+	go func() {
+		_, err := a.Call(ctx, "idempotent", nil, &got)
+		done <- err
+	}()
+	for _, backoff := range []time.Duration{time.Millisecond, 2 * time.Millisecond} {
+		clock.WaitTimers(1)
+		clock.Advance(backoff)
+	}
+	if err := <-done; err != nil || got != "done" {
+		t.Fatalf("got %q, %v, want done after retries", got, err)
+	}
+	if len(ids) != 3 || ids[0] == ids[1] || ids[1] == ids[2] {
+		t.Errorf("got attempts %v, want 3 with distinct ids", ids)
+	}
+	if len(retries) != 2 || retries[0] != 1 || retries[1] != 2 {
+		t.Errorf("got retries %v, want [1 2]", retries)
+	}
+
+	var werr *jsonrpc2.Error
+	if _, err := a.Call(ctx, "other", nil, &got); !errors.As(err, &werr) || werr.Code != jsonrpc2.ServerOverloaded {
+		t.Errorf("got %v for a call to another method, want %v", err, jsonrpc2.ErrServerOverloaded)
+	}
+	if len(ids) != 4 {
+		t.Errorf("got %d attempts, want the call to another method not retried", len(ids))
+	}
+}
+
+func TestRetryPolicyErrorData(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	attempts := 0
+	handler := func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		attempts++
+		if attempts == 1 {
+			werr, _ := jsonrpc2.NewError(jsonrpc2.InternalError, "rebuilding").WithData(jsonrpc2.ErrorData{
+				Retryable:  true,
+				RetryAfter: 20 * time.Millisecond,
+			})
+			return reply(ctx, nil, werr)
+		}
+		return reply(ctx, "done", nil)
+	}
+
+	clock := fake.NewClock(time.Now())
+	policy := jsonrpc2.RetryPolicy{Methods: []string{"idempotent"}, MaxAttempts: 2, Clock: clock}
+	a, b := pipeConns(t, jsonrpc2.WithCallInterceptors(policy.Interceptor()))
+	a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+	b.Go(ctx, handler)
+
+	var got string
+	done := make(chan error, 1)
+	go func() {
+		_, err := a.Call(ctx, "idempotent", nil, &got)
+		done <- err
+	}()
+	clock.WaitTimers(1)
+	clock.Advance(19 * time.Millisecond)
+	select {
+	case err := <-done:
+		t.Fatalf("got %v before the 20ms of the error data", err)
+	default:
+	}
+	clock.Advance(time.Millisecond)
+	if err := <-done; err != nil || got != "done" {
+		t.Fatalf("got %q, %v, want done after a retry", got, err)
+	}
+}
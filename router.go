@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sync"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// ErrNoBackend is returned by a StickyRouter that has no backend to route a
+// request to.
+var ErrNoBackend = errors.New("no backend to route to")
+
+// StickyRouter forwards requests to a set of backend connections, always
+// routing the requests of a key to the same backend, as in a proxy fronting
+// several servers.
+//
+// Keys are assigned to backends with rendezvous hashing, so that only the keys
+// of a backend that leaves move to other backends, and a backend that joins
+// only takes keys from the others.
+type StickyRouter struct {
+	key      func(Request) string
+	onChange func(name string, joined bool) // may be nil
+
+	mu       sync.Mutex
+	backends map[string]Conn
+}
+
+// NewStickyRouter returns a StickyRouter routing requests by the key returned
+// by key, such as the URI of the document in their params.
+//
+// onChange is called after a backend joins or leaves, so that state bound to
+// the keys that moved can be rebalanced. It may be called concurrently, and
+// may be nil.
+func NewStickyRouter(key func(Request) string, onChange func(name string, joined bool)) *StickyRouter {
+	return &StickyRouter{
+		key:      key,
+		onChange: onChange,
+		backends: make(map[string]Conn),
+	}
+}
+
+// Add adds the backend c, identified by name, until it is closed. The name
+// must be stable across restarts of the backend for keys to stay with it.
+func (r *StickyRouter) Add(name string, c Conn) {
+	r.mu.Lock()
+	r.backends[name] = c
+	r.mu.Unlock()
+
+	OnClose(c, func() {
+		r.mu.Lock()
+		current := r.backends[name] == c
+		r.mu.Unlock()
+		if current {
+			r.Remove(name)
+		}
+	})
+	if r.onChange != nil {
+		r.onChange(name, true)
+	}
+}
+
+// Remove removes the backend identified by name.
+func (r *StickyRouter) Remove(name string) {
+	r.mu.Lock()
+	_, ok := r.backends[name]
+	delete(r.backends, name)
+	r.mu.Unlock()
+
+	if ok && r.onChange != nil {
+		r.onChange(name, false)
+	}
+}
+
+// Pick returns the name and connection of the backend of key, or nil if
+// there is no backend.
+func (r *StickyRouter) Pick(key string) (string, Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var (
+		best     string
+		bestConn Conn
+		max      uint64
+	)
+	for name, c := range r.backends {
+		h := fnv.New64a()
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+		if score := h.Sum64(); bestConn == nil || score > max || (score == max && name < best) {
+			best, bestConn, max = name, c, score
+		}
+	}
+	return best, bestConn
+}
+
+// Handler returns a handler that forwards every request to the backend of its
+// key, and replies to calls with the result or error of the backend.
+//
+// Requests are replied to with ErrNoBackend if there is no backend.
+func (r *StickyRouter) Handler() (h Handler) {
+	h = Handler(func(ctx context.Context, reply Replier, req Request) error {
+		_, backend := r.Pick(r.key(req))
+		if backend == nil {
+			return reply(ctx, nil, ErrNoBackend)
+		}
+
+		if _, ok := req.(*Call); !ok {
+			return reply(ctx, nil, backend.Notify(ctx, req.Method(), req.Params()))
+		}
+		var result json.RawMessage
+		if _, err := backend.Call(ctx, req.Method(), req.Params(), &result); err != nil {
+			return reply(ctx, nil, err)
+		}
+		return reply(ctx, result, nil)
+	})
+
+	return h
+}
@@ -0,0 +1,99 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+func TestStickyRouter(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var (
+		mu      sync.Mutex
+		changes []string
+	)
+	router := jsonrpc2.NewStickyRouter(func(req jsonrpc2.Request) string {
+		var uri string
+		_ = json.Unmarshal(req.Params(), &uri)
+		return uri
+	}, func(name string, joined bool) {
+		mu.Lock()
+		changes = append(changes, name+" "+strconv.FormatBool(joined))
+		mu.Unlock()
+	})
+
+	names := []string{"a", "b", "c"}
+	for _, name := range names {
+		name := name
+		proxy, backend := pipeConns(t)
+		proxy.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+		backend.Go(ctx, func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+			return reply(ctx, name, nil)
+		})
+		router.Add(name, proxy)
+	}
+
+	before := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		key := "file:///" + strconv.Itoa(i)
+		before[key], _ = router.Pick(key)
+	}
+
+	_, c := router.Pick("file:///0")
+	call, err := jsonrpc2.NewCall(jsonrpc2.NewNumberID(1), "hover", "file:///0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	h := router.Handler()
+	if err := h(ctx, func(ctx context.Context, result interface{}, err error) error {
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := json.Marshal(result)
+		return json.Unmarshal(data, &got)
+	}, call); err != nil {
+		t.Fatal(err)
+	}
+	if got != before["file:///0"] {
+		t.Errorf("request routed to %s, want %s", got, before["file:///0"])
+	}
+
+	removed := before["file:///0"]
+	c.Close()
+	<-c.Done()
+	for key, name := range before {
+		moved, _ := router.Pick(key)
+		if name != removed && moved != name {
+			t.Errorf("key %s moved from %s to %s", key, name, moved)
+		}
+		if moved == removed {
+			t.Errorf("key %s still routed to the removed backend", key)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"a true", "b true", "c true", removed + " false"}
+	if len(changes) != len(want) {
+		t.Fatalf("got changes %v, want %v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("got changes %v, want %v", changes, want)
+		}
+	}
+}
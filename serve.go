@@ -5,7 +5,9 @@ package jsonrpc2
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"time"
@@ -43,11 +45,130 @@ func HandlerServer(h Handler) StreamServer {
 	})
 }
 
+// TCPOption tunes a TCP connection.
+type TCPOption func(*net.TCPConn) error
+
+// WithNoDelay returns a TCPOption that controls whether Nagle's algorithm is
+// disabled, as with (*net.TCPConn).SetNoDelay.
+//
+// Go disables it by default; delaying writes adds visible latency to the small
+// messages of a language server.
+func WithNoDelay(noDelay bool) TCPOption {
+	return func(c *net.TCPConn) error {
+		return c.SetNoDelay(noDelay)
+	}
+}
+
+// WithKeepAlive returns a TCPOption that enables keep-alive probes sent every
+// period. A zero or negative period disables them.
+func WithKeepAlive(period time.Duration) TCPOption {
+	return func(c *net.TCPConn) error {
+		if period <= 0 {
+			return c.SetKeepAlive(false)
+		}
+		if err := c.SetKeepAlive(true); err != nil {
+			return err
+		}
+		return c.SetKeepAlivePeriod(period)
+	}
+}
+
+// WithBufferSizes returns a TCPOption that sets the size of the operating
+// system receive and send buffers. A zero size keeps the system default.
+func WithBufferSizes(read, write int) TCPOption {
+	return func(c *net.TCPConn) error {
+		if read > 0 {
+			if err := c.SetReadBuffer(read); err != nil {
+				return err
+			}
+		}
+		if write > 0 {
+			return c.SetWriteBuffer(write)
+		}
+		return nil
+	}
+}
+
+// TuneConn applies opts to nc if it is a TCP connection, and does nothing
+// otherwise.
+//
+// It is meant to be used on connections dialed by clients, Serve applies the
+// options given WithTCPOptions itself.
+func TuneConn(nc net.Conn, opts ...TCPOption) error {
+	tc, ok := nc.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	for _, opt := range opts {
+		if err := opt(tc); err != nil {
+			return fmt.Errorf("tune %s connection: %w", tc.RemoteAddr(), err)
+		}
+	}
+	return nil
+}
+
+// ServeOption configures Serve and ListenAndServe.
+type ServeOption func(*serveConfig)
+
+// serveConfig holds the configuration of Serve.
+type serveConfig struct {
+	tcpOptions  []TCPOption
+	clock       Clock
+	group       *Group
+	onConnError ConnErrorHandler // may be nil
+}
+
+// WithTCPOptions returns a ServeOption that applies opts to every accepted TCP
+// connection before it is served.
+//
+// A connection that cannot be tuned is closed without being served.
+func WithTCPOptions(opts ...TCPOption) ServeOption {
+	return func(cfg *serveConfig) {
+		cfg.tcpOptions = append(cfg.tcpOptions, opts...)
+	}
+}
+
+// WithClock returns a ServeOption that measures the idle timeout with clock
+// instead of SystemClock.
+func WithClock(clock Clock) ServeOption {
+	return func(cfg *serveConfig) {
+		cfg.clock = clock
+	}
+}
+
+// WithGroup returns a ServeOption that adds every served connection to group,
+// so that notifications can be broadcast to all the clients.
+func WithGroup(group *Group) ServeOption {
+	return func(cfg *serveConfig) {
+		cfg.group = group
+	}
+}
+
+// ConnErrorHandler is called with the error an accepted connection failed
+// with, while it was tuned or served, once the connection is closed. It
+// returns whether the error is fatal to the server.
+type ConnErrorHandler func(nc net.Conn, err error) (fatal bool)
+
+// WithConnErrorHandler returns a ServeOption that reports the errors of the
+// accepted connections to handler.
+//
+// Serve returns the errors handler reports fatal, and keeps serving the other
+// connections otherwise. The errors of the connections closed by their peer or
+// by the server are not reported. Without a handler, the connection errors
+// are dropped.
+func WithConnErrorHandler(handler ConnErrorHandler) ServeOption {
+	return func(cfg *serveConfig) {
+		cfg.onConnError = handler
+	}
+}
+
 // ListenAndServe starts an jsonrpc2 server on the given address.
 //
 // If idleTimeout is non-zero, ListenAndServe exits after there are no clients for
 // this duration, otherwise it exits only on error.
-func ListenAndServe(ctx context.Context, network, addr string, server StreamServer, idleTimeout time.Duration) error {
+func ListenAndServe(
+	ctx context.Context, network, addr string, server StreamServer, idleTimeout time.Duration, opts ...ServeOption,
+) error {
 	ln, err := net.Listen(network, addr)
 	if err != nil {
 		return fmt.Errorf("failed to listen %s:%s: %w", network, addr, err)
@@ -58,26 +179,39 @@ func ListenAndServe(ctx context.Context, network, addr string, server StreamServ
 		defer os.Remove(addr)
 	}
 
-	return Serve(ctx, ln, server, idleTimeout)
+	return Serve(ctx, ln, server, idleTimeout, opts...)
 }
 
 // Serve accepts incoming connections from the network, and handles them using
 // the provided server. If idleTimeout is non-zero, ListenAndServe exits after
 // there are no clients for this duration, otherwise it exits only on error.
-func Serve(ctx context.Context, ln net.Listener, server StreamServer, idleTimeout time.Duration) error {
+func Serve(
+	ctx context.Context, ln net.Listener, server StreamServer, idleTimeout time.Duration, opts ...ServeOption,
+) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	cfg := serveConfig{
+		clock: SystemClock,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
 	// Max duration: ~290 years; surely that's long enough.
 	const forever = 1<<63 - 1
 	if idleTimeout <= 0 {
 		idleTimeout = forever
 	}
-	connTimer := time.NewTimer(idleTimeout)
+	connTimer := cfg.clock.NewTimer(idleTimeout)
 
+	type closedConn struct {
+		nc  net.Conn
+		err error
+	}
 	newConns := make(chan net.Conn)
 	doneListening := make(chan error)
-	closedConns := make(chan error)
+	closedConns := make(chan closedConn)
 
 	go func() {
 		for {
@@ -100,26 +234,37 @@ func Serve(ctx context.Context, ln net.Listener, server StreamServer, idleTimeou
 		case netConn := <-newConns:
 			activeConns++
 			connTimer.Stop()
+			if err := TuneConn(netConn, cfg.tcpOptions...); err != nil {
+				netConn.Close()
+				go func() { closedConns <- closedConn{nc: netConn, err: err} }()
+				continue
+			}
 			stream := NewStream(netConn)
 			go func() {
 				conn := NewConn(stream)
-				closedConns <- server.ServeStream(ctx, conn)
+				if cfg.group != nil {
+					cfg.group.Add(conn)
+				}
+				err := server.ServeStream(ctx, conn)
 				stream.Close()
+				closedConns <- closedConn{nc: netConn, err: err}
 			}()
 
 		case err := <-doneListening:
 			return err
 
-		case <-closedConns:
-			// if !isClosingError(err) {
-			// }
-
+		case closed := <-closedConns:
 			activeConns--
 			if activeConns == 0 {
 				connTimer.Reset(idleTimeout)
 			}
 
-		case <-connTimer.C:
+			if closed.err != nil && cfg.onConnError != nil && !isClosingError(closed.err) &&
+				cfg.onConnError(closed.nc, closed.err) {
+				return closed.err
+			}
+
+		case <-connTimer.C():
 			return ErrIdleTimeout
 
 		case <-ctx.Done():
@@ -127,3 +272,48 @@ func Serve(ctx context.Context, ln net.Listener, server StreamServer, idleTimeou
 		}
 	}
 }
+
+// isClosingError reports whether err is the error of a connection closed by
+// its peer or by the server.
+func isClosingError(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) || errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, context.Canceled)
+}
+
+// ServeStdio serves a single client over the standard input and output with
+// handler, as most language servers do, framing the messages as with
+// NewStream.
+//
+// It returns once the client closed the standard input, with a nil error, or
+// once ctx is done or the Conn failed, with the error of the Conn.
+func ServeStdio(ctx context.Context, handler Handler, opts ...ConnOption) error {
+	conn := NewConn(NewStream(&stdio{in: os.Stdin, out: os.Stdout}), opts...)
+	conn.Go(ctx, handler)
+	<-conn.Done()
+
+	if err := conn.Err(); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// stdio is an io.ReadWriteCloser over the standard input and output.
+type stdio struct {
+	in  *os.File
+	out *os.File
+}
+
+// compile time check whether the stdio implements a io.ReadWriteCloser interface.
+var _ io.ReadWriteCloser = (*stdio)(nil)
+
+// Read implements io.Reader.
+func (s *stdio) Read(p []byte) (int, error) { return s.in.Read(p) }
+
+// Write implements io.Writer.
+func (s *stdio) Write(p []byte) (int, error) { return s.out.Write(p) }
+
+// Close implements io.Closer.
+//
+// Only the standard input is closed, to stop reading, so that the program can
+// keep writing to the standard output.
+func (s *stdio) Close() error { return s.in.Close() }
@@ -7,14 +7,18 @@ import (
 	"context"
 	"errors"
 	"net"
-	"sync"
+	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/jsonrpc2/fake"
 )
 
 func TestIdleTimeout(t *testing.T) {
+	t.Parallel()
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -32,15 +36,17 @@ func TestIdleTimeout(t *testing.T) {
 		return conn
 	}
 
-	server := jsonrpc2.HandlerServer(jsonrpc2.MethodNotFoundHandler)
-	var (
-		runErr error
-		wg     sync.WaitGroup
-	)
-	wg.Add(1)
+	const idle = 100 * time.Millisecond
+	clock := fake.NewClock(time.Now())
+	handler := jsonrpc2.HandlerServer(jsonrpc2.MethodNotFoundHandler)
+	served := make(chan struct{}, 3)
+	server := jsonrpc2.ServerFunc(func(ctx context.Context, conn jsonrpc2.Conn) error {
+		defer func() { served <- struct{}{} }()
+		return handler.ServeStream(ctx, conn)
+	})
+	done := make(chan error, 1)
 	go func() {
-		defer wg.Done()
-		runErr = jsonrpc2.Serve(ctx, ln, server, 100*time.Millisecond)
+		done <- jsonrpc2.Serve(ctx, ln, server, idle, jsonrpc2.WithClock(clock))
 	}()
 
 	// Exercise some connection/disconnection patterns, and then assert that when
@@ -52,9 +58,217 @@ func TestIdleTimeout(t *testing.T) {
 	conn3 := connect()
 	conn3.Close()
 
-	wg.Wait()
+	// once every connection was served, the only timer that can be armed is the
+	// one of the last connection closed
+	for i := 0; i < 3; i++ {
+		<-served
+	}
+	clock.WaitTimers(1)
+	clock.Advance(idle)
+
+	if err := <-done; !errors.Is(err, jsonrpc2.ErrIdleTimeout) {
+		t.Errorf("run() returned error %v, want %v", err, jsonrpc2.ErrIdleTimeout)
+	}
+}
+
+func TestServeTCPOptions(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	tuned := make(chan *net.TCPConn, 1)
+	record := func(c *net.TCPConn) error {
+		tuned <- c
+		return nil
+	}
+	server := jsonrpc2.HandlerServer(jsonrpc2.MethodNotFoundHandler)
+	go jsonrpc2.Serve(ctx, ln, server, 0, jsonrpc2.WithTCPOptions(
+		jsonrpc2.WithNoDelay(true),
+		jsonrpc2.WithKeepAlive(time.Minute),
+		jsonrpc2.WithBufferSizes(1<<16, 1<<16),
+		record,
+	))
+
+	conn, err := net.DialTimeout("tcp", ln.Addr().String(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := jsonrpc2.TuneConn(conn, jsonrpc2.WithNoDelay(true)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-tuned:
+	case <-ctx.Done():
+		t.Fatal("accepted connection was not tuned")
+	}
+}
+
+func TestIdleTimeoutClock(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	const idle = time.Minute
+	clock := fake.NewClock(time.Now())
+	server := jsonrpc2.HandlerServer(jsonrpc2.MethodNotFoundHandler)
+	done := make(chan error, 1)
+	go func() {
+		done <- jsonrpc2.Serve(ctx, ln, server, idle, jsonrpc2.WithClock(clock))
+	}()
+
+	nc, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := jsonrpc2.NewConn(jsonrpc2.NewStream(nc))
+	conn.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+	// the reply proves the connection is being served
+	var werr *jsonrpc2.Error
+	if _, err := conn.Call(ctx, "ping", nil, nil); !errors.As(err, &werr) || werr.Code != jsonrpc2.MethodNotFound {
+		t.Fatalf("got %v, want %v", err, jsonrpc2.ErrMethodNotFound)
+	}
 
-	if !errors.Is(runErr, jsonrpc2.ErrIdleTimeout) {
-		t.Errorf("run() returned error %v, want %v", runErr, jsonrpc2.ErrIdleTimeout)
+	clock.Advance(2 * idle)
+	select {
+	case err := <-done:
+		t.Fatalf("Serve returned %v with an active connection", err)
+	default:
+	}
+
+	conn.Close()
+	clock.WaitTimers(1)
+	clock.Advance(idle - time.Second)
+	select {
+	case err := <-done:
+		t.Fatalf("Serve returned %v before the idle timeout", err)
+	default:
+	}
+
+	clock.Advance(time.Second)
+	if err := <-done; !errors.Is(err, jsonrpc2.ErrIdleTimeout) {
+		t.Errorf("Serve returned %v, want %v", err, jsonrpc2.ErrIdleTimeout)
+	}
+}
+
+func TestServeStdio(t *testing.T) {
+	ctx := context.Background()
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdoutR.Close()
+	defer stdoutW.Close()
+
+	stdin, stdout := os.Stdin, os.Stdout
+	os.Stdin, os.Stdout = stdinR, stdoutW
+	defer func() { os.Stdin, os.Stdout = stdin, stdout }()
+
+	served := make(chan error, 1)
+	go func() {
+		served <- jsonrpc2.ServeStdio(ctx, func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+			return reply(ctx, req.Method(), nil)
+		})
+	}()
+
+	client := jsonrpc2.NewConn(jsonrpc2.NewStream(rwc{stdoutR, stdinW}))
+	client.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+	var got string
+	if _, err := client.Call(ctx, "initialize", nil, &got); err != nil || got != "initialize" {
+		t.Errorf("client.Call(...): got %q, %v, want initialize", got, err)
+	}
+
+	// the editor disconnects
+	stdinW.Close()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("jsonrpc2.ServeStdio(...): got %v once the client disconnected, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("jsonrpc2.ServeStdio(...) did not return once the client disconnected")
+	}
+	stdoutW.Close()
+	<-client.Done()
+}
+
+func TestServeConnErrorHandler(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	errTune := errors.New("tune failed")
+	failing := func(*net.TCPConn) error { return errTune }
+	var count int32
+	reported := make(chan error, 2)
+	onConnError := func(nc net.Conn, err error) bool {
+		reported <- err
+		// the second error is fatal
+		return atomic.AddInt32(&count, 1) == 2
+	}
+	served := make(chan error, 1)
+	go func() {
+		server := jsonrpc2.HandlerServer(jsonrpc2.MethodNotFoundHandler)
+		served <- jsonrpc2.Serve(ctx, ln, server, 0,
+			jsonrpc2.WithTCPOptions(failing), jsonrpc2.WithConnErrorHandler(onConnError))
+	}()
+
+	for i := 0; i < 2; i++ {
+		conn, err := net.DialTimeout("tcp", ln.Addr().String(), 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		select {
+		case err := <-reported:
+			if !errors.Is(err, errTune) {
+				t.Errorf("got connection error %v, want %v", err, errTune)
+			}
+		case <-ctx.Done():
+			t.Fatal("the connection error was not reported")
+		}
+		if i == 0 {
+			select {
+			case err := <-served:
+				t.Fatalf("jsonrpc2.Serve(...) returned %v on a non-fatal connection error", err)
+			default:
+			}
+		}
+	}
+
+	select {
+	case err := <-served:
+		if !errors.Is(err, errTune) {
+			t.Errorf("jsonrpc2.Serve(...): got %v, want the fatal %v", err, errTune)
+		}
+	case <-ctx.Done():
+		t.Fatal("jsonrpc2.Serve(...) did not return on a fatal connection error")
 	}
 }
@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Shutdown coordinates the two-phase shutdown of a Conn, as with the
+// "shutdown" call and "exit" notification of LSP.
+//
+// Once the shutdown call is received, the Conn is draining: the later calls
+// other than exit are rejected and the later notifications other than exit
+// are dropped. The exit request closes the Conn.
+type Shutdown struct {
+	conn           Conn
+	shutdownMethod string
+	exitMethod     string
+
+	mu        sync.Mutex
+	requested bool
+	exited    bool
+}
+
+// NewShutdown returns a Shutdown of conn, with the shutdown and exit method
+// names, "shutdown" and "exit" if empty.
+func NewShutdown(conn Conn, shutdownMethod, exitMethod string) *Shutdown {
+	if shutdownMethod == "" {
+		shutdownMethod = "shutdown"
+	}
+	if exitMethod == "" {
+		exitMethod = "exit"
+	}
+	return &Shutdown{
+		conn:           conn,
+		shutdownMethod: shutdownMethod,
+		exitMethod:     exitMethod,
+	}
+}
+
+// Requested reports whether the shutdown call was received.
+func (s *Shutdown) Requested() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requested
+}
+
+// Clean reports whether the exit request was received after the shutdown
+// call, as LSP requires for the server to exit with a zero status.
+func (s *Shutdown) Clean() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requested && s.exited
+}
+
+// Handler returns a handler that passes the requests to handler until the
+// shutdown call is received, and then only the exit request.
+//
+// The shutdown call is passed to handler, to release the resources of the
+// server and reply. The calls received after it are replied to with an error
+// wrapping ErrInvalidRequest. The exit request is passed to handler, and the
+// Conn is closed once handler returns.
+func (s *Shutdown) Handler(handler Handler) (h Handler) {
+	h = Handler(func(ctx context.Context, reply Replier, req Request) error {
+		switch req.Method() {
+		case s.shutdownMethod:
+			s.mu.Lock()
+			s.requested = true
+			s.mu.Unlock()
+			return handler(ctx, reply, req)
+
+		case s.exitMethod:
+			s.mu.Lock()
+			s.exited = true
+			s.mu.Unlock()
+			err := handler(ctx, reply, req)
+			s.conn.Close()
+			return err
+		}
+
+		if !s.Requested() {
+			return handler(ctx, reply, req)
+		}
+		if _, isCall := req.(*Call); isCall {
+			return reply(ctx, nil, fmt.Errorf("%q after %q: %w", req.Method(), s.shutdownMethod, ErrInvalidRequest))
+		}
+		// notifications are dropped once shutting down
+		return nil
+	})
+
+	return h
+}
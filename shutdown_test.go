@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+func TestShutdown(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	handled := make(chan string, 8)
+	handler := func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		handled <- req.Method()
+		return reply(ctx, nil, nil)
+	}
+
+	a, b := pipeConns(t)
+	shutdown := jsonrpc2.NewShutdown(b, "", "")
+	a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+	b.Go(ctx, shutdown.Handler(handler))
+
+	if _, err := a.Call(ctx, "hover", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Call(ctx, "shutdown", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !shutdown.Requested() {
+		t.Error("shutdown.Requested(): got false after the shutdown call")
+	}
+
+	var werr *jsonrpc2.Error
+	if _, err := a.Call(ctx, "hover", nil, nil); !errors.As(err, &werr) || werr.Code != jsonrpc2.InvalidRequest {
+		t.Errorf("got %v for a call after shutdown, want %v", err, jsonrpc2.ErrInvalidRequest)
+	}
+	if err := a.Notify(ctx, "didChange", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Notify(ctx, "exit", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-b.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the connection was not closed on exit")
+	}
+	if !shutdown.Clean() {
+		t.Error("shutdown.Clean(): got false after shutdown and exit")
+	}
+
+	close(handled)
+	var got []string
+	for method := range handled {
+		got = append(got, method)
+	}
+	if len(got) != 3 || got[0] != "hover" || got[1] != "shutdown" || got[2] != "exit" {
+		t.Errorf("got methods handled %v, want [hover shutdown exit]", got)
+	}
+}
@@ -0,0 +1,96 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// HdrContentSignature is the header name of the base64 encoded signature of
+// the content part. It is only sent and checked by streams configured
+// WithSignature.
+const HdrContentSignature = "Content-Signature"
+
+// Signer signs the bodies of the messages written to a stream, and verifies
+// the signatures of the messages read from it.
+type Signer interface {
+	// Sign returns the signature of body, or nil to send body unsigned.
+	Sign(body []byte) []byte
+
+	// Verify reports whether sig is a valid signature of body.
+	Verify(body, sig []byte) bool
+}
+
+// SignatureError is the error of reading a message with a missing or invalid
+// signature.
+//
+// It wraps ErrParse, since the stream is still in sync with the message
+// boundaries after such a message.
+type SignatureError struct {
+	// Missing reports whether the message carried no signature at all.
+	Missing bool
+}
+
+// compile time check whether the SignatureError implements error interface.
+var _ error = (*SignatureError)(nil)
+
+// Error implements error.Error.
+func (e *SignatureError) Error() string {
+	if e.Missing {
+		return "missing " + HdrContentSignature
+	}
+	return "invalid " + HdrContentSignature
+}
+
+// Unwrap implements errors.Unwrap.
+func (e *SignatureError) Unwrap() error { return ErrParse }
+
+type hmacSigner struct {
+	key []byte
+}
+
+// HMACSigner returns a Signer of HMAC-SHA256 signatures with the shared key.
+func HMACSigner(key []byte) Signer {
+	return &hmacSigner{key: append([]byte(nil), key...)}
+}
+
+// Sign implements Signer.Sign.
+func (s *hmacSigner) Sign(body []byte) []byte {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// Verify implements Signer.Verify.
+func (s *hmacSigner) Verify(body, sig []byte) bool {
+	return hmac.Equal(s.Sign(body), sig)
+}
+
+type ed25519Signer struct {
+	key  ed25519.PrivateKey
+	peer ed25519.PublicKey
+}
+
+// Ed25519Signer returns a Signer of Ed25519 signatures, that signs with key
+// and verifies with the public key of the peer.
+//
+// A nil key makes a verify-only Signer, whose messages are sent unsigned.
+func Ed25519Signer(key ed25519.PrivateKey, peer ed25519.PublicKey) Signer {
+	return &ed25519Signer{key: key, peer: peer}
+}
+
+// Sign implements Signer.Sign.
+func (s *ed25519Signer) Sign(body []byte) []byte {
+	if s.key == nil {
+		return nil
+	}
+	return ed25519.Sign(s.key, body)
+}
+
+// Verify implements Signer.Verify.
+func (s *ed25519Signer) Verify(body, sig []byte) bool {
+	return len(sig) == ed25519.SignatureSize && ed25519.Verify(s.peer, body, sig)
+}
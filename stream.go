@@ -5,12 +5,19 @@ package jsonrpc2
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/md5"
+	"encoding/base64"
 	stdjson "encoding/json"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/segmentio/encoding/json"
 )
@@ -30,8 +37,19 @@ const (
 	//  https://tools.ietf.org/html/rfc7231#section-3.1.1.5
 	HdrContentType = "Content-Type"
 
+	// HdrContentMD5 is the HTTP header name of the base64 encoded MD5 digest of
+	// the content part. It is only sent and checked by streams configured
+	// WithChecksum.
+	//
+	// RFC 1864: The Content-MD5 Header Field:
+	//  https://tools.ietf.org/html/rfc1864
+	HdrContentMD5 = "Content-MD5"
+
 	// HeaderContentSeparator is the header and content part separator.
 	HdrContentSeparator = "\r\n\r\n"
+
+	// hdrLineSeparator is the separator of header lines.
+	hdrLineSeparator = "\r\n"
 )
 
 // Framer wraps a network connection up into a Stream.
@@ -40,6 +58,37 @@ const (
 // NewRawStream and NewStream are implementations of a Framer.
 type Framer func(conn io.ReadWriteCloser) Stream
 
+// NewReadWriteCloser returns an io.ReadWriteCloser reading from r and writing
+// to w, for the transports with a pipe for each direction, such as two FIFOs
+// or the standard output and input of a process, to be wrapped by a Framer.
+//
+// Close closes w, so that the peer reads the end of the stream, then r, and
+// returns the first error.
+func NewReadWriteCloser(r io.ReadCloser, w io.WriteCloser) io.ReadWriteCloser {
+	return &splitPipe{r: r, w: w}
+}
+
+// splitPipe is an io.ReadWriteCloser made of a pipe for each direction.
+type splitPipe struct {
+	r io.ReadCloser
+	w io.WriteCloser
+}
+
+// Read implements io.Reader.
+func (p *splitPipe) Read(b []byte) (int, error) { return p.r.Read(b) }
+
+// Write implements io.Writer.
+func (p *splitPipe) Write(b []byte) (int, error) { return p.w.Write(b) }
+
+// Close implements io.Closer.
+func (p *splitPipe) Close() error {
+	werr := p.w.Close()
+	if rerr := p.r.Close(); werr == nil {
+		return rerr
+	}
+	return werr
+}
+
 // Stream abstracts the transport mechanics from the JSON RPC protocol.
 //
 // A Conn reads and writes messages using the stream it was provided on
@@ -50,6 +99,10 @@ type Framer func(conn io.ReadWriteCloser) Stream
 // a single Conn in a safe manner.
 type Stream interface {
 	// Read gets the next message from the stream.
+	//
+	// An error wrapping ErrParse or ErrInvalidRequest reports a message that
+	// could not be decoded while the stream is still in sync with the message
+	// boundaries, so that the next message can be read.
 	Read(context.Context) (Message, int64, error)
 
 	// Write sends a message to the stream.
@@ -90,6 +143,9 @@ func (s *rawStream) Read(ctx context.Context) (Message, int64, error) {
 	}
 
 	msg, err := DecodeMessage(raw)
+	if err == nil {
+		setWireForm(msg, raw, nil)
+	}
 	return msg, int64(len(raw)), err
 }
 
@@ -101,7 +157,7 @@ func (s *rawStream) Write(ctx context.Context, msg Message) (int64, error) {
 	default:
 	}
 
-	data, err := json.Marshal(msg)
+	data, err := marshalMessage(msg)
 	if err != nil {
 		return 0, fmt.Errorf("marshaling message: %w", err)
 	}
@@ -122,6 +178,21 @@ func (s *rawStream) Close() error {
 type stream struct {
 	conn io.ReadWriteCloser
 	in   *bufio.Reader
+
+	readBufferSize int   // size of the read buffer, the bufio default if 0
+	streaming      bool  // decode message bodies directly from the connection
+	maxMessageSize int64 // maximum size of a message body read into a buffer
+	readLimit      int64 // maximum size of a message read, header included, if positive
+
+	checksum   bool                      // send and verify the Content-MD5 header
+	onMismatch func(ctx context.Context) // called on checksum mismatches, may be nil
+
+	signer Signer // signs and verifies message bodies, may be nil
+
+	compressed      bool  // decompress the gzip compressed bodies read
+	compressedLimit int64 // maximum size of a decompressed body, maxMessageSize if 0, unlimited if negative
+
+	permissiveIDs bool // keep the non-standard IDs read as raw JSON
 }
 
 // NewStream returns a Stream built on top of a io.ReadWriteCloser.
@@ -129,10 +200,131 @@ type stream struct {
 // The messages are sent with HTTP content length and MIME type headers.
 // This is the format used by LSP and others.
 func NewStream(conn io.ReadWriteCloser) Stream {
-	return &stream{
-		conn: conn,
-		in:   bufio.NewReader(conn),
+	return newStream(conn)
+}
+
+// DefaultMaxMessageSize is the maximum size of the message bodies a stream
+// reads into a buffer, unless configured with WithMaxMessageSize.
+const DefaultMaxMessageSize = 64 << 20
+
+// StreamOption configures the streams created by NewStreamFramer.
+type StreamOption func(*stream)
+
+// WithMaxMessageSize returns a StreamOption that sets the maximum size of the
+// message bodies read into a buffer, DefaultMaxMessageSize by default.
+//
+// The content length is checked before any buffer is allocated. A larger body
+// is decoded directly from the connection, as with WithStreamingDecode, unless
+// the stream needs it in full to verify a signature or decompress it, in which
+// case it is skipped and Read fails with an error wrapping ErrParse.
+func WithMaxMessageSize(size int64) StreamOption {
+	return func(s *stream) {
+		if size > 0 {
+			s.maxMessageSize = size
+		}
+	}
+}
+
+// WithReadBufferSize returns a StreamOption that sets the size of the buffer
+// the connection is read through, 4096 bytes by default.
+//
+// A buffer larger than the typical message lets most messages be read with a
+// single read from the connection.
+func WithReadBufferSize(size int) StreamOption {
+	return func(s *stream) {
+		s.readBufferSize = size
+	}
+}
+
+// WithStreamingDecode returns a StreamOption that decodes each message body
+// directly from the connection, bounded by its content length, instead of
+// reading it into a buffer first.
+func WithStreamingDecode() StreamOption {
+	return func(s *stream) {
+		s.streaming = true
+	}
+}
+
+// WithChecksum returns a StreamOption that sends the MD5 digest of every
+// message body in the Content-MD5 header, and verifies it for the messages
+// read that carry the header.
+//
+// A mismatch makes Read fail with an error wrapping ErrParse, and calls
+// onMismatch if it is not nil, so that a higher layer can request the message
+// to be sent again.
+func WithChecksum(onMismatch func(ctx context.Context)) StreamOption {
+	return func(s *stream) {
+		s.checksum = true
+		s.onMismatch = onMismatch
+	}
+}
+
+// WithSignature returns a StreamOption that signs every message body written
+// with signer into the Content-Signature header, and requires the messages
+// read to carry a valid one.
+//
+// The signature covers the exact bytes of the body, so that a message is
+// verified before it is decoded. A message with a missing or invalid
+// signature makes Read fail with a *SignatureError, and is never delivered.
+// Messages are always read in full before decoding with this option.
+func WithSignature(signer Signer) StreamOption {
+	return func(s *stream) {
+		s.signer = signer
+	}
+}
+
+// WithAllowCompressed returns a StreamOption that detects the message bodies
+// read that are compressed with gzip, from their magic number, and decompresses
+// them, for peers that compress their messages without negotiation.
+//
+// A body decompressing to more than limit bytes makes Read fail with an error
+// wrapping ErrParse. A limit of 0 applies the maximum message size, see
+// WithMaxMessageSize, and only a negative limit lets bodies decompress to any
+// size. Messages are always read in full before decoding with this option, and
+// a signature is verified on the compressed body.
+func WithAllowCompressed(limit int64) StreamOption {
+	return func(s *stream) {
+		s.compressed = true
+		s.compressedLimit = limit
+	}
+}
+
+// WithPermissiveIDs returns a StreamOption that accepts the messages read with
+// IDs that are neither int32 numbers nor strings, such as fractional or very
+// large numbers, instead of failing to decode them.
+//
+// Such IDs are kept as raw JSON and echoed back verbatim in the responses, and
+// a call with a null ID is read as a call with the NullID rather than a
+// notification.
+func WithPermissiveIDs() StreamOption {
+	return func(s *stream) {
+		s.permissiveIDs = true
+	}
+}
+
+// NewStreamFramer returns a Framer of streams like the ones created by
+// NewStream, configured by opts.
+func NewStreamFramer(opts ...StreamOption) Framer {
+	return func(conn io.ReadWriteCloser) Stream {
+		return newStream(conn, opts...)
+	}
+}
+
+// newStream returns a stream built on top of conn, configured by opts.
+func newStream(conn io.ReadWriteCloser, opts ...StreamOption) *stream {
+	s := &stream{
+		conn:           conn,
+		maxMessageSize: DefaultMaxMessageSize,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.readBufferSize > 0 {
+		s.in = bufio.NewReaderSize(conn, s.readBufferSize)
+	} else {
+		s.in = bufio.NewReader(conn)
+	}
+	return s
 }
 
 // Read implements Stream.Read.
@@ -145,6 +337,8 @@ func (s *stream) Read(ctx context.Context) (Message, int64, error) {
 
 	var total int64
 	var length int64
+	var digest, signature string
+	var header []string
 	// read the header, stop on the first empty line
 	for {
 		line, err := s.in.ReadString('\n')
@@ -165,14 +359,20 @@ func (s *stream) Read(ctx context.Context) (Message, int64, error) {
 		}
 
 		name, value := line[:colon], strings.TrimSpace(line[colon+1:])
-		switch name {
-		case HdrContentLength:
-			if length, err = strconv.ParseInt(value, 10, 32); err != nil {
+		header = append(header, name, value)
+		// header names are case-insensitive, as in HTTP
+		switch {
+		case strings.EqualFold(name, HdrContentLength):
+			if length, err = strconv.ParseInt(value, 10, 64); err != nil {
 				return nil, total, fmt.Errorf("failed parsing %s: %v: %w", HdrContentLength, value, err)
 			}
 			if length <= 0 {
 				return nil, total, fmt.Errorf("invalid %s: %v", HdrContentLength, length)
 			}
+		case strings.EqualFold(name, HdrContentMD5):
+			digest = value
+		case strings.EqualFold(name, HdrContentSignature):
+			signature = value
 		default:
 			// ignoring unknown headers
 		}
@@ -181,17 +381,165 @@ func (s *stream) Read(ctx context.Context) (Message, int64, error) {
 	if length == 0 {
 		return nil, total, fmt.Errorf("missing %s header", HdrContentLength)
 	}
+	if s.readLimit > 0 && total+length > s.readLimit {
+		if err := s.skip(length); err != nil {
+			return nil, total, err
+		}
+		return nil, total + length, limitError(total+length, s.readLimit)
+	}
 
-	data := make([]byte, length)
-	if _, err := io.ReadFull(s.in, data); err != nil {
-		return nil, total, fmt.Errorf("read full of data: %w", err)
+	var sum hash.Hash
+	body := io.Reader(s.in)
+	if s.checksum && digest != "" {
+		sum = md5.New() //nolint:gosec // used as a checksum only
+		body = io.TeeReader(body, sum)
 	}
 
+	var (
+		msg Message
+		err error
+	)
+	buffered := s.signer != nil || s.compressed
+	switch {
+	case (s.streaming || length > s.maxMessageSize) && !buffered:
+		if msg, err = s.decodeBody(body, length); err == nil {
+			setWireForm(msg, nil, header)
+		}
+	case length > s.maxMessageSize:
+		if err := s.skip(length); err != nil {
+			return nil, total, err
+		}
+		return nil, total + length, fmt.Errorf("%w: %s %d larger than %d bytes",
+			ErrParse, HdrContentLength, length, s.maxMessageSize)
+	default:
+		data := make([]byte, length)
+		if _, err := io.ReadFull(body, data); err != nil {
+			return nil, total, fmt.Errorf("read full of data: %w", err)
+		}
+		if s.signer != nil {
+			if err := s.verify(data, signature); err != nil {
+				return nil, total + length, err
+			}
+		}
+		if s.compressed && isGzip(data) {
+			if data, err = s.decompress(data); err != nil {
+				return nil, total + length, err
+			}
+		}
+		if msg, err = decodeBytes(data, s.permissiveIDs); err != nil {
+			err = parseError(err)
+		} else {
+			setWireForm(msg, data, header)
+		}
+	}
 	total += length
-	msg, err := DecodeMessage(data)
+
+	if sum != nil && base64.StdEncoding.EncodeToString(sum.Sum(nil)) != digest {
+		if s.onMismatch != nil {
+			s.onMismatch(ctx)
+		}
+		return nil, total, fmt.Errorf("%s mismatch: %w", HdrContentMD5, ErrParse)
+	}
+
 	return msg, total, err
 }
 
+// skip discards the next length bytes of the connection, the body of a message
+// that is not read, so that the stream stays in sync with the message
+// boundaries.
+func (s *stream) skip(length int64) error {
+	if _, err := io.CopyN(io.Discard, s.in, length); err != nil {
+		return fmt.Errorf("read full of data: %w", err)
+	}
+	return nil
+}
+
+// setReadLimit implements readLimiter.
+func (s *stream) setReadLimit(max int64) {
+	s.readLimit = max
+}
+
+// verify returns a *SignatureError if signature, as read from the header, is
+// not a valid signature of the message body data.
+func (s *stream) verify(data []byte, signature string) error {
+	if signature == "" {
+		return &SignatureError{Missing: true}
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || !s.signer.Verify(data, sig) {
+		return &SignatureError{}
+	}
+	return nil
+}
+
+// gzipMagic is the magic number gzip compressed data starts with.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// isGzip reports whether data is compressed with gzip.
+func isGzip(data []byte) bool {
+	return bytes.HasPrefix(data, gzipMagic)
+}
+
+// decompress returns the decompressed gzip data.
+func (s *stream) decompress(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: decompressing body: %v", ErrParse, err)
+	}
+	defer zr.Close()
+
+	limit := s.compressedLimit
+	if limit == 0 {
+		limit = s.maxMessageSize
+	}
+	r := io.Reader(zr)
+	if limit > 0 {
+		r = io.LimitReader(zr, limit+1)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: decompressing body: %v", ErrParse, err)
+	}
+	if limit > 0 && int64(len(out)) > limit {
+		return nil, fmt.Errorf("%w: decompressed body larger than %d bytes", ErrParse, limit)
+	}
+	return out, nil
+}
+
+// decodeBody decodes a message from the next length bytes of the connection.
+//
+// The whole body is consumed even if decoding fails, so that the stream stays
+// in sync with the message boundaries.
+func (s *stream) decodeBody(r io.Reader, length int64) (Message, error) {
+	body := &io.LimitedReader{R: r, N: length}
+	msg, err := decodeMessage(json.NewDecoder(body), s.permissiveIDs)
+
+	if _, cerr := io.Copy(io.Discard, body); cerr != nil {
+		return nil, fmt.Errorf("read full of data: %w", cerr)
+	}
+	if body.N > 0 {
+		// the connection ended before the end of the body
+		return nil, fmt.Errorf("read full of data: %w", io.ErrUnexpectedEOF)
+	}
+	if err != nil {
+		return nil, parseError(err)
+	}
+
+	return msg, nil
+}
+
+// parseError returns the error of decoding a message body that was read in
+// full, so that the stream is still in sync with the message boundaries.
+//
+// It wraps ErrParse, or ErrInvalidRequest if the body was valid JSON but not a
+// valid message.
+func parseError(err error) error {
+	if errors.Is(err, ErrInvalidRequest) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrParse, err)
+}
+
 // Write implements Stream.Write.
 func (s *stream) Write(ctx context.Context, msg Message) (int64, error) {
 	select {
@@ -200,27 +548,88 @@ func (s *stream) Write(ctx context.Context, msg Message) (int64, error) {
 	default:
 	}
 
-	data, err := json.Marshal(msg)
+	data, err := marshalMessage(msg)
 	if err != nil {
 		return 0, fmt.Errorf("marshaling message: %w", err)
 	}
 
-	n, err := fmt.Fprintf(s.conn, "%s: %v%s", HdrContentLength, len(data), HdrContentSeparator)
-	total := int64(n)
-	if err != nil {
-		return 0, fmt.Errorf("write data to conn: %w", err)
+	// write the header and the body at once, so that the message is sent in
+	// a single packet with TCP_NODELAY
+	buf := writePool.Get().(*[]byte)
+	*buf = append(s.appendHeader((*buf)[:0], msg, data), data...)
+	n, err := s.conn.Write(*buf)
+	if cap(*buf) <= maxPooledWrite {
+		writePool.Put(buf)
 	}
-
-	n, err = s.conn.Write(data)
-	total += int64(n)
 	if err != nil {
 		return 0, fmt.Errorf("write data to conn: %w", err)
 	}
 
-	return total, nil
+	return int64(n), nil
 }
 
 // Close implements Stream.Close.
 func (s *stream) Close() error {
 	return s.conn.Close()
 }
+
+// maxPooledWrite is the capacity above which write buffers are not reused, so
+// that a few large messages do not pin memory.
+const maxPooledWrite = 64 << 10
+
+// writePool holds the buffers messages are written from.
+var writePool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 1024)
+		return &buf
+	},
+}
+
+// appendHeader appends the header of the message body data to buf, with the
+// headers carried by msg.
+func (s *stream) appendHeader(buf []byte, msg Message, data []byte) []byte {
+	buf = append(buf, HdrContentLength...)
+	buf = append(buf, ": "...)
+	buf = strconv.AppendInt(buf, int64(len(data)), 10)
+
+	if s.checksum {
+		sum := md5.Sum(data) //nolint:gosec // used as a checksum only
+		buf = append(buf, hdrLineSeparator...)
+		buf = append(buf, HdrContentMD5...)
+		buf = append(buf, ": "...)
+		var digest [24]byte // base64 encoded length of an MD5 sum
+		base64.StdEncoding.Encode(digest[:], sum[:])
+		buf = append(buf, digest[:]...)
+	}
+
+	if s.signer != nil {
+		if sig := s.signer.Sign(data); sig != nil {
+			buf = append(buf, hdrLineSeparator...)
+			buf = append(buf, HdrContentSignature...)
+			buf = append(buf, ": "...)
+			buf = append(buf, base64.StdEncoding.EncodeToString(sig)...)
+		}
+	}
+
+	if carrier, ok := msg.(HeaderCarrier); ok {
+		for _, name := range carrier.HeaderNames() {
+			if computedHeader(name) {
+				continue
+			}
+			buf = append(buf, hdrLineSeparator...)
+			buf = append(buf, stripNewlines(name)...)
+			buf = append(buf, ": "...)
+			buf = append(buf, stripNewlines(carrier.Header(name))...)
+		}
+	}
+
+	return append(buf, HdrContentSeparator...)
+}
+
+// computedHeader reports whether the header name is one of the headers the
+// stream computes itself, compared case-insensitively.
+func computedHeader(name string) bool {
+	return strings.EqualFold(name, HdrContentLength) ||
+		strings.EqualFold(name, HdrContentMD5) ||
+		strings.EqualFold(name, HdrContentSignature)
+}
@@ -0,0 +1,529 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+// rwc is an io.ReadWriteCloser reading from r and writing to w.
+type rwc struct {
+	io.Reader
+	io.Writer
+}
+
+func (rwc) Close() error { return nil }
+
+func TestStreamingDecode(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	body1 := `{"jsonrpc":"2.0","id":1,"method":"one","params":[1, 2]}`
+	body2 := `{"jsonrpc":"2.0","method":"two"}   `
+	input := "Content-Length: " + strconv.Itoa(len(body1)) + "\r\n\r\n" + body1 +
+		"Content-Length: " + strconv.Itoa(len(body2)) + "\r\n\r\n" + body2
+
+	s := jsonrpc2.NewStreamFramer(jsonrpc2.WithStreamingDecode())(rwc{strings.NewReader(input), io.Discard})
+
+	msg, _, err := s.Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if call, ok := msg.(*jsonrpc2.Call); !ok || call.Method() != "one" || string(call.Params()) != "[1, 2]" {
+		t.Fatalf("got %#v, want call of one", msg)
+	}
+
+	// trailing whitespace in the body must be consumed with it
+	msg, n, err := s.Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notify, ok := msg.(*jsonrpc2.Notification); !ok || notify.Method() != "two" {
+		t.Fatalf("got %#v, want notification of two", msg)
+	}
+	if want := int64(len("Content-Length: 36\r\n\r\n") + len(body2)); n != want {
+		t.Errorf("got %d bytes read, want %d", n, want)
+	}
+
+	if _, _, err := s.Read(ctx); !errors.Is(err, io.EOF) {
+		t.Errorf("got %v at the end of the input, want EOF", err)
+	}
+}
+
+func TestStreamLargeContentLength(t *testing.T) {
+	t.Parallel()
+
+	// lengths beyond 32 bits are accepted, the body is then cut short
+	input := "Content-Length: 4294967296\r\n\r\n{}"
+	s := jsonrpc2.NewStreamFramer(jsonrpc2.WithStreamingDecode())(rwc{strings.NewReader(input), io.Discard})
+	_, _, err := s.Read(context.Background())
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestStreamMaxMessageSize(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	body := `{"jsonrpc":"2.0","method":"large"}`
+	next := `{"jsonrpc":"2.0","method":"next"}`
+	input := "Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body +
+		"Content-Length: " + strconv.Itoa(len(next)) + "\r\n\r\n" + next
+
+	tests := map[string]struct {
+		opts    []jsonrpc2.StreamOption
+		wantErr bool
+	}{
+		"buffered": {
+			opts: []jsonrpc2.StreamOption{jsonrpc2.WithMaxMessageSize(int64(len(body)))},
+		},
+		"decoded from the connection": {
+			opts: []jsonrpc2.StreamOption{jsonrpc2.WithMaxMessageSize(8)},
+		},
+		"skipped": {
+			opts:    []jsonrpc2.StreamOption{jsonrpc2.WithMaxMessageSize(int64(len(next))), jsonrpc2.WithAllowCompressed(0)},
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			s := jsonrpc2.NewStreamFramer(tt.opts...)(rwc{strings.NewReader(input), io.Discard})
+			msg, _, err := s.Read(ctx)
+			if tt.wantErr {
+				if !errors.Is(err, jsonrpc2.ErrParse) {
+					t.Errorf("got %v, want %v", err, jsonrpc2.ErrParse)
+				}
+			} else if notify, ok := msg.(*jsonrpc2.Notification); !ok || notify.Method() != "large" {
+				t.Errorf("got %#v, %v, want the large notification", msg, err)
+			}
+
+			// the stream is still in sync with the message boundaries
+			msg, _, err = s.Read(ctx)
+			if notify, ok := msg.(*jsonrpc2.Notification); !ok || notify.Method() != "next" {
+				t.Errorf("got %#v, %v, want the next notification", msg, err)
+			}
+		})
+	}
+
+	// a content length that cannot be allocated is never trusted
+	huge := "Content-Length: 9223372036854775807\r\n\r\n{}"
+	_, _, err := jsonrpc2.NewStream(rwc{strings.NewReader(huge), io.Discard}).Read(ctx)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestStreamChecksum(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	notify, err := jsonrpc2.NewNotification("check", []int{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	mismatches := 0
+	framer := jsonrpc2.NewStreamFramer(jsonrpc2.WithChecksum(func(context.Context) { mismatches++ }))
+	if _, err := framer(rwc{strings.NewReader(""), &buf}).Write(ctx, notify); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), jsonrpc2.HdrContentMD5+": ") {
+		t.Fatalf("no checksum header in %q", buf.String())
+	}
+
+	msg, _, err := framer(rwc{bytes.NewReader(buf.Bytes()), io.Discard}).Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := msg.(*jsonrpc2.Notification); !ok || got.Method() != "check" {
+		t.Fatalf("got %#v, want notification of check", msg)
+	}
+
+	corrupted := strings.Replace(buf.String(), "[1,2]", "[1,3]", 1)
+	if _, _, err := framer(rwc{strings.NewReader(corrupted), io.Discard}).Read(ctx); !errors.Is(err, jsonrpc2.ErrParse) {
+		t.Errorf("got %v for a corrupted body, want %v", err, jsonrpc2.ErrParse)
+	}
+	if mismatches != 1 {
+		t.Errorf("got %d mismatches reported, want 1", mismatches)
+	}
+}
+
+func TestStreamSignature(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	notify, err := jsonrpc2.NewNotification("sign", []int{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signers := map[string]struct {
+		sign, verify jsonrpc2.Signer
+	}{
+		"hmac": {
+			sign:   jsonrpc2.HMACSigner([]byte("secret")),
+			verify: jsonrpc2.HMACSigner([]byte("secret")),
+		},
+		"ed25519": {
+			sign:   jsonrpc2.Ed25519Signer(key, nil),
+			verify: jsonrpc2.Ed25519Signer(nil, pub),
+		},
+	}
+	for name, tt := range signers {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+			writer := jsonrpc2.NewStreamFramer(jsonrpc2.WithSignature(tt.sign))
+			if _, err := writer(rwc{strings.NewReader(""), &buf}).Write(ctx, notify); err != nil {
+				t.Fatal(err)
+			}
+
+			reader := jsonrpc2.NewStreamFramer(jsonrpc2.WithSignature(tt.verify))
+			msg, _, err := reader(rwc{bytes.NewReader(buf.Bytes()), io.Discard}).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, ok := msg.(*jsonrpc2.Notification); !ok || got.Method() != "sign" {
+				t.Fatalf("got %#v, want notification of sign", msg)
+			}
+
+			tampered := strings.Replace(buf.String(), "[1,2]", "[1,3]", 1)
+			_, _, err = reader(rwc{strings.NewReader(tampered), io.Discard}).Read(ctx)
+			var serr *jsonrpc2.SignatureError
+			if !errors.As(err, &serr) || serr.Missing || !errors.Is(err, jsonrpc2.ErrParse) {
+				t.Errorf("got %v for a tampered body, want an invalid signature error", err)
+			}
+
+			var unsigned bytes.Buffer
+			if _, err := jsonrpc2.NewStream(rwc{strings.NewReader(""), &unsigned}).Write(ctx, notify); err != nil {
+				t.Fatal(err)
+			}
+			_, _, err = reader(rwc{&unsigned, io.Discard}).Read(ctx)
+			if !errors.As(err, &serr) || !serr.Missing {
+				t.Errorf("got %v for an unsigned body, want a missing signature error", err)
+			}
+		})
+	}
+}
+
+func BenchmarkStreamWrite(b *testing.B) {
+	ctx := context.Background()
+	notify, err := jsonrpc2.NewNotification("textDocument/didChange", map[string]string{"text": strings.Repeat("x", 256)})
+	if err != nil {
+		b.Fatal(err)
+	}
+	s := jsonrpc2.NewStream(rwc{strings.NewReader(""), io.Discard})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.Write(ctx, notify); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// countingWriter counts the calls to Write.
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func TestStreamSingleWrite(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	notify, err := jsonrpc2.NewNotification("single", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var w countingWriter
+	n, err := jsonrpc2.NewStream(rwc{strings.NewReader(""), &w}).Write(ctx, notify)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.writes != 1 {
+		t.Errorf("got %d writes, want 1", w.writes)
+	}
+	if n != int64(w.Len()) {
+		t.Errorf("got %d bytes reported, want %d", n, w.Len())
+	}
+}
+
+func BenchmarkStreamRead(b *testing.B) {
+	ctx := context.Background()
+	notify, err := jsonrpc2.NewNotification("textDocument/didChange", map[string]string{"text": strings.Repeat("x", 16<<10)})
+	if err != nil {
+		b.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := jsonrpc2.NewStream(rwc{strings.NewReader(""), &buf}).Write(ctx, notify); err != nil {
+		b.Fatal(err)
+	}
+	input := bytes.Repeat(buf.Bytes(), 64)
+
+	for name, size := range map[string]int{"default": 0, "64KiB": 64 << 10} {
+		size := size
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(buf.Len()))
+			var s jsonrpc2.Stream
+			for i := 0; i < b.N; i++ {
+				if i%64 == 0 {
+					s = jsonrpc2.NewStreamFramer(jsonrpc2.WithReadBufferSize(size))(rwc{bytes.NewReader(input), io.Discard})
+				}
+				if _, _, err := s.Read(ctx); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestStreamWireForm(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	body := `{"jsonrpc":"2.0", "id":1, "method":"signed"}`
+	input := "Content-Length: " + strconv.Itoa(len(body)) + "\r\nX-Signature: abc\r\n\r\n" + body
+
+	msg, _, err := jsonrpc2.NewStream(rwc{strings.NewReader(input), io.Discard}).Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	call, ok := msg.(*jsonrpc2.Call)
+	if !ok {
+		t.Fatalf("got %#v, want a call", msg)
+	}
+	if got := string(call.Raw()); got != body {
+		t.Errorf("got raw %s, want %s", got, body)
+	}
+	if got := call.Header("X-Signature"); got != "abc" {
+		t.Errorf("got header %q, want %q", got, "abc")
+	}
+
+	built, err := jsonrpc2.NewCall(jsonrpc2.NewNumberID(1), "built", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if built.Raw() != nil || built.Header(jsonrpc2.HdrContentLength) != "" {
+		t.Error("got a wire form for a call that was not read")
+	}
+}
+
+func TestStreamHeaderPassthrough(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	body := `{"jsonrpc":"2.0","result":null,"id":1}`
+	input := "Content-Length: " + strconv.Itoa(len(body)) +
+		"\r\nContent-Type: application/vscode-jsonrpc; charset=utf-8\r\nX-Trace: 42\r\n\r\n" + body
+
+	msg, _, err := jsonrpc2.NewStream(rwc{strings.NewReader(input), io.Discard}).Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, ok := msg.(*jsonrpc2.Response)
+	if !ok {
+		t.Fatalf("got %#v, want a response", msg)
+	}
+	resp.SetHeader("X-Trace", "43")
+
+	var out bytes.Buffer
+	if _, err := jsonrpc2.NewStream(rwc{strings.NewReader(""), &out}).Write(ctx, resp); err != nil {
+		t.Fatal(err)
+	}
+	want := "Content-Length: " + strconv.Itoa(len(body)) +
+		"\r\nContent-Type: application/vscode-jsonrpc; charset=utf-8\r\nX-Trace: 43\r\n\r\n" + body
+	if got := out.String(); got != want {
+		t.Errorf("got %q written, want %q", got, want)
+	}
+}
+
+func TestStreamHeaderSanitized(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	body := `{"jsonrpc":"2.0","result":null,"id":1}`
+	input := "content-length: " + strconv.Itoa(len(body)) + "\r\nX-Trace: 4\r2\r\n\r\n" + body
+
+	msg, _, err := jsonrpc2.NewStream(rwc{strings.NewReader(input), io.Discard}).Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, ok := msg.(*jsonrpc2.Response)
+	if !ok {
+		t.Fatalf("got %#v, want a response", msg)
+	}
+	resp.SetHeader("X-Injected", "1\r\nContent-Length: 2\r\n\r\n{}")
+	resp.SetHeader("CONTENT-MD5", "forged")
+
+	var out bytes.Buffer
+	if _, err := jsonrpc2.NewStream(rwc{strings.NewReader(""), &out}).Write(ctx, resp); err != nil {
+		t.Fatal(err)
+	}
+	want := "Content-Length: " + strconv.Itoa(len(body)) +
+		"\r\nX-Trace: 42\r\nX-Injected: 1Content-Length: 2{}\r\n\r\n" + body
+	if got := out.String(); got != want {
+		t.Errorf("got %q written, want %q", got, want)
+	}
+}
+
+func TestStreamAllowCompressed(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	body := `{"jsonrpc":"2.0","method":"compressed","params":"` + strings.Repeat("z", 256) + `"}`
+	var zbody bytes.Buffer
+	zw := gzip.NewWriter(&zbody)
+	zw.Write([]byte(body))
+	zw.Close()
+	input := "Content-Length: " + strconv.Itoa(zbody.Len()) + "\r\n\r\n" + zbody.String()
+
+	tests := map[string]struct {
+		opts    []jsonrpc2.StreamOption
+		wantErr bool
+	}{
+		"allowed": {
+			opts: []jsonrpc2.StreamOption{jsonrpc2.WithAllowCompressed(0)},
+		},
+		"not allowed": {
+			wantErr: true,
+		},
+		"unlimited": {
+			opts: []jsonrpc2.StreamOption{jsonrpc2.WithMaxMessageSize(int64(zbody.Len())), jsonrpc2.WithAllowCompressed(-1)},
+		},
+		"too large": {
+			opts:    []jsonrpc2.StreamOption{jsonrpc2.WithAllowCompressed(int64(len(body) - 1))},
+			wantErr: true,
+		},
+		"larger than the maximum message size": {
+			opts:    []jsonrpc2.StreamOption{jsonrpc2.WithMaxMessageSize(int64(zbody.Len())), jsonrpc2.WithAllowCompressed(0)},
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			s := jsonrpc2.NewStreamFramer(tt.opts...)(rwc{strings.NewReader(input), io.Discard})
+			msg, n, err := s.Read(ctx)
+			if tt.wantErr {
+				if !errors.Is(err, jsonrpc2.ErrParse) {
+					t.Errorf("got %v, want %v", err, jsonrpc2.ErrParse)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if notify, ok := msg.(*jsonrpc2.Notification); !ok || notify.Method() != "compressed" || string(notify.Raw()) != body {
+				t.Errorf("got %#v, want the decompressed notification", msg)
+			}
+			if n != int64(len(input)) {
+				t.Errorf("got %d bytes read, want %d", n, len(input))
+			}
+		})
+	}
+}
+
+func TestStreamPermissiveIDs(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	for _, id := range []string{`1.5`, `12345678901234567890`, `null`} {
+		id := id
+		t.Run(id, func(t *testing.T) {
+			t.Parallel()
+
+			body := `{"jsonrpc":"2.0","method":"m","id":` + id + `}`
+			input := "Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+
+			msg, _, err := jsonrpc2.NewStream(rwc{strings.NewReader(input), io.Discard}).Read(ctx)
+			if _, ok := msg.(*jsonrpc2.Call); ok || (err == nil && id != `null`) {
+				t.Errorf("got %#v, %v in strict mode, want a decode error or a notification", msg, err)
+			}
+
+			for _, opts := range [][]jsonrpc2.StreamOption{
+				{jsonrpc2.WithPermissiveIDs()},
+				{jsonrpc2.WithPermissiveIDs(), jsonrpc2.WithStreamingDecode()},
+			} {
+				msg, _, err := jsonrpc2.NewStreamFramer(opts...)(rwc{strings.NewReader(input), io.Discard}).Read(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				call, ok := msg.(*jsonrpc2.Call)
+				if !ok {
+					t.Fatalf("got %#v, want a call", msg)
+				}
+				resp, _ := jsonrpc2.NewResponse(call.ID(), nil, nil)
+				var out bytes.Buffer
+				if _, err := jsonrpc2.NewStream(rwc{strings.NewReader(""), &out}).Write(ctx, resp); err != nil {
+					t.Fatal(err)
+				}
+				if want := `"id":` + id; !strings.Contains(out.String(), want) {
+					t.Errorf("got response %q, want the id echoed back as %s", out.String(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewReadWriteCloser(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	// a pipe for each direction, as with two FIFOs
+	upR, upW := io.Pipe()
+	downR, downW := io.Pipe()
+	a := jsonrpc2.NewConn(jsonrpc2.NewStream(jsonrpc2.NewReadWriteCloser(downR, upW)))
+	b := jsonrpc2.NewConn(jsonrpc2.NewStream(jsonrpc2.NewReadWriteCloser(upR, downW)))
+	a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+	b.Go(ctx, func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		return reply(ctx, req.Method(), nil)
+	})
+
+	var got string
+	if _, err := a.Call(ctx, "ping", nil, &got); err != nil || got != "ping" {
+		t.Errorf("a.Call(...): got %q, %v, want ping", got, err)
+	}
+
+	// closing one side ends the stream of the other
+	a.Close()
+	<-a.Done()
+	select {
+	case <-b.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the peer was not stopped by closing the pipes")
+	}
+}
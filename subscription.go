@@ -0,0 +1,280 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// SubscriptionParams is the params of the notifications of a subscription,
+// in the style of eth_subscribe.
+type SubscriptionParams struct {
+	// Subscription is the id of the subscription.
+	Subscription string `json:"subscription"`
+
+	// Result is the event.
+	Result json.RawMessage `json:"result"`
+}
+
+// publishedEvent is the params of a notification sent by a Publisher.
+type publishedEvent struct {
+	Subscription string      `json:"subscription"`
+	Result       interface{} `json:"result"`
+}
+
+// Publisher manages the subscriptions of the peer of a Conn, on the side that
+// sends the events.
+//
+// The handler of the subscribe method calls Subscribe and replies with the
+// id, and the handler of the unsubscribe method calls Unsubscribe.
+type Publisher struct {
+	conn   Conn
+	method string
+
+	mu   sync.Mutex
+	next uint64
+	subs map[string]context.CancelFunc
+}
+
+// NewPublisher returns a Publisher sending the events to conn as
+// notifications of method, with SubscriptionParams.
+func NewPublisher(conn Conn, method string) *Publisher {
+	return &Publisher{
+		conn:   conn,
+		method: method,
+		subs:   make(map[string]context.CancelFunc),
+	}
+}
+
+// Subscribe starts a subscription and returns its id.
+//
+// run is called in a goroutine of its own to produce the events of the
+// subscription on events, each sent as a notification. Its context is done
+// when the subscription is cancelled or the Conn is closed, after which the
+// events are no longer read. The subscription ends when run returns.
+func (p *Publisher) Subscribe(run func(ctx context.Context, events chan<- interface{})) string {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	p.mu.Lock()
+	p.next++
+	id := strconv.FormatUint(p.next, 10)
+	p.subs[id] = cancel
+	p.mu.Unlock()
+
+	events := make(chan interface{})
+	go func() {
+		defer close(events)
+		run(ctx, events)
+	}()
+	go func() {
+		defer p.Unsubscribe(id)
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				if err := p.conn.Notify(ctx, p.method, publishedEvent{Subscription: id, Result: event}); err != nil {
+					return
+				}
+			case <-ctx.Done():
+				return
+			case <-p.conn.Done():
+				return
+			}
+		}
+	}()
+
+	return id
+}
+
+// Unsubscribe cancels the subscription id, and reports whether it was active.
+func (p *Publisher) Unsubscribe(id string) bool {
+	p.mu.Lock()
+	cancel, ok := p.subs[id]
+	delete(p.subs, id)
+	p.mu.Unlock()
+
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// Subscriber manages the subscriptions made over a Conn, on the side that
+// receives the events.
+//
+// Its Handler must wrap the handler of the Conn, so that the notifications of
+// the subscriptions are delivered to them.
+type Subscriber struct {
+	conn   Conn
+	method string
+
+	mu       sync.Mutex
+	subs     map[string]*Subscription
+	inflight int                          // number of Subscribe calls in progress
+	early    map[string][]json.RawMessage // events received before their subscription was known
+}
+
+// NewSubscriber returns a Subscriber of the notifications of method received
+// on conn.
+func NewSubscriber(conn Conn, method string) *Subscriber {
+	return &Subscriber{
+		conn:   conn,
+		method: method,
+		subs:   make(map[string]*Subscription),
+		early:  make(map[string][]json.RawMessage),
+	}
+}
+
+// Subscribe calls method with params, and returns the subscription of the id
+// the peer replies with.
+func (s *Subscriber) Subscribe(ctx context.Context, method string, params interface{}) (*Subscription, error) {
+	s.mu.Lock()
+	s.inflight++
+	s.mu.Unlock()
+
+	var id string
+	_, err := s.conn.Call(ctx, method, params, &id)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inflight--
+	early := s.early[id]
+	delete(s.early, id)
+	if s.inflight == 0 {
+		s.early = make(map[string][]json.RawMessage)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sub := newSubscription(s, id)
+	for _, event := range early {
+		sub.push(event)
+	}
+	s.subs[id] = sub
+	return sub, nil
+}
+
+// Handler returns a handler that delivers the notifications of the
+// subscriptions, and passes all other requests to handler.
+func (s *Subscriber) Handler(handler Handler) (h Handler) {
+	h = Handler(func(ctx context.Context, reply Replier, req Request) error {
+		if req.Method() != s.method {
+			return handler(ctx, reply, req)
+		}
+
+		var params SubscriptionParams
+		if err := json.Unmarshal(req.Params(), &params); err != nil {
+			return reply(ctx, nil, fmt.Errorf("%s params: %v: %w", s.method, err, ErrInvalidParams))
+		}
+
+		s.mu.Lock()
+		if sub, ok := s.subs[params.Subscription]; ok {
+			sub.push(params.Result)
+		} else if s.inflight > 0 {
+			// the reply of the subscribe call may not have been processed yet
+			s.early[params.Subscription] = append(s.early[params.Subscription], params.Result)
+		}
+		s.mu.Unlock()
+
+		return reply(ctx, nil, nil)
+	})
+
+	return h
+}
+
+// Subscription is a subscription made by a Subscriber.
+type Subscription struct {
+	// ID is the id of the subscription.
+	ID string
+
+	// C delivers the events of the subscription, it is closed when the
+	// subscription is cancelled or the Conn is closed.
+	C <-chan json.RawMessage
+
+	s *Subscriber
+
+	mu     sync.Mutex
+	queue  []json.RawMessage
+	wake   chan struct{} // signaled when an event is queued
+	cancel chan struct{} // closed by Unsubscribe
+	once   sync.Once
+}
+
+// newSubscription returns the subscription id of s, delivering its events
+// until it is cancelled.
+func newSubscription(s *Subscriber, id string) *Subscription {
+	c := make(chan json.RawMessage)
+	sub := &Subscription{
+		ID:     id,
+		C:      c,
+		s:      s,
+		wake:   make(chan struct{}, 1),
+		cancel: make(chan struct{}),
+	}
+	go sub.deliver(c)
+	return sub
+}
+
+// push queues event, without blocking the read loop of the Conn.
+func (sub *Subscription) push(event json.RawMessage) {
+	sub.mu.Lock()
+	sub.queue = append(sub.queue, event)
+	sub.mu.Unlock()
+
+	select {
+	case sub.wake <- struct{}{}:
+	default:
+	}
+}
+
+// deliver sends the queued events on c until the subscription is cancelled.
+func (sub *Subscription) deliver(c chan<- json.RawMessage) {
+	defer close(c)
+	for {
+		sub.mu.Lock()
+		queue := sub.queue
+		sub.queue = nil
+		sub.mu.Unlock()
+
+		for _, event := range queue {
+			select {
+			case c <- event:
+			case <-sub.cancel:
+				return
+			case <-sub.s.conn.Done():
+				return
+			}
+		}
+
+		select {
+		case <-sub.wake:
+		case <-sub.cancel:
+			return
+		case <-sub.s.conn.Done():
+			return
+		}
+	}
+}
+
+// Unsubscribe cancels the subscription, and calls method with the id of the
+// subscription as its only param to cancel it on the peer.
+//
+// C is closed even if the call fails.
+func (sub *Subscription) Unsubscribe(ctx context.Context, method string) error {
+	sub.s.mu.Lock()
+	delete(sub.s.subs, sub.ID)
+	sub.s.mu.Unlock()
+	sub.once.Do(func() { close(sub.cancel) })
+
+	_, err := sub.s.conn.Call(ctx, method, []string{sub.ID}, nil)
+	return err
+}
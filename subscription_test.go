@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+func TestSubscriptions(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, server := pipeConns(t)
+
+	publisher := jsonrpc2.NewPublisher(server, "subscription")
+	stopped := make(chan struct{})
+	server.Go(ctx, func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		switch req.Method() {
+		case "subscribe":
+			id := publisher.Subscribe(func(ctx context.Context, events chan<- interface{}) {
+				defer close(stopped)
+				for i := 1; i <= 3; i++ {
+					events <- i
+				}
+				<-ctx.Done()
+			})
+			return reply(ctx, id, nil)
+		case "unsubscribe":
+			var ids []string
+			if err := json.Unmarshal(req.Params(), &ids); err != nil {
+				return reply(ctx, nil, err)
+			}
+			return reply(ctx, publisher.Unsubscribe(ids[0]), nil)
+		}
+		return jsonrpc2.MethodNotFoundHandler(ctx, reply, req)
+	})
+
+	subscriber := jsonrpc2.NewSubscriber(client, "subscription")
+	client.Go(ctx, subscriber.Handler(jsonrpc2.MethodNotFoundHandler))
+
+	sub, err := subscriber.Subscribe(ctx, "subscribe", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for want := 1; want <= 3; want++ {
+		var got int
+		if err := json.Unmarshal(<-sub.C, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("got event %d, want %d", got, want)
+		}
+	}
+
+	if err := sub.Unsubscribe(ctx, "unsubscribe"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-sub.C; ok {
+		t.Error("got an event after unsubscribing")
+	}
+	<-stopped
+}
@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// MethodTelemetryEvent is the default method of the notifications sent by a
+// Telemetry, as defined by the LSP specification.
+const MethodTelemetryEvent = "telemetry/event"
+
+// Telemetry sends one-way telemetry notifications to the peer of a Conn,
+// apart from the processing of requests.
+//
+// Events are buffered and sent from a goroutine of their own, so that Emit
+// never blocks. Events emitted while the buffer is full are dropped.
+type Telemetry struct {
+	conn    Conn
+	method  string
+	events  chan interface{}
+	dropped uint64 // access atomically
+}
+
+// NewTelemetry returns a Telemetry sending notifications of method to conn,
+// buffering up to size events. method defaults to MethodTelemetryEvent if
+// empty.
+//
+// The events are sent until conn is closed.
+func NewTelemetry(conn Conn, method string, size int) *Telemetry {
+	if method == "" {
+		method = MethodTelemetryEvent
+	}
+	t := &Telemetry{
+		conn:   conn,
+		method: method,
+		events: make(chan interface{}, size),
+	}
+	go t.run()
+	return t
+}
+
+// Emit queues event to be sent as the params of a notification, and reports
+// whether it was queued.
+func (t *Telemetry) Emit(event interface{}) bool {
+	select {
+	case <-t.conn.Done():
+		return false
+	default:
+	}
+
+	select {
+	case t.events <- event:
+		return true
+	default:
+		atomic.AddUint64(&t.dropped, 1)
+		return false
+	}
+}
+
+// Dropped returns the number of events dropped because the buffer was full.
+func (t *Telemetry) Dropped() uint64 { return atomic.LoadUint64(&t.dropped) }
+
+// run sends the queued events until the connection is closed.
+func (t *Telemetry) run() {
+	ctx := context.Background()
+	for {
+		select {
+		case event := <-t.events:
+			// errors are dropped like events, the connection is going away
+			_ = t.conn.Notify(ctx, t.method, event)
+		case <-t.conn.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,48 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+func TestTelemetry(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	a, b := pipeConns(t)
+
+	block := make(chan struct{})
+	events := make(chan string, 8)
+	a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+	b.Go(ctx, func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		<-block
+		events <- req.Method() + " " + string(req.Params())
+		return reply(ctx, nil, nil)
+	})
+
+	telemetry := jsonrpc2.NewTelemetry(a, "", 1)
+	// the peer stalls on the first event, so that at most one more event is
+	// being written and one is buffered
+	accepted := 0
+	for i := 0; i < 5; i++ {
+		if telemetry.Emit(i) {
+			accepted++
+		}
+	}
+	if accepted == 5 || telemetry.Dropped() == 0 {
+		t.Errorf("got %d events accepted and %d dropped, want some dropped", accepted, telemetry.Dropped())
+	}
+
+	close(block)
+	if got, want := <-events, jsonrpc2.MethodTelemetryEvent+" 0"; got != want {
+		t.Errorf("got event %q, want %q", got, want)
+	}
+}
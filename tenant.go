@@ -0,0 +1,276 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// TenantExtractor returns the tenant of a request, and whether it has one.
+type TenantExtractor func(ctx context.Context, req Request) (string, bool)
+
+// FixedTenant returns a TenantExtractor assigning every request to tenant, for
+// the handler of a connection serving a single tenant.
+func FixedTenant(tenant string) TenantExtractor {
+	return func(context.Context, Request) (string, bool) {
+		return tenant, true
+	}
+}
+
+// TenantHeader returns a TenantExtractor reading the tenant from the named
+// header the request was framed with.
+func TenantHeader(name string) TenantExtractor {
+	return func(ctx context.Context, req Request) (string, bool) {
+		carrier, ok := req.(HeaderCarrier)
+		if !ok {
+			return "", false
+		}
+		tenant := carrier.Header(name)
+		return tenant, tenant != ""
+	}
+}
+
+// TenantParam returns a TenantExtractor reading the tenant from the string
+// field of a params object.
+func TenantParam(field string) TenantExtractor {
+	return func(ctx context.Context, req Request) (string, bool) {
+		var params map[string]json.RawMessage
+		if err := json.Unmarshal(req.Params(), &params); err != nil {
+			return "", false
+		}
+		var tenant string
+		if err := json.Unmarshal(params[field], &tenant); err != nil {
+			return "", false
+		}
+		return tenant, tenant != ""
+	}
+}
+
+// TenantStats is the metrics of a tenant of a TenantRouter.
+type TenantStats struct {
+	// Requests is the number of requests of the tenant.
+	Requests int64
+
+	// Errors is the number of calls of the tenant replied to with an error.
+	Errors int64
+
+	// InFlight is the number of requests of the tenant being processed.
+	InFlight int64
+}
+
+// tenantState is a tenant of a TenantRouter.
+type tenantState struct {
+	handler Handler
+	used    uint64 // sequence number of the last request, protected by the router mu
+
+	requests int64 // access atomically
+	errors   int64 // access atomically
+	inflight int64 // access atomically
+}
+
+// TenantRouter dispatches requests to a Handler instance per tenant, with its
+// own limit of requests processed at once and its own metrics, as in a server
+// hosted for several customers.
+//
+// A single TenantRouter may serve the handlers of many connections, with
+// tenants derived per connection with FixedTenant, or per request.
+type TenantRouter struct {
+	newHandler func(tenant string) Handler
+	limit      int
+	maxTenants int
+
+	mu      sync.Mutex
+	tenants map[string]*tenantState
+	seq     uint64 // numbers the requests, to find the least recently used tenant
+}
+
+// DefaultMaxTenants is the maximum number of tenants a TenantRouter keeps,
+// unless configured with WithMaxTenants.
+const DefaultMaxTenants = 1024
+
+// TenantRouterOption configures a TenantRouter created by NewTenantRouter.
+type TenantRouterOption func(*TenantRouter)
+
+// WithMaxTenants returns a TenantRouterOption that sets the maximum number of
+// tenants kept, DefaultMaxTenants by default.
+//
+// The tenants are usually chosen by the peers, so their number is bounded:
+// once it is reached, the handler and the metrics of the least recently used
+// tenant without requests in flight are dropped to make room for a new one,
+// as with Remove. The requests of a new tenant are rejected with an error
+// wrapping ErrServerOverloaded while every tenant has requests in flight.
+func WithMaxTenants(n int) TenantRouterOption {
+	return func(r *TenantRouter) {
+		if n > 0 {
+			r.maxTenants = n
+		}
+	}
+}
+
+// NewTenantRouter returns a TenantRouter creating the handler of a tenant with
+// newHandler when its first request arrives, configured by opts.
+//
+// Each tenant processes at most limit requests at once, as with a Limiter; no
+// limit applies if limit is not positive. newHandler is called without locks
+// held, and may be called more than once for a tenant whose first requests
+// arrive concurrently, in which case a single handler is kept.
+func NewTenantRouter(newHandler func(tenant string) Handler, limit int, opts ...TenantRouterOption) *TenantRouter {
+	r := &TenantRouter{
+		newHandler: newHandler,
+		limit:      limit,
+		maxTenants: DefaultMaxTenants,
+		tenants:    make(map[string]*tenantState),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Handler returns a handler that passes each request to the handler of the
+// tenant returned by extract.
+//
+// The calls without a tenant are replied to with an error wrapping
+// ErrInvalidRequest, and the notifications without a tenant are dropped.
+func (r *TenantRouter) Handler(extract TenantExtractor) (h Handler) {
+	h = Handler(func(ctx context.Context, reply Replier, req Request) error {
+		name, ok := extract(ctx, req)
+		if !ok {
+			if _, isCall := req.(*Call); isCall {
+				return reply(ctx, nil, fmt.Errorf("%q: no tenant: %w", req.Method(), ErrInvalidRequest))
+			}
+			return nil
+		}
+		t, ok := r.begin(name)
+		if !ok {
+			if _, isCall := req.(*Call); isCall {
+				return reply(ctx, nil, fmt.Errorf("%q: too many tenants: %w", req.Method(), ErrServerOverloaded))
+			}
+			return nil
+		}
+
+		var once sync.Once
+		done := func() {
+			once.Do(func() { atomic.AddInt64(&t.inflight, -1) })
+		}
+
+		_, isCall := req.(*Call)
+		innerReply := reply
+		reply = func(ctx context.Context, result interface{}, err error) error {
+			done()
+			if err != nil {
+				atomic.AddInt64(&t.errors, 1)
+			}
+			return innerReply(ctx, result, err)
+		}
+		err := t.handler(ctx, reply, req)
+		if !isCall || err != nil {
+			done()
+		}
+		return err
+	})
+
+	return h
+}
+
+// begin returns the state of the tenant name, creating it if needed, with a
+// request of the tenant counted in flight. It reports false if the tenant is
+// new and there is no room for it.
+func (r *TenantRouter) begin(name string) (*tenantState, bool) {
+	r.mu.Lock()
+	if t, ok := r.tenants[name]; ok {
+		r.use(t)
+		r.mu.Unlock()
+		return t, true
+	}
+	r.mu.Unlock()
+
+	// newHandler may be slow or call back into r, so it runs without r.mu
+	handler := r.newHandler(name)
+	if r.limit > 0 {
+		handler = NewLimiter(r.limit).Handler(handler)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.tenants[name]
+	if !ok {
+		if len(r.tenants) >= r.maxTenants && !r.evictIdle() {
+			return nil, false
+		}
+		t = &tenantState{handler: handler}
+		r.tenants[name] = t
+	}
+	r.use(t)
+	return t, true
+}
+
+// use counts a request of t in flight. r.mu must be held.
+func (r *TenantRouter) use(t *tenantState) {
+	r.seq++
+	t.used = r.seq
+	atomic.AddInt64(&t.requests, 1)
+	atomic.AddInt64(&t.inflight, 1)
+}
+
+// evictIdle drops the least recently used tenant without requests in flight,
+// and reports whether there was one. r.mu must be held.
+func (r *TenantRouter) evictIdle() bool {
+	var (
+		oldest string
+		used   uint64
+		found  bool
+	)
+	for name, t := range r.tenants {
+		if atomic.LoadInt64(&t.inflight) == 0 && (!found || t.used < used) {
+			oldest, used, found = name, t.used, true
+		}
+	}
+	if found {
+		delete(r.tenants, oldest)
+	}
+	return found
+}
+
+// Tenants returns the sorted list of the tenants that sent requests.
+func (r *TenantRouter) Tenants() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tenants := make([]string, 0, len(r.tenants))
+	for name := range r.tenants {
+		tenants = append(tenants, name)
+	}
+	sort.Strings(tenants)
+	return tenants
+}
+
+// Stats returns the metrics of tenant, and whether it sent requests.
+func (r *TenantRouter) Stats(tenant string) (TenantStats, bool) {
+	r.mu.Lock()
+	t, ok := r.tenants[tenant]
+	r.mu.Unlock()
+	if !ok {
+		return TenantStats{}, false
+	}
+	return TenantStats{
+		Requests: atomic.LoadInt64(&t.requests),
+		Errors:   atomic.LoadInt64(&t.errors),
+		InFlight: atomic.LoadInt64(&t.inflight),
+	}, true
+}
+
+// Remove drops the handler and the metrics of tenant, so that its next
+// request gets a new handler. The requests being processed are not affected.
+func (r *TenantRouter) Remove(tenant string) {
+	r.mu.Lock()
+	delete(r.tenants, tenant)
+	r.mu.Unlock()
+}
@@ -0,0 +1,124 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+func TestTenantRouter(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	created := make(map[string]int)
+	router := jsonrpc2.NewTenantRouter(func(tenant string) jsonrpc2.Handler {
+		created[tenant]++
+		return func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+			if req.Method() == "fail" {
+				return reply(ctx, nil, errors.New("failed"))
+			}
+			return reply(ctx, tenant, nil)
+		}
+	}, 1)
+
+	a, b := pipeConns(t)
+	a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+	b.Go(ctx, router.Handler(jsonrpc2.TenantParam("tenant")))
+
+	type params struct {
+		Tenant string `json:"tenant,omitempty"`
+	}
+	for _, tenant := range []string{"acme", "initech", "acme"} {
+		var got string
+		if _, err := a.Call(ctx, "whoami", params{tenant}, &got); err != nil || got != tenant {
+			t.Errorf("got %q, %v for tenant %s, want %s", got, err, tenant, tenant)
+		}
+	}
+	if _, err := a.Call(ctx, "fail", params{"acme"}, nil); err == nil {
+		t.Error("got no error from a failing call")
+	}
+
+	var werr *jsonrpc2.Error
+	if _, err := a.Call(ctx, "whoami", params{}, nil); !errors.As(err, &werr) || werr.Code != jsonrpc2.InvalidRequest {
+		t.Errorf("got %v for a call without a tenant, want %v", err, jsonrpc2.ErrInvalidRequest)
+	}
+
+	if got := router.Tenants(); len(got) != 2 || got[0] != "acme" || got[1] != "initech" {
+		t.Errorf("router.Tenants(): got %v, want [acme initech]", got)
+	}
+	if created["acme"] != 1 || created["initech"] != 1 {
+		t.Errorf("got handlers created %v, want one per tenant", created)
+	}
+	want := jsonrpc2.TenantStats{Requests: 3, Errors: 1}
+	if got, ok := router.Stats("acme"); !ok || got != want {
+		t.Errorf(`router.Stats("acme"): got %+v, want %+v`, got, want)
+	}
+}
+
+func TestTenantRouterMaxTenants(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	release := make(chan struct{})
+	var router *jsonrpc2.TenantRouter
+	router = jsonrpc2.NewTenantRouter(func(tenant string) jsonrpc2.Handler {
+		router.Tenants() // newHandler runs without the router locked
+		return jsonrpc2.AsyncHandler(func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+			if req.Method() == "block" {
+				<-release
+			}
+			return reply(ctx, tenant, nil)
+		})
+	}, 0, jsonrpc2.WithMaxTenants(2))
+
+	a, b := pipeConns(t)
+	a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+	b.Go(ctx, router.Handler(jsonrpc2.TenantParam("tenant")))
+
+	type params struct {
+		Tenant string `json:"tenant,omitempty"`
+	}
+	for _, tenant := range []string{"acme", "initech", "acme", "globex"} {
+		if _, err := a.Call(ctx, "whoami", params{tenant}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// initech was the least recently used tenant
+	if got := router.Tenants(); len(got) != 2 || got[0] != "acme" || got[1] != "globex" {
+		t.Errorf("router.Tenants(): got %v, want [acme globex]", got)
+	}
+
+	// no tenant can be dropped while all have requests in flight
+	blocked := make(chan error, 2)
+	for _, tenant := range []string{"acme", "globex"} {
+		tenant := tenant
+		go func() {
+			_, err := a.Call(ctx, "block", params{tenant}, nil)
+			blocked <- err
+		}()
+	}
+	for {
+		acme, _ := router.Stats("acme")
+		globex, _ := router.Stats("globex")
+		if acme.InFlight == 1 && globex.InFlight == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	var werr *jsonrpc2.Error
+	if _, err := a.Call(ctx, "whoami", params{"initech"}, nil); !errors.As(err, &werr) || werr.Code != jsonrpc2.ServerOverloaded {
+		t.Errorf("got %v for a new tenant, want %v", err, jsonrpc2.ErrServerOverloaded)
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-blocked; err != nil {
+			t.Error(err)
+		}
+	}
+}
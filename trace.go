@@ -0,0 +1,127 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// list of trace methods defined by the LSP specification.
+const (
+	// MethodSetTrace is the notification a client sends to change the trace
+	// value of the server.
+	MethodSetTrace = "$/setTrace"
+
+	// MethodLogTrace is the notification a server sends to log its execution
+	// trace, as allowed by the trace value.
+	MethodLogTrace = "$/logTrace"
+)
+
+// TraceValue is the verbosity of the $/logTrace notifications of a server.
+type TraceValue string
+
+// list of TraceValues.
+const (
+	// TraceOff disables the notifications.
+	TraceOff TraceValue = "off"
+
+	// TraceMessages sends the notifications without their verbose part.
+	TraceMessages TraceValue = "messages"
+
+	// TraceVerbose sends the notifications in full.
+	TraceVerbose TraceValue = "verbose"
+)
+
+// SetTraceParams is the params of a $/setTrace notification.
+type SetTraceParams struct {
+	// Value is the new trace value.
+	Value TraceValue `json:"value"`
+}
+
+// LogTraceParams is the params of a $/logTrace notification.
+type LogTraceParams struct {
+	// Message is the message to log.
+	Message string `json:"message"`
+
+	// Verbose is additional information, only sent under TraceVerbose.
+	Verbose string `json:"verbose,omitempty"`
+}
+
+// Tracer implements the trace protocol of LSP on the server side of a Conn.
+//
+// Its Handler honors the $/setTrace notifications of the client, and Log
+// sends $/logTrace notifications as allowed by the current trace value.
+type Tracer struct {
+	conn  Conn
+	onSet func(TraceValue) // called when the value changes, may be nil
+
+	mu    sync.Mutex
+	value TraceValue
+}
+
+// NewTracer returns a Tracer logging to conn, starting at value.
+//
+// onSet is called with every value set by the client, so that the server can
+// adjust the verbosity of its other logs, it may be nil.
+func NewTracer(conn Conn, value TraceValue, onSet func(TraceValue)) *Tracer {
+	return &Tracer{
+		conn:  conn,
+		onSet: onSet,
+		value: value,
+	}
+}
+
+// Value returns the current trace value.
+func (t *Tracer) Value() TraceValue {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.value
+}
+
+// Log sends a $/logTrace notification of message to the client, with verbose
+// under TraceVerbose. It sends nothing under TraceOff.
+func (t *Tracer) Log(ctx context.Context, message, verbose string) error {
+	params := LogTraceParams{Message: message}
+	switch t.Value() {
+	case TraceOff:
+		return nil
+	case TraceVerbose:
+		params.Verbose = verbose
+	}
+	return t.conn.Notify(ctx, MethodLogTrace, params)
+}
+
+// Handler returns a handler that processes $/setTrace notifications, and
+// passes all other requests to handler.
+func (t *Tracer) Handler(handler Handler) (h Handler) {
+	h = Handler(func(ctx context.Context, reply Replier, req Request) error {
+		if req.Method() != MethodSetTrace {
+			return handler(ctx, reply, req)
+		}
+
+		var params SetTraceParams
+		if err := json.Unmarshal(req.Params(), &params); err != nil {
+			return reply(ctx, nil, fmt.Errorf("%s params: %v: %w", MethodSetTrace, err, ErrInvalidParams))
+		}
+		switch params.Value {
+		case TraceOff, TraceMessages, TraceVerbose:
+		default:
+			return reply(ctx, nil, fmt.Errorf("trace value %q: %w", params.Value, ErrInvalidParams))
+		}
+
+		t.mu.Lock()
+		t.value = params.Value
+		t.mu.Unlock()
+		if t.onSet != nil {
+			t.onSet(params.Value)
+		}
+		return reply(ctx, nil, nil)
+	})
+
+	return h
+}
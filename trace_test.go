@@ -0,0 +1,66 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+func TestTracer(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, server := pipeConns(t)
+
+	logs := make(chan jsonrpc2.LogTraceParams, 1)
+	client.Go(ctx, func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		var params jsonrpc2.LogTraceParams
+		if err := json.Unmarshal(req.Params(), &params); err != nil {
+			t.Error(err)
+		}
+		logs <- params
+		return reply(ctx, nil, nil)
+	})
+	set := make(chan jsonrpc2.TraceValue, 1)
+	tracer := jsonrpc2.NewTracer(server, jsonrpc2.TraceOff, func(v jsonrpc2.TraceValue) { set <- v })
+	server.Go(ctx, tracer.Handler(jsonrpc2.MethodNotFoundHandler))
+
+	if err := tracer.Log(ctx, "dropped", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	params := jsonrpc2.SetTraceParams{Value: jsonrpc2.TraceMessages}
+	if err := client.Notify(ctx, jsonrpc2.MethodSetTrace, params); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-set; got != jsonrpc2.TraceMessages {
+		t.Fatalf("got trace value %q, want %q", got, jsonrpc2.TraceMessages)
+	}
+	if err := tracer.Log(ctx, "messages", "details"); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-logs; got.Message != "messages" || got.Verbose != "" {
+		t.Errorf("got %+v, want the message without details", got)
+	}
+
+	params.Value = jsonrpc2.TraceVerbose
+	if err := client.Notify(ctx, jsonrpc2.MethodSetTrace, params); err != nil {
+		t.Fatal(err)
+	}
+	<-set
+	if err := tracer.Log(ctx, "verbose", "details"); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-logs; got.Message != "verbose" || got.Verbose != "details" {
+		t.Errorf("got %+v, want the message with details", got)
+	}
+}
@@ -0,0 +1,509 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package ubjson provides a jsonrpc2.Framer exchanging messages encoded with
+// Universal Binary JSON (https://ubjson.org), for embedded peers that already
+// ship a UBJSON library.
+//
+// Messages are converted from and to their JSON encoding, so that the
+// messages read are the same as the ones read by the streams of the jsonrpc2
+// package. UBJSON values are self delimiting, so no header frames them.
+package ubjson
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/segmentio/encoding/json"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+// list of UBJSON type markers.
+const (
+	markerNull    = 'Z'
+	markerNoOp    = 'N'
+	markerTrue    = 'T'
+	markerFalse   = 'F'
+	markerInt8    = 'i'
+	markerUint8   = 'U'
+	markerInt16   = 'I'
+	markerInt32   = 'l'
+	markerInt64   = 'L'
+	markerFloat32 = 'd'
+	markerFloat64 = 'D'
+	markerHighNum = 'H'
+	markerChar    = 'C'
+	markerString  = 'S'
+	markerArray   = '['
+	markerArrEnd  = ']'
+	markerObject  = '{'
+	markerObjEnd  = '}'
+	markerType    = '$'
+	markerCount   = '#'
+)
+
+// maxDepth is the maximum nesting of the containers of a value.
+const maxDepth = 512
+
+// FromJSON returns the UBJSON encoding of the JSON value data.
+//
+// Integers are encoded with the smallest integer type holding them, other
+// numbers as float64 if they are decoded back to the same JSON, and as
+// high-precision numbers otherwise.
+func FromJSON(data []byte) ([]byte, error) {
+	dec := stdjson.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var buf bytes.Buffer
+	if err := encodeValue(&buf, dec); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("ubjson: trailing data after JSON value")
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeValue encodes the next JSON value of dec to buf.
+func encodeValue(buf *bytes.Buffer, dec *stdjson.Decoder) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("ubjson: %w", err)
+	}
+
+	switch tok := tok.(type) {
+	case nil:
+		buf.WriteByte(markerNull)
+	case bool:
+		if tok {
+			buf.WriteByte(markerTrue)
+		} else {
+			buf.WriteByte(markerFalse)
+		}
+	case stdjson.Number:
+		encodeNumber(buf, string(tok))
+	case string:
+		buf.WriteByte(markerString)
+		encodeString(buf, tok)
+	case stdjson.Delim:
+		switch tok {
+		case '[':
+			buf.WriteByte(markerArray)
+			for dec.More() {
+				if err := encodeValue(buf, dec); err != nil {
+					return err
+				}
+			}
+			buf.WriteByte(markerArrEnd)
+		case '{':
+			buf.WriteByte(markerObject)
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return fmt.Errorf("ubjson: %w", err)
+				}
+				encodeString(buf, key.(string))
+				if err := encodeValue(buf, dec); err != nil {
+					return err
+				}
+			}
+			buf.WriteByte(markerObjEnd)
+		}
+		if _, err := dec.Token(); err != nil { // closing delimiter
+			return fmt.Errorf("ubjson: %w", err)
+		}
+	}
+	return nil
+}
+
+// encodeNumber encodes the JSON number n to buf.
+func encodeNumber(buf *bytes.Buffer, n string) {
+	if i, err := strconv.ParseInt(n, 10, 64); err == nil {
+		encodeInt(buf, i)
+		return
+	}
+	if f, err := strconv.ParseFloat(n, 64); err == nil && strconv.FormatFloat(f, 'g', -1, 64) == n {
+		buf.WriteByte(markerFloat64)
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], math.Float64bits(f))
+		buf.Write(b[:])
+		return
+	}
+	buf.WriteByte(markerHighNum)
+	encodeString(buf, n)
+}
+
+// encodeInt encodes i to buf with the smallest integer type holding it.
+func encodeInt(buf *bytes.Buffer, i int64) {
+	var b [8]byte
+	switch {
+	case i >= 0 && i <= math.MaxUint8:
+		buf.WriteByte(markerUint8)
+		buf.WriteByte(byte(i))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		buf.WriteByte(markerInt8)
+		buf.WriteByte(byte(int8(i)))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		buf.WriteByte(markerInt16)
+		binary.BigEndian.PutUint16(b[:2], uint16(int16(i)))
+		buf.Write(b[:2])
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		buf.WriteByte(markerInt32)
+		binary.BigEndian.PutUint32(b[:4], uint32(int32(i)))
+		buf.Write(b[:4])
+	default:
+		buf.WriteByte(markerInt64)
+		binary.BigEndian.PutUint64(b[:], uint64(i))
+		buf.Write(b[:])
+	}
+}
+
+// encodeString encodes the length and bytes of s to buf, without a marker.
+func encodeString(buf *bytes.Buffer, s string) {
+	encodeInt(buf, int64(len(s)))
+	buf.WriteString(s)
+}
+
+// ToJSON returns the JSON encoding of the UBJSON value data.
+func ToJSON(data []byte) ([]byte, error) {
+	r := bufio.NewReader(bytes.NewReader(data))
+	out, err := readValue(r)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		return nil, errors.New("ubjson: trailing data after value")
+	}
+	return out, nil
+}
+
+// readValue reads the next UBJSON value from r, skipping no-op markers, and
+// returns its JSON encoding.
+func readValue(r *bufio.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	for {
+		marker, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if marker == markerNoOp {
+			continue
+		}
+		if err := decodeValue(&buf, r, marker, 0); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+}
+
+// decodeValue decodes the value of type marker from r as JSON to buf.
+func decodeValue(buf *bytes.Buffer, r *bufio.Reader, marker byte, depth int) error {
+	if depth > maxDepth {
+		return errors.New("ubjson: value nested too deeply")
+	}
+
+	switch marker {
+	case markerNull:
+		buf.WriteString("null")
+	case markerTrue:
+		buf.WriteString("true")
+	case markerFalse:
+		buf.WriteString("false")
+	case markerInt8, markerUint8, markerInt16, markerInt32, markerInt64:
+		i, err := decodeInt(r, marker)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(strconv.FormatInt(i, 10))
+	case markerFloat32, markerFloat64:
+		f, err := decodeFloat(r, marker)
+		if err != nil {
+			return err
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			buf.WriteString("null")
+			break
+		}
+		buf.WriteString(strconv.FormatFloat(f, 'g', -1, 64))
+	case markerHighNum:
+		s, err := decodeString(r)
+		if err != nil {
+			return err
+		}
+		if !stdjson.Valid([]byte(s)) {
+			return fmt.Errorf("ubjson: invalid high-precision number %q", s)
+		}
+		buf.WriteString(s)
+	case markerChar:
+		c, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		return writeString(buf, string([]byte{c}))
+	case markerString:
+		s, err := decodeString(r)
+		if err != nil {
+			return err
+		}
+		return writeString(buf, s)
+	case markerArray, markerObject:
+		return decodeContainer(buf, r, marker, depth)
+	default:
+		return fmt.Errorf("ubjson: unknown type marker %q", marker)
+	}
+	return nil
+}
+
+// decodeContainer decodes the array or object opened by marker from r as
+// JSON to buf, in its plain or optimized form.
+func decodeContainer(buf *bytes.Buffer, r *bufio.Reader, marker byte, depth int) error {
+	object := marker == markerObject
+	end := byte(markerArrEnd)
+	if object {
+		end = markerObjEnd
+		buf.WriteByte('{')
+	} else {
+		buf.WriteByte('[')
+	}
+
+	// optimized containers declare the type of their values and their count
+	var valueType byte
+	count := int64(-1)
+	next, err := r.Peek(1)
+	if err != nil {
+		return err
+	}
+	if next[0] == markerType {
+		r.ReadByte()
+		if valueType, err = r.ReadByte(); err != nil {
+			return err
+		}
+		if next, err = r.Peek(1); err != nil {
+			return err
+		}
+		if next[0] != markerCount {
+			return errors.New("ubjson: container type without count")
+		}
+	}
+	if next[0] == markerCount {
+		r.ReadByte()
+		countMarker, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if count, err = decodeInt(r, countMarker); err != nil {
+			return err
+		}
+		if count < 0 {
+			return fmt.Errorf("ubjson: invalid container count %d", count)
+		}
+	}
+
+	for i := int64(0); count < 0 || i < count; i++ {
+		if count < 0 {
+			m, err := r.ReadByte()
+			if err != nil {
+				return err
+			}
+			if m == markerNoOp {
+				i--
+				continue
+			}
+			if m == end {
+				break
+			}
+			r.UnreadByte()
+		}
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		if object {
+			key, err := decodeString(r)
+			if err != nil {
+				return err
+			}
+			if err := writeString(buf, key); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+		}
+
+		m := valueType
+		if m == 0 {
+			if m, err = r.ReadByte(); err != nil {
+				return err
+			}
+		}
+		if err := decodeValue(buf, r, m, depth+1); err != nil {
+			return err
+		}
+	}
+
+	if object {
+		buf.WriteByte('}')
+	} else {
+		buf.WriteByte(']')
+	}
+	return nil
+}
+
+// decodeInt decodes an integer of type marker from r.
+func decodeInt(r io.Reader, marker byte) (int64, error) {
+	var b [8]byte
+	switch marker {
+	case markerInt8:
+		_, err := io.ReadFull(r, b[:1])
+		return int64(int8(b[0])), err
+	case markerUint8:
+		_, err := io.ReadFull(r, b[:1])
+		return int64(b[0]), err
+	case markerInt16:
+		_, err := io.ReadFull(r, b[:2])
+		return int64(int16(binary.BigEndian.Uint16(b[:2]))), err
+	case markerInt32:
+		_, err := io.ReadFull(r, b[:4])
+		return int64(int32(binary.BigEndian.Uint32(b[:4]))), err
+	case markerInt64:
+		_, err := io.ReadFull(r, b[:])
+		return int64(binary.BigEndian.Uint64(b[:])), err
+	default:
+		return 0, fmt.Errorf("ubjson: type marker %q is not an integer", marker)
+	}
+}
+
+// decodeFloat decodes a float of type marker from r.
+func decodeFloat(r io.Reader, marker byte) (float64, error) {
+	var b [8]byte
+	if marker == markerFloat32 {
+		_, err := io.ReadFull(r, b[:4])
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b[:4]))), err
+	}
+	_, err := io.ReadFull(r, b[:])
+	return math.Float64frombits(binary.BigEndian.Uint64(b[:])), err
+}
+
+// decodeString decodes the length and bytes of a string from r.
+func decodeString(r io.Reader) (string, error) {
+	var m [1]byte
+	if _, err := io.ReadFull(r, m[:]); err != nil {
+		return "", err
+	}
+	n, err := decodeInt(r, m[0])
+	if err != nil {
+		return "", err
+	}
+	if n < 0 {
+		return "", fmt.Errorf("ubjson: invalid string length %d", n)
+	}
+
+	// copy rather than allocate n bytes up front, n is not trusted
+	var s bytes.Buffer
+	if _, err := io.CopyN(&s, r, n); err != nil {
+		return "", err
+	}
+	return s.String(), nil
+}
+
+// writeString writes s to buf as a JSON string.
+func writeString(buf *bytes.Buffer, s string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("ubjson: %w", err)
+	}
+	buf.Write(data)
+	return nil
+}
+
+// stream is a jsonrpc2.Stream of UBJSON encoded messages.
+type stream struct {
+	conn io.ReadWriteCloser
+	in   *bufio.Reader
+	n    int64 // bytes read from conn through in, reset by Read
+}
+
+// compile time check whether the stream implements a jsonrpc2.Stream interface.
+var _ jsonrpc2.Stream = (*stream)(nil)
+
+// NewStream returns a jsonrpc2.Stream exchanging UBJSON encoded messages over
+// conn. It is a jsonrpc2.Framer.
+func NewStream(conn io.ReadWriteCloser) jsonrpc2.Stream {
+	s := &stream{conn: conn}
+	s.in = bufio.NewReader(readCounter{s})
+	return s
+}
+
+// readCounter counts the bytes read from the connection of a stream.
+type readCounter struct {
+	s *stream
+}
+
+// Read implements io.Reader.
+func (r readCounter) Read(p []byte) (int, error) {
+	n, err := r.s.conn.Read(p)
+	r.s.n += int64(n)
+	return n, err
+}
+
+// Read implements jsonrpc2.Stream.
+func (s *stream) Read(ctx context.Context) (jsonrpc2.Message, int64, error) {
+	select {
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	default:
+	}
+
+	// the bytes buffered by a previous read are part of this message
+	buffered := int64(s.in.Buffered())
+	s.n = 0
+	data, err := readValue(s.in)
+	n := s.n + buffered - int64(s.in.Buffered())
+	if err != nil {
+		return nil, n, fmt.Errorf("reading message: %w", err)
+	}
+
+	msg, err := jsonrpc2.DecodeMessage(data)
+	if err != nil {
+		if !errors.Is(err, jsonrpc2.ErrInvalidRequest) {
+			err = fmt.Errorf("%w: %v", jsonrpc2.ErrParse, err)
+		}
+		return nil, n, err
+	}
+	return msg, n, nil
+}
+
+// Write implements jsonrpc2.Stream.
+func (s *stream) Write(ctx context.Context, msg jsonrpc2.Message) (int64, error) {
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	default:
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return 0, fmt.Errorf("marshaling message: %w", err)
+	}
+	if data, err = FromJSON(data); err != nil {
+		return 0, fmt.Errorf("encoding message: %w", err)
+	}
+	n, err := s.conn.Write(data)
+	if err != nil {
+		return int64(n), fmt.Errorf("write data to conn: %w", err)
+	}
+	return int64(n), nil
+}
+
+// Close implements jsonrpc2.Stream.
+func (s *stream) Close() error {
+	return s.conn.Close()
+}
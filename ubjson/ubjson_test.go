@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ubjson_test
+
+import (
+	"bytes"
+	"testing"
+
+	"go.lsp.dev/jsonrpc2/conformance"
+	"go.lsp.dev/jsonrpc2/ubjson"
+)
+
+func TestConformance(t *testing.T) {
+	conformance.Run(t, ubjson.NewStream)
+}
+
+func TestRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		json string
+		want []byte
+	}{
+		"null":    {json: `null`, want: []byte("Z")},
+		"bool":    {json: `[true,false]`, want: []byte("[TF]")},
+		"uint8":   {json: `200`, want: []byte{'U', 200}},
+		"int8":    {json: `-1`, want: []byte{'i', 0xff}},
+		"int16":   {json: `1000`, want: []byte{'I', 0x03, 0xe8}},
+		"int32":   {json: `100000`, want: []byte{'l', 0x00, 0x01, 0x86, 0xa0}},
+		"int64":   {json: `10000000000`, want: []byte{'L', 0, 0, 0, 0x02, 0x54, 0x0b, 0xe4, 0}},
+		"float":   {json: `1.5`, want: []byte{'D', 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		"highNum": {json: `123456789012345678901234567890`},
+		"string":  {json: `"été"`, want: append([]byte{'S', 'U', 5}, "été"...)},
+		"object":  {json: `{"b":1,"a":[null]}`, want: []byte("{U\x01bU\x01U\x01a[Z]}")},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			data, err := ubjson.FromJSON([]byte(tt.json))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want != nil && !bytes.Equal(data, tt.want) {
+				t.Errorf("got %q encoded, want %q", data, tt.want)
+			}
+			back, err := ubjson.ToJSON(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(back); got != tt.json {
+				t.Errorf("got %s decoded, want %s", got, tt.json)
+			}
+		})
+	}
+}
+
+func TestOptimizedContainer(t *testing.T) {
+	t.Parallel()
+
+	// a no-op, then an array of three int8 values declared up front
+	data := []byte("N[$i#U\x03\x01\x02\x03")
+	got, err := ubjson.ToJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[1,2,3]`; string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// an object of two values counted up front
+	data = []byte("{#U\x02U\x01aTU\x01bC\x78")
+	if got, err = ubjson.ToJSON(data); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"a":true,"b":"x"}`; string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
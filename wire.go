@@ -4,7 +4,10 @@
 package jsonrpc2
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 
 	"github.com/segmentio/encoding/json"
 )
@@ -40,15 +43,50 @@ func (version) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// Canonicalize returns the canonical form of the JSON value data: compact,
+// with the keys of its objects sorted, and without HTML escaping, so that
+// equivalent values can be signed, cached or compared byte for byte.
+//
+// Numbers are kept as they are written. Of duplicate keys, the last one is
+// kept.
+func Canonicalize(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("decoding value: %w", err)
+	}
+	if err := dec.Decode(new(interface{})); !errors.Is(err, io.EOF) {
+		return nil, errors.New("decoding value: trailing data")
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, fmt.Errorf("encoding value: %w", err)
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte{'\n'}), nil
+}
+
 // ID is a Request identifier.
 //
 // Only one of either the Name or Number members will be set, using the
-// number form if the Name is the empty string.
+// number form if the Name is the empty string, unless the ID is NullID or was
+// read WithPermissiveIDs as raw JSON.
 type ID struct {
 	name   string
 	number int32
+	null   bool
+	raw    string // raw JSON of the IDs that are neither int32 numbers nor strings
 }
 
+// NullID is the explicit null ID of the error responses to messages whose ID
+// could not be determined, such as parse and invalid request errors.
+//
+// It is distinct from the zero number ID.
+var NullID = ID{null: true}
+
 // compile time check whether the ID implements a fmt.Formatter, json.Marshaler and json.Unmarshaler interfaces.
 var (
 	_ fmt.Formatter    = (*ID)(nil)
@@ -73,6 +111,10 @@ func (id ID) Format(f fmt.State, r rune) {
 	}
 
 	switch {
+	case id.null:
+		fmt.Fprint(f, "null")
+	case id.raw != "":
+		fmt.Fprint(f, id.raw)
 	case id.name != "":
 		fmt.Fprintf(f, strF, id.name)
 	default:
@@ -82,6 +124,12 @@ func (id ID) Format(f fmt.State, r rune) {
 
 // MarshalJSON implements json.Marshaler.
 func (id *ID) MarshalJSON() ([]byte, error) {
+	if id.null {
+		return []byte("null"), nil
+	}
+	if id.raw != "" {
+		return []byte(id.raw), nil
+	}
 	if id.name != "" {
 		return json.Marshal(id.name)
 	}
@@ -91,6 +139,10 @@ func (id *ID) MarshalJSON() ([]byte, error) {
 // UnmarshalJSON implements json.Unmarshaler.
 func (id *ID) UnmarshalJSON(data []byte) error {
 	*id = ID{}
+	if string(data) == "null" {
+		*id = NullID
+		return nil
+	}
 	if err := json.Unmarshal(data, &id.number); err == nil {
 		return nil
 	}
@@ -132,7 +184,7 @@ type wireResponse struct {
 // We can decode this and then work out which it is.
 type combined struct {
 	VersionTag version          `json:"jsonrpc"`
-	ID         *ID              `json:"id,omitempty"`
+	ID         json.RawMessage  `json:"id,omitempty"`
 	Method     string           `json:"method"`
 	Params     *json.RawMessage `json:"params,omitempty"`
 	Result     *json.RawMessage `json:"result,omitempty"`
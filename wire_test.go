@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/segmentio/encoding/json"
 
@@ -102,6 +103,32 @@ func TestIDDecode(t *testing.T) {
 	}
 }
 
+func TestNullID(t *testing.T) {
+	t.Parallel()
+
+	if jsonrpc2.NullID == jsonrpc2.NewNumberID(0) {
+		t.Error("NullID is the zero number ID")
+	}
+	if got := fmt.Sprintf("%v %q", jsonrpc2.NullID, jsonrpc2.NullID); got != "null null" {
+		t.Errorf("got %s, want null null", got)
+	}
+
+	resp, _ := jsonrpc2.NewResponse(jsonrpc2.NullID, nil, jsonrpc2.NewError(jsonrpc2.ParseError, "oops"))
+	data, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkJSON(t, data, []byte(`{"jsonrpc":"2.0","error":{"code":-32700,"message":"oops"},"id":null}`))
+
+	msg, err := jsonrpc2.DecodeMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := msg.(*jsonrpc2.Response).ID(); id != jsonrpc2.NullID {
+		t.Errorf("decoded id %v, want null", id)
+	}
+}
+
 func TestErrorEncode(t *testing.T) {
 	t.Parallel()
 
@@ -137,6 +164,43 @@ func TestErrorResponse(t *testing.T) {
 	}`))
 }
 
+func TestErrorData(t *testing.T) {
+	t.Parallel()
+
+	werr, err := jsonrpc2.NewError(jsonrpc2.ServerOverloaded, "busy").WithData(jsonrpc2.ErrorData{
+		Retryable:  true,
+		RetryAfter: 500 * time.Millisecond,
+		Details:    []string{"index is rebuilding"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(werr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkJSON(t, b, []byte(`{
+		"code": -32003,
+		"message": "busy",
+		"data": {"retryable": true, "retryAfter": 500, "details": ["index is rebuilding"]}
+	}`))
+
+	var decoded jsonrpc2.Error
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Retryable() || decoded.RetryAfter() != 500*time.Millisecond {
+		t.Errorf("got retryable %v after %v, want true after 500ms", decoded.Retryable(), decoded.RetryAfter())
+	}
+	if data, ok := decoded.ErrorData(); !ok || len(data.Details) != 1 {
+		t.Errorf("got error data %+v, want the details", data)
+	}
+
+	if _, ok := jsonrpc2.NewError(jsonrpc2.InternalError, "plain").ErrorData(); ok {
+		t.Error("got error data for an error without data")
+	}
+}
+
 func checkJSON(t *testing.T, got, want []byte) {
 	t.Helper()
 
@@ -155,3 +219,78 @@ func checkJSON(t *testing.T, got, want []byte) {
 		t.Fatalf("Got:\n%s\nWant:\n%s", g, w)
 	}
 }
+
+func TestRawMessageParams(t *testing.T) {
+	t.Parallel()
+
+	raw := json.RawMessage(`{"html":"<b>", "spaced": true}`)
+	for name, params := range map[string]interface{}{"value": raw, "pointer": &raw} {
+		params := params
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			notify, err := jsonrpc2.NewNotification("raw", params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(notify.Params(), raw) {
+				t.Errorf("got params %s, want %s unchanged", notify.Params(), raw)
+			}
+		})
+	}
+}
+
+func BenchmarkRawMessageParams(b *testing.B) {
+	raw := json.RawMessage(`{"textDocument":{"uri":"file:///a.go"},"position":{"line":10,"character":4}}`)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := jsonrpc2.NewCall(jsonrpc2.NewNumberID(1), "textDocument/hover", raw); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		in, want string
+		wantErr  bool
+	}{
+		"sorted": {
+			in:   `{"b": 1, "a": {"d": [1, 2], "c": null}}`,
+			want: `{"a":{"c":null,"d":[1,2]},"b":1}`,
+		},
+		"numbers": {
+			in:   `[1.50, 1e3, 12345678901234567890]`,
+			want: `[1.50,1e3,12345678901234567890]`,
+		},
+		"html": {
+			in:   ` "<b>&" `,
+			want: `"<b>&"`,
+		},
+		"trailing": {
+			in:      `{} {}`,
+			wantErr: true,
+		},
+		"invalid": {
+			in:      `{"a":`,
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := jsonrpc2.Canonicalize([]byte(tt.in))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
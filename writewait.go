@@ -0,0 +1,162 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2
+
+import (
+	"context"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// WriteWaitObserver is called with the time each message waited to be written
+// to the stream of a Conn, behind the messages written by other goroutines.
+//
+// It is called from the writing goroutines, so it must be safe for concurrent
+// use, and return promptly as the stream is held while it runs.
+type WriteWaitObserver func(msg Message, wait time.Duration)
+
+// WithWriteWaitObserver returns a ConnOption that reports the time each
+// message waits to be written to observe, to profile the contention on the
+// single writer of the Conn.
+func WithWriteWaitObserver(observe WriteWaitObserver) ConnOption {
+	return func(c *conn) {
+		c.writeWait = observe
+	}
+}
+
+// WithDoubleBufferedWrites returns a ConnOption that queues the messages to
+// write instead of making the writing goroutines take turns on the stream.
+//
+// The first goroutine finding the queue idle writes the queued messages in
+// order, swapping the queue with a spare buffer, while the others wait for
+// their message to be written. It then hands the writing of the messages queued
+// meanwhile over to the first of their goroutines, so that every goroutine
+// returns once its own message is written. The mode is
+// experimental, to compare the contention of both modes with a
+// WriteWaitObserver.
+func WithDoubleBufferedWrites() ConnOption {
+	return func(c *conn) {
+		c.doubleBuffered = true
+	}
+}
+
+// queuedWrite is a message waiting in the write queue of a Conn.
+type queuedWrite struct {
+	ctx    context.Context
+	msg    Message
+	queued time.Time
+	done   chan queuedResult
+}
+
+// queuedResult is the result of writing a queuedWrite.
+type queuedResult struct {
+	n     int64
+	err   error
+	flush bool // the message is not written yet, its writer must flush the queue
+}
+
+// writeQueued writes msg through the write queue.
+func (c *conn) writeQueued(ctx context.Context, msg Message) (int64, error) {
+	w := &queuedWrite{ctx: ctx, msg: msg, queued: time.Now(), done: make(chan queuedResult, 1)}
+
+	c.queueMu.Lock()
+	c.queue = append(c.queue, w)
+	flush := !c.flushing
+	c.flushing = true
+	c.queueMu.Unlock()
+
+	if flush {
+		c.flushQueue()
+	}
+	r := <-w.done
+	if r.flush {
+		c.flushQueue()
+		r = <-w.done
+	}
+	return r.n, r.err
+}
+
+// flushQueue writes the queued messages, which include the message of the
+// calling goroutine, then hands the flushing over to the goroutine of the
+// first message queued meanwhile, if any.
+func (c *conn) flushQueue() {
+	c.queueMu.Lock()
+	batch := c.queue
+	c.queue, c.spare = c.spare[:0], nil
+	c.queueMu.Unlock()
+
+	for i, w := range batch {
+		if c.writeWait != nil {
+			c.writeWait(w.msg, time.Since(w.queued))
+		}
+		n, err := c.stream.Write(w.ctx, w.msg)
+		w.done <- queuedResult{n: n, err: err}
+		batch[i] = nil
+	}
+
+	c.queueMu.Lock()
+	c.spare = batch[:0]
+	if len(c.queue) > 0 {
+		c.queue[0].done <- queuedResult{flush: true}
+	} else {
+		c.flushing = false
+	}
+	c.queueMu.Unlock()
+}
+
+// WriteWaitHistogram is the distribution of write waits in buckets of powers
+// of two nanoseconds, safe for concurrent use.
+//
+// Its Observe method is a WriteWaitObserver.
+type WriteWaitHistogram struct {
+	buckets [65]uint64 // bucket i counts the waits of bits.Len64(wait) == i, access atomically
+}
+
+// Observe records wait in the histogram.
+func (h *WriteWaitHistogram) Observe(_ Message, wait time.Duration) {
+	if wait < 0 {
+		wait = 0
+	}
+	atomic.AddUint64(&h.buckets[bits.Len64(uint64(wait))], 1)
+}
+
+// Count returns the number of waits recorded.
+func (h *WriteWaitHistogram) Count() uint64 {
+	var n uint64
+	for i := range h.buckets {
+		n += atomic.LoadUint64(&h.buckets[i])
+	}
+	return n
+}
+
+// Percentile returns an upper bound of the given percentile of the recorded
+// waits, such as 0.99, or 0 if none was recorded.
+func (h *WriteWaitHistogram) Percentile(p float64) time.Duration {
+	var counts [len(h.buckets)]uint64
+	var total uint64
+	for i := range h.buckets {
+		counts[i] = atomic.LoadUint64(&h.buckets[i])
+		total += counts[i]
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := uint64(p * float64(total))
+	var seen uint64
+	for i, n := range counts {
+		seen += n
+		if seen > rank || seen == total {
+			switch {
+			case i == 0:
+				return 0
+			case i >= 63:
+				return time.Duration(1<<63 - 1)
+			}
+			return time.Duration(uint64(1)<<uint(i) - 1)
+		}
+	}
+	return 0
+}
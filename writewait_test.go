@@ -0,0 +1,113 @@
+// SPDX-FileCopyrightText: 2021 The Go Language Server Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonrpc2_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+func TestWriteWaitObserver(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string][]jsonrpc2.ConnOption{
+		"mutex":           nil,
+		"double buffered": {jsonrpc2.WithDoubleBufferedWrites()},
+	}
+	for name, opts := range tests {
+		opts := opts
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			var hist jsonrpc2.WriteWaitHistogram
+			a, b := pipeConns(t, append(opts, jsonrpc2.WithWriteWaitObserver(hist.Observe))...)
+			a.Go(ctx, jsonrpc2.MethodNotFoundHandler)
+			b.Go(ctx, func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+				return reply(ctx, req.Params(), nil)
+			})
+
+			const senders = 16
+			var wg sync.WaitGroup
+			for i := 0; i < senders; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					var got int
+					if _, err := a.Call(ctx, "echo", i, &got); err != nil || got != i {
+						t.Errorf("a.Call(...): got %d, %v, want %d", got, err, i)
+					}
+				}(i)
+			}
+			wg.Wait()
+
+			if got := hist.Count(); got != senders {
+				t.Errorf("hist.Count(): got %d, want %d", got, senders)
+			}
+			if p50, p100 := hist.Percentile(0.5), hist.Percentile(1); p50 > p100 || p100 > time.Minute {
+				t.Errorf("got percentiles 50: %v, 100: %v, want ordered bounds", p50, p100)
+			}
+		})
+	}
+}
+
+// gatedStream is a Stream whose writes of each method wait for its gate.
+type gatedStream struct {
+	jsonrpc2.Stream
+	started chan string
+	gates   map[string]chan struct{}
+}
+
+func (s *gatedStream) Write(ctx context.Context, msg jsonrpc2.Message) (int64, error) {
+	method := msg.(*jsonrpc2.Notification).Method()
+	s.started <- method
+	<-s.gates[method]
+	return 0, nil
+}
+
+func TestDoubleBufferedWritesHandOver(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	stream := &gatedStream{
+		started: make(chan string, 2),
+		gates:   map[string]chan struct{}{"first": make(chan struct{}), "second": make(chan struct{})},
+	}
+	conn := jsonrpc2.NewConn(stream, jsonrpc2.WithDoubleBufferedWrites())
+
+	notify := func(method string) <-chan error {
+		errc := make(chan error, 1)
+		go func() { errc <- conn.Notify(ctx, method, nil) }()
+		return errc
+	}
+	first := notify("first")
+	if got := <-stream.started; got != "first" {
+		t.Fatalf("got %q written, want first", got)
+	}
+	second := notify("second")
+	time.Sleep(10 * time.Millisecond) // let the second message be queued
+
+	// the writer of the first message returns once it is written, while the
+	// second one is still being written
+	close(stream.gates["first"])
+	if got := <-stream.started; got != "second" {
+		t.Fatalf("got %q written, want second", got)
+	}
+	select {
+	case err := <-first:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the first writer did not return before the next message was written")
+	}
+	close(stream.gates["second"])
+	if err := <-second; err != nil {
+		t.Fatal(err)
+	}
+}